@@ -0,0 +1,105 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Errors
+var (
+	ErrCertificateAuthorityDisabled = errors.New(
+		"the certificate authority is disabled")
+)
+
+const (
+	// certificateAuthorityClockSkew allows the issued certificate to be used
+	// on a remote host which clock is slightly behind
+	certificateAuthorityClockSkew = 1 * time.Minute
+)
+
+// CertificateRequest contains information of the certificate to be issued
+type CertificateRequest struct {
+	KeyID      string
+	Principals []string
+	Validity   time.Duration
+	Extensions map[string]string
+}
+
+// CertificateAuthority issues short-lived SSH user certificates
+type CertificateAuthority struct {
+	signer ssh.Signer
+}
+
+// NewCertificateAuthority creates a new CertificateAuthority. The
+// CertificateAuthority will be disabled if `signer` is nil
+func NewCertificateAuthority(signer ssh.Signer) *CertificateAuthority {
+	return &CertificateAuthority{
+		signer: signer,
+	}
+}
+
+// Enabled returns whether or not the CertificateAuthority is enabled
+func (c *CertificateAuthority) Enabled() bool {
+	return c != nil && c.signer != nil
+}
+
+// Issue generates a new ephemeral key pair, and returns a Signer of the key
+// which is signed with a user certificate issued according to `req`
+func (c *CertificateAuthority) Issue(
+	req CertificateRequest,
+) (ssh.Signer, error) {
+	if !c.Enabled() {
+		return nil, ErrCertificateAuthorityDisabled
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	serial := [8]byte{}
+	if _, err = rand.Read(serial[:]); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           req.KeyID,
+		ValidPrincipals: req.Principals,
+		ValidAfter:      uint64(now.Add(-certificateAuthorityClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(req.Validity).Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: req.Extensions,
+		},
+	}
+	if err = cert.SignCert(rand.Reader, c.signer); err != nil {
+		return nil, err
+	}
+	return ssh.NewCertSigner(cert, signer)
+}
@@ -0,0 +1,74 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCertificateAuthorityIssue(t *testing.T) {
+	ca := testSSHSigner(t)
+	c := NewCertificateAuthority(ca)
+	signer, err := c.Issue(CertificateRequest{
+		KeyID:      "test",
+		Principals: []string{"alice"},
+		Validity:   5 * time.Minute,
+		Extensions: map[string]string{"permit-pty": ""},
+	})
+	if err != nil {
+		t.Error("Unable to issue certificate:", err)
+		return
+	}
+	cert, isCert := signer.PublicKey().(*ssh.Certificate)
+	if !isCert {
+		t.Error("Expecting the signer to be signed with a certificate")
+		return
+	}
+	checker := ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), ca.PublicKey().Marshal())
+		},
+	}
+	if err := checker.CheckCert("alice", cert); err != nil {
+		t.Error("Expecting the certificate to be valid, got error:", err)
+		return
+	}
+	if err := checker.CheckCert("bob", cert); err == nil {
+		t.Error("Expecting the certificate to be invalid for other principals")
+		return
+	}
+	checker.Clock = func() time.Time {
+		return time.Now().Add(10 * time.Minute)
+	}
+	if err := checker.CheckCert("alice", cert); err == nil {
+		t.Error("Expecting the certificate to be expired")
+		return
+	}
+}
+
+func TestCertificateAuthorityDisabled(t *testing.T) {
+	_, err := NewCertificateAuthority(nil).Issue(CertificateRequest{})
+	if err != ErrCertificateAuthorityDisabled {
+		t.Errorf("Expecting error %v, got %v instead",
+			ErrCertificateAuthorityDisabled, err)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/nirui/sshwifty/application/configuration"
 	"github.com/nirui/sshwifty/application/log"
 	"github.com/nirui/sshwifty/application/network"
 	"github.com/nirui/sshwifty/application/rw"
@@ -29,9 +30,34 @@ import (
 
 // Configuration contains configuration data needed to run command
 type Configuration struct {
-	Dial        network.Dial
-	DialTimeout time.Duration
-	AuthRetries int
+	Dial                   network.Dial
+	PresetDial             network.Dial
+	DialTimeout            time.Duration
+	AuthRetries            int
+	Presets                []configuration.Preset
+	OnlyAllowPresetRemotes bool
+	KnownHosts             *KnownHosts
+	CertificateAuthority   *CertificateAuthority
+	WebUser                string
+	SSHSubsystems          []string
+	SSHKeepalive           configuration.SSHKeepaliveSettings
+	SSHAlgorithms          configuration.SSHAlgorithmSettings
+	SSHClients             *SSHClientPool
+	LocalShell             configuration.LocalShellSettings
+}
+
+// Preset returns the first Preset of type `presetType` which targets `host`
+func (c Configuration) Preset(
+	presetType string,
+	host string,
+) (configuration.Preset, bool) {
+	for i := range c.Presets {
+		if c.Presets[i].Type != presetType || c.Presets[i].Host != host {
+			continue
+		}
+		return c.Presets[i], true
+	}
+	return configuration.Preset{}, false
 }
 
 // Commander command control
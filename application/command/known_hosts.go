@@ -0,0 +1,153 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/nirui/sshwifty/application/configuration"
+)
+
+// Errors
+var (
+	ErrKnownHostsUnknownHost = errors.New(
+		"the host is not in the known hosts database")
+
+	ErrKnownHostsMismatchedKey = errors.New(
+		"the host key does not match the one in the known hosts database, " +
+			"the host may have been impersonated")
+)
+
+const (
+	knownHostsFilePermission = 0600
+)
+
+// KnownHosts is a server-side database of the known SSH host keys, stored in
+// the OpenSSH `known_hosts` format
+type KnownHosts struct {
+	cfg  configuration.KnownHostsSettings
+	lock sync.Mutex
+}
+
+// NewKnownHosts creates a new KnownHosts
+func NewKnownHosts(cfg configuration.KnownHostsSettings) *KnownHosts {
+	return &KnownHosts{
+		cfg:  cfg,
+		lock: sync.Mutex{},
+	}
+}
+
+// Enabled returns whether or not the database is enabled
+func (k *KnownHosts) Enabled() bool {
+	return k != nil && k.cfg.Enabled()
+}
+
+// AllowUnknown returns whether or not the user can be asked to confirm the key
+// of a host that is unknown to the database
+func (k *KnownHosts) AllowUnknown() bool {
+	return k.cfg.AllowUnknown
+}
+
+// Persistent returns whether or not the user confirmed key should be saved
+// into the database
+func (k *KnownHosts) Persistent() bool {
+	return k.cfg.Persist
+}
+
+// Verify verifies the `key` of the host `hostname` (in "host:port" format).
+// It returns nil when the key is known to the database,
+// ErrKnownHostsUnknownHost when the host is not in the database,
+// ErrKnownHostsMismatchedKey when the host is known with a different key, or
+// other error when the key is otherwise refused (i.e. revoked)
+//
+// The database file is reloaded on every verification, so changes made to it
+// will take effect without restarting
+func (k *KnownHosts) Verify(hostname string, key ssh.PublicKey) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	callback, err := knownhosts.New(k.cfg.File)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrKnownHostsUnknownHost
+		}
+		return fmt.Errorf("unable to load known hosts database: %s", err)
+	}
+	// The remote address is only used when `hostname` is not given, and the
+	// real one is not always a TCP address (i.e. when dialed through a proxy)
+	remote := &net.TCPAddr{}
+	err = knownHostsError(callback(hostname, remote, key))
+	cert, isCert := key.(*ssh.Certificate)
+	if err == nil || !isCert {
+		return err
+	}
+	// Host certificate that is not signed by a known authority, verify the
+	// key of the certificate instead, just like what OpenSSH does
+	return knownHostsError(callback(hostname, remote, cert.Key))
+}
+
+// knownHostsError converts errors returned by knownhosts
+func knownHostsError(err error) error {
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return err
+	}
+	if len(keyErr.Want) <= 0 {
+		return ErrKnownHostsUnknownHost
+	}
+	return ErrKnownHostsMismatchedKey
+}
+
+// Add saves the `key` of the host `hostname` (in "host:port" format) into the
+// database
+func (k *KnownHosts) Add(hostname string, key ssh.PublicKey) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if cert, isCert := key.(*ssh.Certificate); isCert {
+		key = cert.Key
+	}
+	f, err := os.OpenFile(
+		k.cfg.File,
+		os.O_RDWR|os.O_CREATE|os.O_APPEND,
+		knownHostsFilePermission,
+	)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	// Make sure the new entry is written on it's own line
+	lastByte := [1]byte{'\n'}
+	if s, sErr := f.Seek(-1, io.SeekEnd); sErr == nil && s >= 0 {
+		if _, rErr := io.ReadFull(f, lastByte[:]); rErr != nil {
+			return rErr
+		}
+	}
+	if lastByte[0] != '\n' {
+		line = "\n" + line
+	}
+	_, err = f.WriteString(line + "\n")
+	return err
+}
@@ -0,0 +1,154 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/nirui/sshwifty/application/configuration"
+)
+
+func testSSHSigner(t *testing.T) ssh.Signer {
+	_, k, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("Unable to generate key:", err)
+	}
+	s, err := ssh.NewSignerFromKey(k)
+	if err != nil {
+		t.Fatal("Unable to create signer:", err)
+	}
+	return s
+}
+
+func TestKnownHostsVerify(t *testing.T) {
+	known := testSSHSigner(t).PublicKey()
+	hashed := testSSHSigner(t).PublicKey()
+	other := testSSHSigner(t).PublicKey()
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	err := os.WriteFile(file, []byte(
+		knownhosts.Line([]string{"known.example.com"}, known)+"\n"+
+			knownhosts.Line([]string{
+				knownhosts.HashHostname("[hashed.example.com]:2222"),
+			}, hashed)+"\n",
+	), 0600)
+	if err != nil {
+		t.Fatal("Unable to write known hosts file:", err)
+	}
+	k := NewKnownHosts(configuration.KnownHostsSettings{File: file})
+	tests := []struct {
+		host     string
+		key      ssh.PublicKey
+		expected error
+	}{
+		{"known.example.com:22", known, nil},
+		{"known.example.com:22", other, ErrKnownHostsMismatchedKey},
+		{"known.example.com:2222", known, ErrKnownHostsUnknownHost},
+		{"hashed.example.com:2222", hashed, nil},
+		{"hashed.example.com:2222", other, ErrKnownHostsMismatchedKey},
+		{"unknown.example.com:22", other, ErrKnownHostsUnknownHost},
+	}
+	for i, test := range tests {
+		if err := k.Verify(test.host, test.key); err != test.expected {
+			t.Errorf("Test %d: Expecting error %v, got %v instead",
+				i, test.expected, err)
+		}
+	}
+}
+
+func TestKnownHostsVerifyCertAuthority(t *testing.T) {
+	ca := testSSHSigner(t)
+	host := testSSHSigner(t)
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	err := os.WriteFile(file, []byte("@cert-authority *.example.com "+
+		string(ssh.MarshalAuthorizedKey(ca.PublicKey()))), 0600)
+	if err != nil {
+		t.Fatal("Unable to write known hosts file:", err)
+	}
+	cert := &ssh.Certificate{
+		Key:             host.PublicKey(),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"host.example.com"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal("Unable to sign certificate:", err)
+	}
+	k := NewKnownHosts(configuration.KnownHostsSettings{File: file})
+	if err := k.Verify("host.example.com:22", cert); err != nil {
+		t.Error("Expecting the certificate to be accepted, got error:", err)
+	}
+	// Hosts covered by an authority must present a certificate signed by it
+	err = k.Verify("host.example.com:22", host.PublicKey())
+	if err != ErrKnownHostsMismatchedKey {
+		t.Errorf("Expecting error %v, got %v instead",
+			ErrKnownHostsMismatchedKey, err)
+	}
+}
+
+func TestKnownHostsAdd(t *testing.T) {
+	key := testSSHSigner(t).PublicKey()
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	err := os.WriteFile(file, []byte("# No new line at the end"), 0600)
+	if err != nil {
+		t.Fatal("Unable to write known hosts file:", err)
+	}
+	k := NewKnownHosts(configuration.KnownHostsSettings{File: file})
+	err = k.Verify("new.example.com:22", key)
+	if err != ErrKnownHostsUnknownHost {
+		t.Errorf("Expecting error %v, got %v instead",
+			ErrKnownHostsUnknownHost, err)
+		return
+	}
+	if err := k.Add("new.example.com:22", key); err != nil {
+		t.Error("Unable to add host key:", err)
+		return
+	}
+	if err := k.Verify("new.example.com:22", key); err != nil {
+		t.Error("Expecting the added host key to be accepted, got error:", err)
+	}
+}
+
+func TestKnownHostsVerifyCertOfKnownKey(t *testing.T) {
+	ca := testSSHSigner(t)
+	host := testSSHSigner(t)
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	err := os.WriteFile(file, []byte(knownhosts.Line(
+		[]string{"host.example.com"}, host.PublicKey())+"\n"), 0600)
+	if err != nil {
+		t.Fatal("Unable to write known hosts file:", err)
+	}
+	cert := &ssh.Certificate{
+		Key:         host.PublicKey(),
+		CertType:    ssh.HostCert,
+		ValidBefore: ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal("Unable to sign certificate:", err)
+	}
+	k := NewKnownHosts(configuration.KnownHostsSettings{File: file})
+	if err := k.Verify("host.example.com:22", cert); err != nil {
+		t.Error("Expecting the certificate to be accepted, got error:", err)
+	}
+}
@@ -0,0 +1,122 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+import (
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshPooledClient is a SSH client in the SSHClientPool
+type sshPooledClient struct {
+	client *ssh.Client
+	ready  func()
+	refs   int
+}
+
+// SSHClientPool shares connected SSH clients among the streams of the same
+// connection, so new streams to the same remote can open their sessions on
+// the existing client instead of connecting and authenticating again.
+//
+// Clients are reference counted, and will be closed when the last stream that
+// uses it has released it
+type SSHClientPool struct {
+	lock    sync.Mutex
+	clients map[string]*sshPooledClient
+}
+
+// NewSSHClientPool creates a new SSHClientPool
+func NewSSHClientPool() *SSHClientPool {
+	return &SSHClientPool{
+		lock:    sync.Mutex{},
+		clients: make(map[string]*sshPooledClient),
+	}
+}
+
+// Get returns the client of given `key`, the function to release it, and the
+// `ready` function the client was Put with. The client must be released once
+// it's no longer used
+func (p *SSHClientPool) Get(key string) (*ssh.Client, func(), func(), bool) {
+	if p == nil {
+		return nil, nil, nil, false
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	c, ok := p.clients[key]
+	if !ok {
+		return nil, nil, nil, false
+	}
+	c.refs++
+	return c.client, p.releaser(key, c), c.ready, true
+}
+
+// Put adds the `client` into the pool under the given `key`, and returns the
+// function to release it. If the `key` is already taken by another client,
+// the `client` will not be shared and will be closed once it's released.
+//
+// The `ready` function finishes the setup of the client, and is called by
+// every stream that has started using the client successfully. As the stream
+// that Put the client may fail before that, `ready` must be safe to be called
+// more than once, and must only take effect on the first call
+func (p *SSHClientPool) Put(
+	key string,
+	client *ssh.Client,
+	ready func(),
+) func() {
+	if p == nil {
+		return sync.OnceFunc(func() { client.Close() })
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	c := &sshPooledClient{client: client, ready: ready, refs: 1}
+	if _, ok := p.clients[key]; ok {
+		return p.releaser("", c)
+	}
+	p.clients[key] = c
+	go func() {
+		// Remove the disconnected client so it will not be used by new streams
+		client.Wait()
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		p.remove(key, c)
+	}()
+	return p.releaser(key, c)
+}
+
+// remove deletes `c` from the pool. Must be called with the lock held
+func (p *SSHClientPool) remove(key string, c *sshPooledClient) {
+	if p.clients[key] == c {
+		delete(p.clients, key)
+	}
+}
+
+// releaser returns a function that releases one reference of `c`. The
+// function does nothing when called again
+func (p *SSHClientPool) releaser(key string, c *sshPooledClient) func() {
+	return sync.OnceFunc(func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		c.refs--
+		if c.refs > 0 {
+			return
+		}
+		p.remove(key, c)
+		c.client.Close()
+	})
+}
@@ -0,0 +1,144 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func testSSHPoolClient(t *testing.T) *ssh.Client {
+	serverCfg := &ssh.ServerConfig{NoClientAuth: true}
+	serverCfg.AddHostKey(testSSHSigner(t))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unable to listen:", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(conn, serverCfg)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newChan := range chans {
+			newChan.Reject(ssh.Prohibited, "")
+		}
+	}()
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal("Unable to connect:", err)
+	}
+	return client
+}
+
+func testSSHPoolClientClosed(c *ssh.Client) bool {
+	closed := make(chan struct{})
+	go func() {
+		c.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		return true
+	case <-time.After(100 * time.Millisecond):
+		return false
+	}
+}
+
+func TestSSHClientPool(t *testing.T) {
+	p := NewSSHClientPool()
+	client := testSSHPoolClient(t)
+	readied := 0
+	release1 := p.Put("user@host", client, func() { readied++ })
+	shared, release2, ready, ok := p.Get("user@host")
+	if !ok || shared != client {
+		t.Error("Expecting the client to be shared")
+		return
+	}
+	if ready(); readied != 1 {
+		t.Error("Expecting the ready function of the client to be returned")
+		return
+	}
+	if _, _, _, ok := p.Get("other@host"); ok {
+		t.Error("Expecting no client for an unknown key")
+		return
+	}
+	release1()
+	release1()
+	if testSSHPoolClientClosed(client) {
+		t.Error("Client must not be closed while it's still being used")
+		return
+	}
+	release2()
+	if !testSSHPoolClientClosed(client) {
+		t.Error("Client must be closed after being released by all users")
+		return
+	}
+	if _, _, _, ok := p.Get("user@host"); ok {
+		t.Error("Released client must be removed from the pool")
+		return
+	}
+}
+
+func TestSSHClientPoolDuplicatedKey(t *testing.T) {
+	p := NewSSHClientPool()
+	client1 := testSSHPoolClient(t)
+	client2 := testSSHPoolClient(t)
+	release1 := p.Put("user@host", client1, func() {})
+	defer release1()
+	release2 := p.Put("user@host", client2, func() {})
+	shared, release, _, ok := p.Get("user@host")
+	if !ok || shared != client1 {
+		t.Error("Expecting the first client to be kept in the pool")
+		return
+	}
+	release()
+	release2()
+	if !testSSHPoolClientClosed(client2) {
+		t.Error("Unshared client must be closed once it's released")
+		return
+	}
+}
+
+func TestSSHClientPoolDisconnected(t *testing.T) {
+	p := NewSSHClientPool()
+	client := testSSHPoolClient(t)
+	release := p.Put("user@host", client, func() {})
+	defer release()
+	client.Close()
+	for range 100 {
+		_, r, _, ok := p.Get("user@host")
+		if !ok {
+			return
+		}
+		r()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Disconnected client must be removed from the pool")
+}
@@ -24,5 +24,11 @@ func New() command.Commands {
 	return command.Commands{
 		command.Register("Telnet", newTelnet, parseTelnetConfig),
 		command.Register("SSH", newSSH, parseSSHConfig),
+		command.Register("SFTP", newSFTP, parseSSHConfig),
+		command.Register("SSH Tunnel", newSSHTunnel, parseSSHTunnelConfig),
+		command.Register("TCP", newTCP, parseTCPConfig),
+		command.Register("TLS", newTLSClient, parseTLSClientConfig),
+		command.Register("Local Shell", newLocalShell, parseLocalShellConfig),
+		command.Register("Serial", newSerial, parseSerialConfig),
 	}
 }
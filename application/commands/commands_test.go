@@ -0,0 +1,131 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/rw"
+)
+
+// testStream runs a command on a stream of the command.Handler, and talks to
+// it the same way the client does
+type testStream struct {
+	t      *testing.T
+	input  chan []byte
+	output net.Conn
+	header command.Header
+	buf    []byte
+}
+
+// testCommandStream starts the command of `id` with the `bootup` data, and
+// fails the test unless the command has been started successfully
+func testCommandStream(
+	t *testing.T,
+	cfg command.Configuration,
+	id byte,
+	bootup []byte,
+) *testStream {
+	input := make(chan []byte, 16)
+	output, sender := net.Pipe()
+	bufferPool := command.NewBufferPool(4096)
+	handler, err := command.New(New()).New(
+		cfg,
+		rw.NewFetchReader(func() ([]byte, error) {
+			d, ok := <-input
+			if !ok {
+				return nil, io.EOF
+			}
+			return d, nil
+		}),
+		sender,
+		&sync.Mutex{},
+		0,
+		0,
+		log.NewDitch(),
+		command.NewHooks(configuration.HookSettings{}),
+		&bufferPool,
+	)
+	if err != nil {
+		t.Fatal("Unable to create handler:", err)
+	}
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		handler.Handle()
+	}()
+	t.Cleanup(func() {
+		// Data written after this point will be discarded
+		output.Close()
+		close(input)
+		<-handled
+		sender.Close()
+	})
+	s := &testStream{
+		t:      t,
+		input:  input,
+		output: output,
+		header: command.HeaderStream,
+		buf:    make([]byte, command.StreamHeaderMaxLength),
+	}
+	// The initial stream header: 4 bits of command ID, 1 bit of success flag,
+	// and 11 bits of data length
+	s.input <- append([]byte{
+		byte(s.header), id<<4 | 0x08 | byte(len(bootup)>>8), byte(len(bootup)),
+	}, bootup...)
+	h := s.read(3)
+	if h[0] != byte(s.header) || h[1]&0x08 == 0 {
+		t.Fatalf("Unable to start command %d: %v", id, h)
+	}
+	return s
+}
+
+// read reads `n` bytes of data sent by the handler
+func (s *testStream) read(n int) []byte {
+	s.output.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(s.output, s.buf[:n]); err != nil {
+		s.t.Fatal("Unable to receive data:", err)
+	}
+	return s.buf[:n]
+}
+
+// send sends `data` to the command under the stream `marker`
+func (s *testStream) send(marker byte, data []byte) {
+	h := command.StreamHeader{}
+	h.Set(marker, uint16(len(data)))
+	s.input <- append([]byte{byte(s.header), h[0], h[1]}, data...)
+}
+
+// receive returns the next data sent by the command and it's stream marker.
+// The returned data is only valid until the next call
+func (s *testStream) receive() (byte, []byte) {
+	hd := s.read(1)
+	if command.Header(hd[0]) != s.header {
+		s.t.Fatalf("Expecting stream data, got header %d instead", hd[0])
+	}
+	h := command.StreamHeader{}
+	copy(h[:], s.read(2))
+	return h.Marker(), s.read(int(h.Length()))
+}
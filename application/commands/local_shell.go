@@ -0,0 +1,453 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/rw"
+)
+
+// Errors
+var (
+	ErrLocalShellUnableToReceiveProcess = errors.New(
+		"unable to acquire local shell process")
+
+	ErrLocalShellDisabled = errors.New(
+		"local shell is disabled")
+
+	ErrLocalShellCommandNotAllowed = errors.New(
+		"the command is not allowed")
+
+	ErrLocalShellUnsupported = errors.New(
+		"local shell is not supported on this platform")
+
+	ErrLocalShellUnknownClientSignal = errors.New(
+		"unknown client signal")
+)
+
+// Error codes
+const (
+	LocalShellRequestErrorBadCommand        = command.StreamError(0x01)
+	LocalShellRequestErrorCommandNotAllowed = command.StreamError(0x02)
+	LocalShellRequestErrorBadTerminalSize   = command.StreamError(0x03)
+)
+
+const (
+	localShellType                  = "Local Shell"
+	localShellTermType              = "xterm-256color"
+	localShellMaxCommandLen         = 1024
+	localShellInheritedLocalePrefix = "LC_"
+)
+
+// localShellInheritedEnvirons are the environment variables that the local
+// shell inherits from Sshwifty
+var localShellInheritedEnvirons = []string{"PATH", "LANG", "SHELL"}
+
+// Server signal codes
+const (
+	LocalShellServerOutput                     = 0x00
+	LocalShellServerHookOutputBeforeConnecting = 0x01
+	LocalShellServerStartFailed                = 0x02
+	LocalShellServerStarted                    = 0x03
+	LocalShellServerExitStatus                 = 0x04
+)
+
+// Client signal codes
+const (
+	LocalShellClientInput  = 0x00
+	LocalShellClientResize = 0x01
+)
+
+// localShellPTY is the pseudo terminal that a local shell runs in
+type localShellPTY interface {
+	io.ReadWriteCloser
+
+	// Resize changes the size of the pseudo terminal
+	Resize(rows uint16, cols uint16) error
+}
+
+// localShellProcess is a running local shell
+type localShellProcess struct {
+	pty localShellPTY
+	cmd *exec.Cmd
+}
+
+// close closes the pseudo terminal, which hangs up the shell, then kills the
+// shell in case it ignores the hang up
+func (p *localShellProcess) close() {
+	p.pty.Close()
+	p.cmd.Process.Kill()
+}
+
+// localShell runs one of the allowed commands inside a pseudo terminal on the
+// host which runs Sshwifty
+type localShell struct {
+	l             log.Logger
+	hooks         command.Hooks
+	w             command.StreamResponder
+	cfg           command.Configuration
+	bufferPool    *command.BufferPool
+	baseCtx       context.Context
+	baseCtxCancel func()
+	processChan   chan *localShellProcess
+	process       *localShellProcess
+	closeWait     sync.WaitGroup
+}
+
+func newLocalShell(
+	l log.Logger,
+	hooks command.Hooks,
+	w command.StreamResponder,
+	cfg command.Configuration,
+	bufferPool *command.BufferPool,
+) command.FSMMachine {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
+	return &localShell{
+		l:             l,
+		hooks:         hooks,
+		w:             w,
+		cfg:           cfg,
+		bufferPool:    bufferPool,
+		baseCtx:       ctx,
+		baseCtxCancel: sync.OnceFunc(ctxCancel),
+		processChan:   make(chan *localShellProcess, 1),
+		process:       nil,
+		closeWait:     sync.WaitGroup{},
+	}
+}
+
+func parseLocalShellConfig(
+	p configuration.Preset,
+) (configuration.Preset, error) {
+	return p, nil
+}
+
+// localShellEnviron returns the environment variables of the shell that runs
+// as `runAs`. Other than the locale, the search path and the default shell,
+// nothing is inherited from Sshwifty, as it's environment variables may carry
+// secrets (i.e. the ones referenced by the Presets)
+func localShellEnviron(runAs *user.User) []string {
+	envs := make([]string, 0, 16)
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		if slices.Contains(localShellInheritedEnvirons, name) ||
+			strings.HasPrefix(name, localShellInheritedLocalePrefix) {
+			envs = append(envs, env)
+		}
+	}
+	return append(envs,
+		"TERM="+localShellTermType,
+		"HOME="+runAs.HomeDir,
+		"USER="+runAs.Username,
+		"LOGNAME="+runAs.Username,
+	)
+}
+
+// Bootup starts the local shell
+//
+// Bootup data format:
+// +---------+---------+---------+
+// | String  | 2 bytes | 2 bytes |
+// +---------+---------+---------+
+// | Command | Rows    | Cols    |
+// +---------+---------+---------+
+//
+// The Command must be exactly one of the allowed command lines
+func (d *localShell) Bootup(
+	r *rw.LimitedReader,
+	b []byte,
+) (command.FSMState, command.FSMError) {
+	if !d.cfg.LocalShell.Enabled() {
+		return nil, command.ToFSMError(
+			ErrLocalShellDisabled, LocalShellRequestErrorCommandNotAllowed)
+	}
+
+	sBuf := d.bufferPool.Get()
+	defer d.bufferPool.Put(sBuf)
+
+	commandLine, _, err := ParseString(
+		r.Read, (*sBuf)[:localShellMaxCommandLen])
+	if err != nil {
+		return nil, command.ToFSMError(
+			err, LocalShellRequestErrorBadCommand)
+	}
+
+	commandLineStr := string(commandLine.Data())
+
+	if !d.cfg.LocalShell.Allowed(commandLineStr) {
+		return nil, command.ToFSMError(
+			ErrLocalShellCommandNotAllowed,
+			LocalShellRequestErrorCommandNotAllowed)
+	}
+
+	_, err = io.ReadFull(r, b[:4])
+	if err != nil {
+		return nil, command.ToFSMError(
+			err, LocalShellRequestErrorBadTerminalSize)
+	}
+
+	rows := binary.BigEndian.Uint16(b[0:2])
+	cols := binary.BigEndian.Uint16(b[2:4])
+
+	d.closeWait.Add(1)
+	go d.remote(commandLineStr, rows, cols)
+
+	return d.client, command.NoFSMError()
+}
+
+// runAs returns the user that the shell runs as
+func (d *localShell) runAs() (*user.User, error) {
+	if len(d.cfg.LocalShell.User) <= 0 {
+		return user.Current()
+	}
+
+	return user.Lookup(d.cfg.LocalShell.User)
+}
+
+// start starts the `commandLine` in a pseudo terminal of the given size
+func (d *localShell) start(
+	commandLine string,
+	rows uint16,
+	cols uint16,
+) (*localShellProcess, error) {
+	runAs, err := d.runAs()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(commandLine)
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.Env = localShellEnviron(runAs)
+
+	// Users such as the system ones may not have a home directory, start
+	// from the current working directory in that case
+	if info, err := os.Stat(runAs.HomeDir); err == nil && info.IsDir() {
+		cmd.Dir = runAs.HomeDir
+	}
+
+	pty, err := startLocalShell(cmd, runAs, rows, cols)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localShellProcess{pty: pty, cmd: cmd}, nil
+}
+
+// sendStartFailed sends given `err` to the client through the
+// LocalShellServerStartFailed signal
+func (d *localShell) sendStartFailed(b []byte, err error) error {
+	errLen := copy(b[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
+
+	return d.w.SendManual(LocalShellServerStartFailed, b[:errLen])
+}
+
+// sendExitStatus sends the exit status of the shell to the client
+//
+// Exit status format:
+// +---------+---------+
+// | 4 bytes | n bytes |
+// +---------+---------+
+// | Code    | Status  |
+// +---------+---------+
+//
+// The Code is a signed integer, which is -1 if the shell was terminated by a
+// signal. The Status describes the exit status in a readable way
+func (d *localShell) sendExitStatus(b []byte, s *os.ProcessState) error {
+	start := d.w.HeaderSize()
+
+	binary.BigEndian.PutUint32(b[start:], uint32(int32(s.ExitCode())))
+	start += 4
+	start += copy(b[start:], s.String())
+
+	return d.w.SendManual(LocalShellServerExitStatus, b[:start])
+}
+
+func (d *localShell) remote(commandLine string, rows uint16, cols uint16) {
+	u := d.bufferPool.Get()
+	defer d.bufferPool.Put(u)
+
+	defer func() {
+		d.w.Signal(command.HeaderClose)
+		close(d.processChan)
+		d.baseCtxCancel()
+		d.closeWait.Done()
+	}()
+
+	err := d.hooks.Run(
+		d.baseCtx,
+		configuration.HOOK_BEFORE_CONNECTING,
+		command.NewHookParameters(2).
+			Insert("Remote Type", localShellType).
+			Insert("Remote Address", commandLine),
+		command.NewDefaultHookOutput(d.l, func(
+			b []byte,
+		) (wLen int, wErr error) {
+			wLen = len(b)
+			dLen := copy((*u)[d.w.HeaderSize():], b) + d.w.HeaderSize()
+			wErr = d.w.SendManual(
+				LocalShellServerHookOutputBeforeConnecting,
+				(*u)[:dLen],
+			)
+			return
+		}),
+	)
+	if err != nil {
+		d.sendStartFailed(*u, err)
+		return
+	}
+
+	process, err := d.start(commandLine, rows, cols)
+	if err != nil {
+		d.l.Debug("Unable to start local shell: %s", err)
+		d.sendStartFailed(*u, err)
+		return
+	}
+	defer process.close()
+
+	err = d.w.SendManual(LocalShellServerStarted, (*u)[:d.w.HeaderSize()])
+	if err != nil {
+		return
+	}
+
+	d.processChan <- process
+
+	for {
+		rLen, err := process.pty.Read((*u)[d.w.HeaderSize():])
+		if err != nil {
+			break
+		}
+
+		wErr := d.w.SendManual(
+			LocalShellServerOutput, (*u)[:rLen+d.w.HeaderSize()])
+		if wErr != nil {
+			break
+		}
+	}
+
+	// The output ends when the shell exits or the pseudo terminal is closed,
+	// make sure the shell is gone in the latter case before waiting for it
+	process.close()
+	process.cmd.Wait()
+
+	if process.cmd.ProcessState == nil {
+		return
+	}
+
+	d.sendExitStatus(*u, process.cmd.ProcessState)
+}
+
+func (d *localShell) getProcess() (*localShellProcess, error) {
+	if d.process != nil {
+		return d.process, nil
+	}
+
+	process, ok := <-d.processChan
+	if !ok {
+		return nil, ErrLocalShellUnableToReceiveProcess
+	}
+	d.process = process
+
+	return d.process, nil
+}
+
+func (d *localShell) client(
+	f *command.FSM,
+	r *rw.LimitedReader,
+	h command.StreamHeader,
+	b []byte,
+) error {
+	switch h.Marker() {
+	case LocalShellClientInput:
+		process, err := d.getProcess()
+		if err != nil {
+			return err
+		}
+
+		for !r.Completed() {
+			rBuf, rErr := r.Buffered()
+			if rErr != nil {
+				return rErr
+			}
+
+			_, wErr := process.pty.Write(rBuf)
+			if wErr != nil {
+				process.close()
+				d.l.Debug("Failed to write data to local shell: %s", wErr)
+			}
+		}
+
+		return nil
+
+	case LocalShellClientResize:
+		process, err := d.getProcess()
+		if err != nil {
+			return err
+		}
+
+		_, err = io.ReadFull(r, b[:4])
+		if err != nil {
+			return err
+		}
+
+		rows := binary.BigEndian.Uint16(b[0:2])
+		cols := binary.BigEndian.Uint16(b[2:4])
+
+		// It's ok for it to fail
+		err = process.pty.Resize(rows, cols)
+		if err != nil {
+			d.l.Debug("Failed to resize local shell: %s", err)
+		}
+
+		return nil
+
+	default:
+		return ErrLocalShellUnknownClientSignal
+	}
+}
+
+func (d *localShell) Close() error {
+	process, err := d.getProcess()
+	if err == nil {
+		process.close()
+	}
+
+	d.baseCtxCancel()
+	d.closeWait.Wait()
+
+	return nil
+}
+
+func (d *localShell) Release() error {
+	d.baseCtxCancel()
+
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package commands
+
+import (
+	"os/exec"
+	"os/user"
+)
+
+// startLocalShell always fails, as pseudo terminal is only supported on Linux
+func startLocalShell(
+	cmd *exec.Cmd,
+	runAs *user.User,
+	rows uint16,
+	cols uint16,
+) (localShellPTY, error) {
+	return nil, ErrLocalShellUnsupported
+}
@@ -0,0 +1,149 @@
+//go:build linux
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// localShellPTYFile is the master side of a pseudo terminal
+type localShellPTYFile struct {
+	*os.File
+}
+
+// control runs `f` with the file descriptor of the pseudo terminal without
+// putting it into blocking mode, so Close can still interrupt a Read
+func (p localShellPTYFile) control(f func(fd int) error) error {
+	conn, err := p.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fErr error
+	err = conn.Control(func(fd uintptr) {
+		fErr = f(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	return fErr
+}
+
+// Resize changes the size of the pseudo terminal
+func (p localShellPTYFile) Resize(rows uint16, cols uint16) error {
+	return p.control(func(fd int) error {
+		return unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, &unix.Winsize{
+			Row: rows,
+			Col: cols,
+		})
+	})
+}
+
+// openLocalShellPTY opens a new pseudo terminal, returns both its master and
+// slave side
+func openLocalShellPTY() (localShellPTYFile, *os.File, error) {
+	f, err := os.OpenFile(
+		"/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return localShellPTYFile{}, nil, err
+	}
+	master := localShellPTYFile{File: f}
+	var n uint32
+	err = master.control(func(fd int) error {
+		if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+			return err
+		}
+		n, err = unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+		return err
+	})
+	if err != nil {
+		master.Close()
+		return localShellPTYFile{}, nil, err
+	}
+	slave, err := os.OpenFile(
+		"/dev/pts/"+strconv.FormatUint(uint64(n), 10),
+		os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC,
+		0,
+	)
+	if err != nil {
+		master.Close()
+		return localShellPTYFile{}, nil, err
+	}
+	return master, slave, nil
+}
+
+// localShellCredential returns the credential needed to run as `u`, or nil if
+// `u` is the current user
+func localShellCredential(u *user.User) (*syscall.Credential, error) {
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UID %q: %s", u.Uid, err)
+	}
+	if int(uid) == os.Getuid() {
+		return nil, nil
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GID %q: %s", u.Gid, err)
+	}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]uint32, 0, len(groupIDs))
+	for _, g := range groupIDs {
+		id, err := strconv.ParseUint(g, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GID %q: %s", g, err)
+		}
+		groups = append(groups, uint32(id))
+	}
+	return &syscall.Credential{
+		Uid:    uint32(uid),
+		Gid:    uint32(gid),
+		Groups: groups,
+	}, nil
+}
+
+// startLocalShell starts `cmd` as `runAs` inside a new pseudo terminal of the
+// given size. The pseudo terminal becomes the controlling terminal of `cmd`
+func startLocalShell(
+	cmd *exec.Cmd,
+	runAs *user.User,
+	rows uint16,
+	cols uint16,
+) (localShellPTY, error) {
+	credential, err := localShellCredential(runAs)
+	if err != nil {
+		return nil, err
+	}
+	master, slave, err := openLocalShellPTY()
+	if err != nil {
+		return nil, fmt.Errorf("unable to open pseudo terminal: %s", err)
+	}
+	// The slave is only needed by the shell, closing our copy of it allows
+	// the master to be notified once the shell exits
+	defer slave.Close()
+	if err := master.Resize(rows, cols); err != nil {
+		master.Close()
+		return nil, err
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid:     true,
+		Setctty:    true,
+		Ctty:       0,
+		Credential: credential,
+	}
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, err
+	}
+	return master, nil
+}
@@ -0,0 +1,151 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalShellEnviron(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin:/bin")
+	t.Setenv("LANG", "C.UTF-8")
+	t.Setenv("LC_TIME", "C")
+	t.Setenv("TERM", "dumb")
+	t.Setenv("MY_SERVER_PASSWORD", "secret")
+	t.Setenv("SSHWIFTY_SHAREDKEY", "secret")
+	runAs := &user.User{Username: "ops", HomeDir: "/home/ops"}
+	envs := localShellEnviron(runAs)
+	for _, expected := range []string{
+		"PATH=/usr/bin:/bin",
+		"LANG=C.UTF-8",
+		"LC_TIME=C",
+		"TERM=" + localShellTermType,
+		"HOME=/home/ops",
+		"USER=ops",
+		"LOGNAME=ops",
+	} {
+		name, _, _ := strings.Cut(expected, "=")
+		found := slices.DeleteFunc(slices.Clone(envs), func(e string) bool {
+			return !strings.HasPrefix(e, name+"=")
+		})
+		if len(found) != 1 || found[0] != expected {
+			t.Errorf("Expecting %q, got %q instead", expected, found)
+			return
+		}
+	}
+	for _, env := range envs {
+		if strings.Contains(env, "secret") {
+			t.Errorf("Expecting %q to be left out", env)
+			return
+		}
+	}
+}
+
+func testLocalShell(
+	t *testing.T,
+	script string,
+	rows uint16,
+	cols uint16,
+) (string, *exec.Cmd) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Pseudo terminal is only supported on Linux")
+	}
+	runAs, err := user.Current()
+	if err != nil {
+		t.Fatal("Failed to get current user:", err)
+	}
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Env = localShellEnviron(runAs)
+	pty, err := startLocalShell(cmd, runAs, rows, cols)
+	if err != nil {
+		t.Fatal("Failed to start:", err)
+	}
+	defer pty.Close()
+	timer := time.AfterFunc(5*time.Second, func() { pty.Close() })
+	defer timer.Stop()
+	output := bytes.Buffer{}
+	buf := make([]byte, 1024)
+	for {
+		n, err := pty.Read(buf)
+		output.Write(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+	cmd.Wait()
+	return output.String(), cmd
+}
+
+func TestStartLocalShell(t *testing.T) {
+	output, cmd := testLocalShell(
+		t, "stty size; tty >/dev/null && echo $TERM; exit 3", 33, 77)
+	if !strings.Contains(output, "33 77") {
+		t.Errorf("Expecting terminal size \"33 77\", got %q", output)
+		return
+	}
+	if !strings.Contains(output, localShellTermType) {
+		t.Errorf("Expecting the shell to run in a terminal, got %q", output)
+		return
+	}
+	if cmd.ProcessState.ExitCode() != 3 {
+		t.Errorf("Expecting exit code 3, got %d instead",
+			cmd.ProcessState.ExitCode())
+		return
+	}
+}
+
+func TestStartLocalShellResize(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Pseudo terminal is only supported on Linux")
+	}
+	runAs, err := user.Current()
+	if err != nil {
+		t.Fatal("Failed to get current user:", err)
+	}
+	cmd := exec.Command("/bin/sh", "-c", "read line; stty size")
+	pty, err := startLocalShell(cmd, runAs, 24, 80)
+	if err != nil {
+		t.Error("Failed to start:", err)
+		return
+	}
+	defer pty.Close()
+	if err := pty.Resize(50, 120); err != nil {
+		t.Error("Failed to resize:", err)
+		return
+	}
+	pty.Write([]byte("\n"))
+	output := bytes.Buffer{}
+	buf := make([]byte, 1024)
+	for !strings.Contains(output.String(), "50 120") {
+		n, err := pty.Read(buf)
+		output.Write(buf[:n])
+		if err != nil {
+			t.Errorf("Expecting terminal size \"50 120\", got %q",
+				output.String())
+			return
+		}
+	}
+	cmd.Wait()
+}
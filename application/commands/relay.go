@@ -0,0 +1,209 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/network"
+)
+
+// relayProtocol describes how a relayClient talks to the client
+type relayProtocol struct {
+	remoteData        byte
+	hookOutput        byte
+	dialFailed        byte
+	remoteUnavailable error
+}
+
+// relayClient dials a remote on the behalf of the client, then relays data
+// between the two. It holds the states that are shared by the commands that
+// works this way
+type relayClient struct {
+	presetType    string
+	protocol      relayProtocol
+	l             log.Logger
+	hooks         command.Hooks
+	w             command.StreamResponder
+	cfg           command.Configuration
+	bufferPool    *command.BufferPool
+	baseCtx       context.Context
+	baseCtxCancel func()
+	remoteChan    chan net.Conn
+	remoteConn    net.Conn
+	closeWait     sync.WaitGroup
+}
+
+func newRelayClient(
+	presetType string,
+	protocol relayProtocol,
+	l log.Logger,
+	hooks command.Hooks,
+	w command.StreamResponder,
+	cfg command.Configuration,
+	bufferPool *command.BufferPool,
+) *relayClient {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
+	return &relayClient{
+		presetType:    presetType,
+		protocol:      protocol,
+		l:             l,
+		hooks:         hooks,
+		w:             w,
+		cfg:           cfg,
+		bufferPool:    bufferPool,
+		baseCtx:       ctx,
+		baseCtxCancel: sync.OnceFunc(ctxCancel),
+		remoteChan:    make(chan net.Conn, 1),
+		remoteConn:    nil,
+		closeWait:     sync.WaitGroup{},
+	}
+}
+
+// start starts `remote` in a new goroutine. The stream will be closed once
+// the `remote` returns
+func (d *relayClient) start(remote func()) {
+	d.closeWait.Add(1)
+
+	go func() {
+		defer func() {
+			d.w.Signal(command.HeaderClose)
+			close(d.remoteChan)
+			d.baseCtxCancel()
+			d.closeWait.Done()
+		}()
+
+		remote()
+	}()
+}
+
+// sendDialFailed sends given `err` to the client as the reason of why the
+// remote cannot be connected
+func (d *relayClient) sendDialFailed(b []byte, err error) error {
+	errLen := copy(b[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
+
+	return d.w.SendManual(d.protocol.dialFailed, b[:errLen])
+}
+
+// dial runs the Hooks, then dial the remote at `addr`. Errors are reported to
+// the client before dial returns
+func (d *relayClient) dial(
+	ctx context.Context,
+	addr string,
+	b []byte,
+) (net.Conn, error) {
+	err := d.hooks.Run(
+		d.baseCtx,
+		configuration.HOOK_BEFORE_CONNECTING,
+		command.NewHookParameters(2).
+			Insert("Remote Type", d.presetType).
+			Insert("Remote Address", addr),
+		command.NewDefaultHookOutput(d.l, func(
+			hb []byte,
+		) (wLen int, wErr error) {
+			wLen = len(hb)
+			dLen := copy(b[d.w.HeaderSize():], hb) + d.w.HeaderSize()
+			wErr = d.w.SendManual(d.protocol.hookOutput, b[:dLen])
+			return
+		}),
+	)
+	if err != nil {
+		d.sendDialFailed(b, err)
+
+		return nil, err
+	}
+
+	conn, err := d.cfg.Dial(ctx, "tcp", addr)
+	if err != nil {
+		d.l.Debug("Unable to connect to remote: %s", err)
+		d.sendDialFailed(b, err)
+
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// writeTimeout returns a wrapped `conn` which fails the writes that cannot
+// be completed within the DialTimeout
+func (d *relayClient) writeTimeout(conn net.Conn) network.WriteTimeoutConn {
+	// Set timeout for writer, otherwise the Timeout writer will never
+	// be triggered
+	conn.SetWriteDeadline(time.Now().Add(d.cfg.DialTimeout))
+
+	return network.NewWriteTimeoutConn(conn, d.cfg.DialTimeout)
+}
+
+// relay sends data read from `conn` to the client until either side is
+// closed
+func (d *relayClient) relay(conn net.Conn, b []byte) {
+	for {
+		rLen, err := conn.Read(b[d.w.HeaderSize():])
+		if err != nil {
+			return
+		}
+
+		wErr := d.w.SendManual(
+			d.protocol.remoteData, b[:rLen+d.w.HeaderSize()])
+		if wErr != nil {
+			return
+		}
+	}
+}
+
+func (d *relayClient) getRemote() (net.Conn, error) {
+	if d.remoteConn != nil {
+		return d.remoteConn, nil
+	}
+
+	remoteConn, ok := <-d.remoteChan
+	if !ok {
+		return nil, d.protocol.remoteUnavailable
+	}
+	d.remoteConn = remoteConn
+
+	return d.remoteConn, nil
+}
+
+func (d *relayClient) Close() error {
+	// Cancel first, otherwise getRemote will be blocked until the remote is
+	// either connected or failed
+	d.baseCtxCancel()
+
+	remoteConn, remoteConnErr := d.getRemote()
+	if remoteConnErr == nil {
+		remoteConn.Close()
+	}
+
+	d.closeWait.Wait()
+
+	return nil
+}
+
+func (d *relayClient) Release() error {
+	d.baseCtxCancel()
+
+	return nil
+}
@@ -0,0 +1,526 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/pkg/sftp"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/rw"
+)
+
+// Server -> client signal consts
+const (
+	SFTPServerRespondSucceed             = 0x00
+	SFTPServerRespondFailed              = 0x01
+	SFTPServerHookOutputBeforeConnecting = SSHServerHookOutputBeforeConnecting
+	SFTPServerConnectFailed              = SSHServerConnectFailed
+	SFTPServerConnectSucceed             = SSHServerConnectSucceed
+	SFTPServerConnectVerifyFingerprint   = SSHServerConnectVerifyFingerprint
+	SFTPServerConnectRequestCredential   = SSHServerConnectRequestCredential
+)
+
+// Client -> server signal consts
+const (
+	SFTPClientRequest            = 0x00
+	SFTPClientRespondFingerprint = SSHClientRespondFingerprint
+	SFTPClientRespondCredential  = SSHClientRespondCredential
+)
+
+// sftpOperation is the type of the operation requested by the client
+type sftpOperation byte
+
+// Supported operations
+const (
+	SFTPOperationList   sftpOperation = 0x00
+	SFTPOperationStat   sftpOperation = 0x01
+	SFTPOperationRead   sftpOperation = 0x02
+	SFTPOperationWrite  sftpOperation = 0x03
+	SFTPOperationRename sftpOperation = 0x04
+	SFTPOperationDelete sftpOperation = 0x05
+)
+
+// Flags of the SFTPServerRespondSucceed signal
+const (
+	sftpRespondCompleted  = 0x00
+	sftpRespondMoreToCome = 0x01
+)
+
+// Flags of the SFTPOperationWrite operation
+const (
+	sftpWriteFlagTruncate = 0x01
+)
+
+const (
+	sftpRequestHeaderSize  = 2
+	sftpRespondHeaderSize  = 2
+	sftpRequestQueueSize   = 16
+	sftpMaxPathLen         = 4096
+	sftpFileInfoFixedBytes = 1 + 2 + 8 + 8
+)
+
+// File types reported in the file info
+const (
+	SFTPFileTypeRegular   = 0x00
+	SFTPFileTypeDirectory = 0x01
+	SFTPFileTypeSymlink   = 0x02
+	SFTPFileTypeOther     = 0x03
+)
+
+// Errors
+var (
+	ErrSFTPUnknownOperation = errors.New(
+		"unknown SFTP operation")
+
+	ErrSFTPFileInfoBufferTooSmall = errors.New(
+		"not enough buffer space to marshal the file info")
+
+	ErrSFTPRemoteUnavailable = errors.New(
+		"remote SFTP connection is unavailable")
+
+	ErrSFTPBusy = errors.New(
+		"too many pending requests, try again later")
+)
+
+// sftpRequest is a parsed SFTPClientRequest
+type sftpRequest struct {
+	id        byte
+	operation sftpOperation
+	path      string
+	newPath   string
+	offset    uint64
+	length    int
+	flags     byte
+	data      []byte
+}
+
+// parseSFTPRequest parses the SFTPClientRequest
+//
+// Request data format:
+// +------------+-----------+----------------------+
+// | 1 byte     | 1 byte    | n bytes              |
+// +------------+-----------+----------------------+
+// | Request ID | Operation | Operation parameters |
+// +------------+-----------+----------------------+
+//
+// Operation parameters:
+//   - SFTPOperationList:   String path
+//   - SFTPOperationStat:   String path
+//   - SFTPOperationRead:   String path, 8 bytes offset, Integer length
+//   - SFTPOperationWrite:  String path, 8 bytes offset, 1 byte flags, data
+//   - SFTPOperationRename: String old path, String new path
+//   - SFTPOperationDelete: String path
+func parseSFTPRequest(r *rw.LimitedReader, b []byte) (sftpRequest, error) {
+	_, rErr := io.ReadFull(r, b[:sftpRequestHeaderSize])
+	if rErr != nil {
+		return sftpRequest{}, rErr
+	}
+	req := sftpRequest{
+		id:        b[0],
+		operation: sftpOperation(b[1]),
+	}
+	path, _, pErr := ParseString(r.Read, b[:min(len(b), sftpMaxPathLen)])
+	if pErr != nil {
+		return sftpRequest{}, pErr
+	}
+	req.path = string(path.Data())
+	switch req.operation {
+	case SFTPOperationList, SFTPOperationStat, SFTPOperationDelete:
+	case SFTPOperationRead:
+		if _, rErr = io.ReadFull(r, b[:8]); rErr != nil {
+			return sftpRequest{}, rErr
+		}
+		req.offset = binary.BigEndian.Uint64(b[:8])
+		length := Integer(0)
+		if rErr = length.Unmarshal(r.Read); rErr != nil {
+			return sftpRequest{}, rErr
+		}
+		req.length = length.Int()
+	case SFTPOperationWrite:
+		if _, rErr = io.ReadFull(r, b[:9]); rErr != nil {
+			return sftpRequest{}, rErr
+		}
+		req.offset = binary.BigEndian.Uint64(b[:8])
+		req.flags = b[8]
+		req.data = make([]byte, 0, r.Remains())
+		for !r.Completed() {
+			rData, rErr := r.Buffered()
+			if rErr != nil {
+				return sftpRequest{}, rErr
+			}
+			req.data = append(req.data, rData...)
+		}
+	case SFTPOperationRename:
+		newPath, _, pErr := ParseString(
+			r.Read, b[:min(len(b), sftpMaxPathLen)])
+		if pErr != nil {
+			return sftpRequest{}, pErr
+		}
+		req.newPath = string(newPath.Data())
+	default:
+		return sftpRequest{}, ErrSFTPUnknownOperation
+	}
+	return req, nil
+}
+
+// marshalSFTPFileInfo writes `info` to `b`
+//
+// File info data format:
+// +--------+--------+-------------+---------+-------------------+
+// | String | 1 byte | 2 bytes     | 8 bytes | 8 bytes           |
+// +--------+--------+-------------+---------+-------------------+
+// | Name   | Type   | Permissions | Size    | Modified (Unix s) |
+// +--------+--------+-------------+---------+-------------------+
+func marshalSFTPFileInfo(info fs.FileInfo, b []byte) (int, error) {
+	nLen, nErr := MarshalString(info.Name(), b)
+	if nErr != nil {
+		return 0, nErr
+	}
+	if len(b)-nLen < sftpFileInfoFixedBytes {
+		return 0, ErrSFTPFileInfoBufferTooSmall
+	}
+	bb := b[nLen:]
+	mode := info.Mode()
+	switch {
+	case mode.IsRegular():
+		bb[0] = SFTPFileTypeRegular
+	case mode.IsDir():
+		bb[0] = SFTPFileTypeDirectory
+	case mode&fs.ModeSymlink != 0:
+		bb[0] = SFTPFileTypeSymlink
+	default:
+		bb[0] = SFTPFileTypeOther
+	}
+	binary.BigEndian.PutUint16(bb[1:3], uint16(mode.Perm()))
+	binary.BigEndian.PutUint64(bb[3:11], uint64(info.Size()))
+	binary.BigEndian.PutUint64(bb[11:19], uint64(info.ModTime().Unix()))
+	return nLen + sftpFileInfoFixedBytes, nil
+}
+
+type sftpClient struct {
+	*sshConnector
+	remoteCloseWait sync.WaitGroup
+	requests        chan sftpRequest
+	rejected        []byte
+	rejectedLock    sync.Mutex
+	rejectedNotify  chan struct{}
+	remoteCloser    func() error
+	remoteCloseLock sync.Mutex
+}
+
+func newSFTP(
+	l log.Logger,
+	hooks command.Hooks,
+	w command.StreamResponder,
+	cfg command.Configuration,
+	bufferPool *command.BufferPool,
+) command.FSMMachine {
+	return &sftpClient{
+		sshConnector:    newSSHConnector(l, hooks, w, cfg, bufferPool),
+		remoteCloseWait: sync.WaitGroup{},
+		requests:        make(chan sftpRequest, sftpRequestQueueSize),
+		rejected:        nil,
+		rejectedLock:    sync.Mutex{},
+		rejectedNotify:  make(chan struct{}, 1),
+		remoteCloser:    nil,
+		remoteCloseLock: sync.Mutex{},
+	}
+}
+
+func (d *sftpClient) Bootup(
+	r *rw.LimitedReader,
+	b []byte,
+) (command.FSMState, command.FSMError) {
+	userName, addr, authModes, err := d.parseBootup(r)
+	if !err.Succeed() {
+		return nil, err
+	}
+	d.remoteCloseWait.Add(1)
+	go d.remote(userName, addr, authModes)
+	return d.local, command.NoFSMError()
+}
+
+func (d *sftpClient) setRemoteCloser(closer func() error) {
+	d.remoteCloseLock.Lock()
+	defer d.remoteCloseLock.Unlock()
+	d.remoteCloser = closer
+}
+
+func (d *sftpClient) closeRemote() {
+	d.remoteCloseLock.Lock()
+	defer d.remoteCloseLock.Unlock()
+	if d.remoteCloser == nil {
+		return
+	}
+	d.remoteCloser()
+}
+
+func (d *sftpClient) remote(
+	user string,
+	address string,
+	authMethods SSHAuthModes,
+) {
+	u := d.bufferPool.Get()
+	defer d.bufferPool.Put(u)
+	defer func() {
+		d.w.Signal(command.HeaderClose)
+		d.baseCtxCancel()
+		d.remoteCloseWait.Done()
+	}()
+	conn, release, ready, err :=
+		d.connect("SFTP", user, address, authMethods, (*u)[:])
+	if err != nil {
+		return
+	}
+	defer release()
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		d.sendConnectFailed((*u)[:], err)
+		d.l.Debug("Unable to start SFTP subsystem: %s", err)
+		return
+	}
+	defer client.Close()
+	ready()
+	d.setRemoteCloser(func() error {
+		client.Close()
+		release()
+		return nil
+	})
+	go func() {
+		// The connection may be shared, so wait for the subsystem instead
+		client.Wait()
+		d.baseCtxCancel()
+	}()
+	wErr := d.w.SendManual(SFTPServerConnectSucceed, (*u)[:d.w.HeaderSize()])
+	if wErr != nil {
+		return
+	}
+	d.l.Debug("Serving")
+	for {
+		select {
+		case <-d.baseCtx.Done():
+			return
+		case req := <-d.requests:
+			if wErr := d.serve(client, req, (*u)[:]); wErr != nil {
+				d.l.Debug("Unable to send respond: %s", wErr)
+				return
+			}
+		case <-d.rejectedNotify:
+			for _, id := range d.takeRejected() {
+				wErr := d.respondFailed(id, ErrSFTPBusy, (*u)[:])
+				if wErr != nil {
+					d.l.Debug("Unable to send respond: %s", wErr)
+					return
+				}
+			}
+		}
+	}
+}
+
+// reject records the request of `id` which can't be queued. The remote side
+// will tell the client about it, as the local side must not write to the
+// client
+func (d *sftpClient) reject(id byte) {
+	d.rejectedLock.Lock()
+	defer d.rejectedLock.Unlock()
+	if !slices.Contains(d.rejected, id) {
+		d.rejected = append(d.rejected, id)
+	}
+	select {
+	case d.rejectedNotify <- struct{}{}:
+	default:
+	}
+}
+
+// takeRejected returns the IDs of the rejected requests and forgets them
+func (d *sftpClient) takeRejected() []byte {
+	d.rejectedLock.Lock()
+	defer d.rejectedLock.Unlock()
+	rejected := d.rejected
+	d.rejected = nil
+	return rejected
+}
+
+// respond sends a SFTPServerRespondSucceed signal, `b` must contains the
+// payload after the first d.w.HeaderSize()+sftpRespondHeaderSize bytes
+func (d *sftpClient) respond(id byte, flag byte, b []byte) error {
+	b[d.w.HeaderSize()] = id
+	b[d.w.HeaderSize()+1] = flag
+	return d.w.SendManual(SFTPServerRespondSucceed, b)
+}
+
+// respondFailed sends a SFTPServerRespondFailed signal
+func (d *sftpClient) respondFailed(id byte, err error, b []byte) error {
+	b[d.w.HeaderSize()] = id
+	errLen := copy(b[d.w.HeaderSize()+1:], err.Error())
+	return d.w.SendManual(
+		SFTPServerRespondFailed, b[:d.w.HeaderSize()+1+errLen])
+}
+
+// serve executes the `req` on the `client`. Failures of the operation is
+// reported to the client, only the failure of sending the respond is returned
+func (d *sftpClient) serve(
+	client *sftp.Client,
+	req sftpRequest,
+	b []byte,
+) error {
+	start := d.w.HeaderSize() + sftpRespondHeaderSize
+	switch req.operation {
+	case SFTPOperationList:
+		infos, err := client.ReadDir(req.path)
+		if err != nil {
+			return d.respondFailed(req.id, err, b)
+		}
+		cur := start
+		for i := range infos {
+			iLen, iErr := marshalSFTPFileInfo(infos[i], b[cur:])
+			if iErr == nil {
+				cur += iLen
+				continue
+			}
+			if cur == start {
+				// Not even one item can fit into the buffer, skip it
+				d.l.Debug("Unable to list file %q: %s", infos[i].Name(), iErr)
+				continue
+			}
+			wErr := d.respond(req.id, sftpRespondMoreToCome, b[:cur])
+			if wErr != nil {
+				return wErr
+			}
+			cur = start
+			iLen, iErr = marshalSFTPFileInfo(infos[i], b[cur:])
+			if iErr != nil {
+				d.l.Debug("Unable to list file %q: %s", infos[i].Name(), iErr)
+				continue
+			}
+			cur += iLen
+		}
+		return d.respond(req.id, sftpRespondCompleted, b[:cur])
+	case SFTPOperationStat:
+		info, err := client.Stat(req.path)
+		if err != nil {
+			return d.respondFailed(req.id, err, b)
+		}
+		iLen, iErr := marshalSFTPFileInfo(info, b[start:])
+		if iErr != nil {
+			return d.respondFailed(req.id, iErr, b)
+		}
+		return d.respond(req.id, sftpRespondCompleted, b[:start+iLen])
+	case SFTPOperationRead:
+		f, err := client.Open(req.path)
+		if err != nil {
+			return d.respondFailed(req.id, err, b)
+		}
+		defer f.Close()
+		readLen := min(req.length, len(b)-start)
+		rLen, rErr := f.ReadAt(b[start:start+readLen], int64(req.offset))
+		if rErr != nil && !errors.Is(rErr, io.EOF) {
+			return d.respondFailed(req.id, rErr, b)
+		}
+		return d.respond(req.id, sftpRespondCompleted, b[:start+rLen])
+	case SFTPOperationWrite:
+		flags := os.O_WRONLY | os.O_CREATE
+		if req.flags&sftpWriteFlagTruncate != 0 {
+			flags |= os.O_TRUNC
+		}
+		f, err := client.OpenFile(req.path, flags)
+		if err != nil {
+			return d.respondFailed(req.id, err, b)
+		}
+		defer f.Close()
+		_, wErr := f.WriteAt(req.data, int64(req.offset))
+		if wErr != nil {
+			return d.respondFailed(req.id, wErr, b)
+		}
+		return d.respond(req.id, sftpRespondCompleted, b[:start])
+	case SFTPOperationRename:
+		if err := client.Rename(req.path, req.newPath); err != nil {
+			return d.respondFailed(req.id, err, b)
+		}
+		return d.respond(req.id, sftpRespondCompleted, b[:start])
+	case SFTPOperationDelete:
+		if err := client.Remove(req.path); err != nil {
+			return d.respondFailed(req.id, err, b)
+		}
+		return d.respond(req.id, sftpRespondCompleted, b[:start])
+	default:
+		return d.respondFailed(req.id, ErrSFTPUnknownOperation, b)
+	}
+}
+
+func (d *sftpClient) local(
+	f *command.FSM,
+	r *rw.LimitedReader,
+	h command.StreamHeader,
+	b []byte,
+) error {
+	switch h.Marker() {
+	case SFTPClientRequest:
+		req, err := parseSFTPRequest(r, b)
+		if err != nil {
+			return err
+		}
+		d.credentialProcessed = true
+		select {
+		case d.requests <- req:
+			return nil
+		case <-d.baseCtx.Done():
+			return ErrSFTPRemoteUnavailable
+		default:
+			// Don't wait for the queue, otherwise all streams of the client
+			// will be stuck
+			d.reject(req.id)
+			return nil
+		}
+	case SFTPClientRespondFingerprint:
+		confirmed, err := d.respondFingerprint(r)
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			d.closeRemote()
+		}
+		return nil
+	case SFTPClientRespondCredential:
+		return d.respondCredential(r)
+	default:
+		return ErrSSHUnknownClientSignal
+	}
+}
+
+func (d *sftpClient) Close() error {
+	d.close()
+	d.closeRemote()
+	d.baseCtxCancel()
+	d.remoteCloseWait.Wait()
+	return nil
+}
+
+func (d *sftpClient) Release() error {
+	d.baseCtxCancel()
+	return nil
+}
@@ -0,0 +1,306 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/network"
+	"github.com/nirui/sshwifty/application/rw"
+)
+
+func testLimitedReader(data []byte) *rw.LimitedReader {
+	sent := false
+	fr := rw.NewFetchReader(func() ([]byte, error) {
+		if sent {
+			return nil, io.EOF
+		}
+		sent = true
+		return data, nil
+	})
+	lr := rw.NewLimitedReader(&fr, len(data))
+	return &lr
+}
+
+func TestParseSFTPRequestWrite(t *testing.T) {
+	data := []byte{
+		0x07, byte(SFTPOperationWrite),
+		0x04, '/', 't', 'm', 'p',
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00,
+		sftpWriteFlagTruncate,
+		'H', 'e', 'l', 'l', 'o',
+	}
+	req, err := parseSFTPRequest(testLimitedReader(data), make([]byte, 64))
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if req.id != 0x07 || req.operation != SFTPOperationWrite {
+		t.Errorf("Unexpected request header: %d, %d", req.id, req.operation)
+		return
+	}
+	if req.path != "/tmp" {
+		t.Errorf("Expecting the path to be %q, got %q instead",
+			"/tmp", req.path)
+		return
+	}
+	if req.offset != 256 {
+		t.Errorf("Expecting the offset to be %d, got %d instead",
+			256, req.offset)
+		return
+	}
+	if req.flags != sftpWriteFlagTruncate {
+		t.Errorf("Expecting the flags to be %d, got %d instead",
+			sftpWriteFlagTruncate, req.flags)
+		return
+	}
+	if !bytes.Equal(req.data, []byte("Hello")) {
+		t.Errorf("Expecting the data to be %q, got %q instead",
+			"Hello", req.data)
+		return
+	}
+}
+
+func TestParseSFTPRequestRename(t *testing.T) {
+	data := []byte{
+		0x01, byte(SFTPOperationRename),
+		0x02, '/', 'a',
+		0x02, '/', 'b',
+	}
+	req, err := parseSFTPRequest(testLimitedReader(data), make([]byte, 64))
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if req.path != "/a" || req.newPath != "/b" {
+		t.Errorf("Expecting the paths to be %q and %q, got %q and %q instead",
+			"/a", "/b", req.path, req.newPath)
+		return
+	}
+}
+
+func TestParseSFTPRequestUnknownOperation(t *testing.T) {
+	data := []byte{0x01, 0xff, 0x02, '/', 'a'}
+	_, err := parseSFTPRequest(testLimitedReader(data), make([]byte, 64))
+	if err != ErrSFTPUnknownOperation {
+		t.Errorf("Expecting error %q, got %q instead",
+			ErrSFTPUnknownOperation, err)
+		return
+	}
+}
+
+// testSFTPStream starts a SFTP stream to a SSH server that serves an in-memory
+// file system. The server fingerprint is yet to be confirmed when returned
+func testSFTPStream(t *testing.T) *testStream {
+	addr := testSSHServer(t, testSSHPasswordConfig("secret", nil), func(
+		conn *ssh.ServerConn,
+		chans <-chan ssh.NewChannel,
+		reqs <-chan *ssh.Request,
+	) {
+		testSSHSessions(conn, chans, reqs, func(
+			conn *ssh.ServerConn,
+			ch ssh.Channel,
+			req *ssh.Request,
+		) {
+			if req.Type != "subsystem" {
+				return
+			}
+			go func() {
+				server := sftp.NewRequestServer(ch, sftp.InMemHandler())
+				defer server.Close()
+				server.Serve()
+			}()
+		})
+	})
+	s := testCommandStream(t, command.Configuration{
+		Dial:        network.TCPDial(),
+		DialTimeout: 5 * time.Second,
+		Presets: []configuration.Preset{{
+			Type:    "SFTP",
+			Host:    addr,
+			Secrets: map[string]string{sshPasswordMeta: "secret"},
+		}},
+	}, 0x02, testSSHBootup(t, "test", addr, SSHAuthMethodPassphrase))
+	if marker, _ := s.receive(); marker != SFTPServerConnectVerifyFingerprint {
+		t.Fatalf("Expecting fingerprint verification, got %d instead", marker)
+	}
+	return s
+}
+
+// testSFTPConfirm confirms the server fingerprint and waits for the
+// connection to be established
+func testSFTPConfirm(t *testing.T, s *testStream) {
+	s.send(SFTPClientRespondFingerprint, []byte{0})
+	for {
+		marker, d := s.receive()
+		switch marker {
+		case SFTPServerConnectSucceed:
+			return
+		case SFTPServerConnectFailed:
+			t.Fatalf("Unable to connect: %s", d)
+		}
+	}
+}
+
+// testSFTPRequest sends a SFTPClientRequest and returns the respond to it. The
+// returned data is the payload of the respond, including the flag of the
+// succeed respond, and is only valid until the next call
+func testSFTPRequest(
+	t *testing.T,
+	s *testStream,
+	id byte,
+	op sftpOperation,
+	path string,
+	params []byte,
+) (byte, []byte) {
+	b := make([]byte, 2, 64+len(path)+len(params))
+	b[0], b[1] = id, byte(op)
+	b = b[:cap(b)]
+	n, err := MarshalString(path, b[2:])
+	if err != nil {
+		t.Fatal("Unable to marshal path:", err)
+	}
+	s.send(SFTPClientRequest, append(b[:2+n], params...))
+	return testSFTPRespond(t, s, id)
+}
+
+// testSFTPRespond waits for the respond to the request of `id`
+func testSFTPRespond(t *testing.T, s *testStream, id byte) (byte, []byte) {
+	for {
+		marker, d := s.receive()
+		if marker != SFTPServerRespondSucceed &&
+			marker != SFTPServerRespondFailed {
+			continue
+		}
+		if d[0] != id {
+			t.Fatalf("Expecting respond to request %d, got %d instead",
+				id, d[0])
+		}
+		return marker, d[1:]
+	}
+}
+
+func TestSFTPServe(t *testing.T) {
+	s := testSFTPStream(t)
+	testSFTPConfirm(t, s)
+	write := append(make([]byte, 8), sftpWriteFlagTruncate)
+	write = append(write, "Hello World"...)
+	if marker, d := testSFTPRequest(
+		t, s, 1, SFTPOperationWrite, "/hello.txt", write,
+	); marker != SFTPServerRespondSucceed {
+		t.Errorf("Unable to write: %s", d)
+		return
+	}
+	marker, d := testSFTPRequest(t, s, 2, SFTPOperationStat, "/hello.txt", nil)
+	if marker != SFTPServerRespondSucceed || d[0] != sftpRespondCompleted {
+		t.Errorf("Unable to stat: %s", d)
+		return
+	}
+	name, nLen, err := ParseString(
+		bytes.NewReader(d[1:]).Read, make([]byte, 64))
+	if err != nil || string(name.Data()) != "hello.txt" {
+		t.Errorf("Unexpected file name %q (%v)", name.Data(), err)
+		return
+	}
+	info := d[1+nLen.ByteSize()+nLen.Int():]
+	if info[0] != SFTPFileTypeRegular ||
+		binary.BigEndian.Uint64(info[3:11]) != 11 {
+		t.Errorf("Unexpected file info: %v", info)
+		return
+	}
+	read := binary.BigEndian.AppendUint64(nil, 6)
+	length := Integer(5)
+	lBuf := make([]byte, length.ByteSize())
+	length.Marshal(lBuf)
+	marker, d = testSFTPRequest(
+		t, s, 3, SFTPOperationRead, "/hello.txt", append(read, lBuf...))
+	if marker != SFTPServerRespondSucceed || string(d[1:]) != "World" {
+		t.Errorf("Expecting to read %q, got %q instead", "World", d[1:])
+		return
+	}
+	newPath := make([]byte, 64)
+	n, _ := MarshalString("/world.txt", newPath)
+	if marker, d := testSFTPRequest(
+		t, s, 4, SFTPOperationRename, "/hello.txt", newPath[:n],
+	); marker != SFTPServerRespondSucceed {
+		t.Errorf("Unable to rename: %s", d)
+		return
+	}
+	marker, d = testSFTPRequest(t, s, 5, SFTPOperationList, "/", nil)
+	if marker != SFTPServerRespondSucceed ||
+		!bytes.Contains(d, []byte("world.txt")) ||
+		bytes.Contains(d, []byte("hello.txt")) {
+		t.Errorf("Unexpected list result: %q", d)
+		return
+	}
+	if marker, d := testSFTPRequest(
+		t, s, 6, SFTPOperationDelete, "/world.txt", nil,
+	); marker != SFTPServerRespondSucceed {
+		t.Errorf("Unable to delete: %s", d)
+		return
+	}
+	if marker, _ := testSFTPRequest(
+		t, s, 7, SFTPOperationStat, "/world.txt", nil,
+	); marker != SFTPServerRespondFailed {
+		t.Error("Expecting the deleted file to be gone")
+		return
+	}
+}
+
+func TestSFTPServeBusy(t *testing.T) {
+	s := testSFTPStream(t)
+	// Requests are not served until the connection is established
+	requests := sftpRequestQueueSize + 8
+	path := make([]byte, 64)
+	n, _ := MarshalString("/", path)
+	for i := range requests {
+		s.send(SFTPClientRequest, append(
+			[]byte{byte(i), byte(SFTPOperationStat)}, path[:n]...))
+	}
+	testSFTPConfirm(t, s)
+	busy := 0
+	for range requests {
+		marker, d := s.receive()
+		switch marker {
+		case SFTPServerRespondSucceed:
+		case SFTPServerRespondFailed:
+			if string(d[1:]) != ErrSFTPBusy.Error() {
+				t.Errorf("Unexpected failure: %s", d[1:])
+				return
+			}
+			busy++
+		default:
+			t.Errorf("Unexpected respond %d", marker)
+			return
+		}
+	}
+	if busy != requests-sftpRequestQueueSize {
+		t.Errorf("Expecting %d requests to be rejected, got %d instead",
+			requests-sftpRequestQueueSize, busy)
+		return
+	}
+}
@@ -20,11 +20,17 @@ package commands
 import (
 	"bytes"
 	"context"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -45,6 +51,7 @@ const (
 	SSHServerConnectSucceed             = 0x04
 	SSHServerConnectVerifyFingerprint   = 0x05
 	SSHServerConnectRequestCredential   = 0x06
+	SSHServerNotice                     = 0x07
 )
 
 type sshCredentialRequestType byte
@@ -53,6 +60,7 @@ const (
 	SSHServerCredentialPrivateKey          sshCredentialRequestType = 0x00
 	SSHServerCredentialPassphrase          sshCredentialRequestType = 0x01
 	SSHServerCredentialKeyboardInteractive sshCredentialRequestType = 0x02
+	SSHServerCredentialKeyPassphrase       sshCredentialRequestType = 0x03
 )
 
 func (s sshCredentialRequestType) makeHeader(b []byte, headerSize int) []byte {
@@ -61,6 +69,24 @@ func (s sshCredentialRequestType) makeHeader(b []byte, headerSize int) []byte {
 	return b
 }
 
+// sshNoticeType is the type of the information carried by the SSHServerNotice
+// signal
+type sshNoticeType byte
+
+const (
+	SSHServerNoticeJumpHost   sshNoticeType = 0x00
+	SSHServerNoticeExitStatus sshNoticeType = 0x01
+	SSHServerNoticeBanner     sshNoticeType = 0x02
+	SSHServerNoticeVersion    sshNoticeType = 0x03
+	SSHServerNoticeReconnect  sshNoticeType = 0x04
+)
+
+func (s sshNoticeType) makeHeader(b []byte, headerSize int) []byte {
+	b = b[:headerSize+1]
+	b[headerSize] = byte(s)
+	return b
+}
+
 // Client -> server signal consts
 const (
 	SSHClientStdIn              = 0x00
@@ -78,6 +104,9 @@ const (
 	SSHRequestErrorBadUserName      = command.StreamError(0x01)
 	SSHRequestErrorBadRemoteAddress = command.StreamError(0x02)
 	SSHRequestErrorBadAuthMethod    = command.StreamError(0x03)
+	SSHRequestErrorBadTerminal      = command.StreamError(0x04)
+	SSHRequestErrorBadCommand       = command.StreamError(0x05)
+	SSHRequestErrorBadSubsystem     = command.StreamError(0x06)
 )
 
 // SSHAuthModes SSH auth methods
@@ -103,23 +132,37 @@ func (a SSHAuthModes) supports(t SSHAuthModes) bool {
 	return a&t != 0
 }
 
-// build builds authentication methods enabled in current `a`
+// build builds authentication methods enabled in current `a`. Credentials
+// that can be found in `creds` will be used directly instead of requesting
+// them from the client. When reconnecting, the client will not be asked at
+// all, only the credentials cached during the first connection will be used
 func (a SSHAuthModes) build(
-	d *sshClient,
+	d *sshConnector,
+	creds sshCredentials,
 	b []byte,
 	retries int,
 ) []ssh.AuthMethod {
+	methods := make([]ssh.AuthMethod, 0, SSHTotalSupportedAuthMethods+1)
+	if len(creds.signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(creds.signers...))
+	}
 	if !a.enabled() {
-		return nil
+		return methods
 	}
-	methods := make([]ssh.AuthMethod, 0, SSHTotalSupportedAuthMethods)
-	if a.supports(SSHAuthMethodPassphrase) {
+	password, passwordDefined := creds.secrets[sshPasswordMeta]
+	privateKey, privateKeyDefined := creds.secrets[sshPrivateKeyMeta]
+	if a.supports(SSHAuthMethodPassphrase) && passwordDefined {
+		methods = append(methods, ssh.Password(password))
+	} else if a.supports(SSHAuthMethodPassphrase) {
 		methods = append(
 			methods,
 			ssh.RetryableAuthMethod(ssh.PasswordCallback(func() (
 				string,
 				error,
 			) {
+				if d.reconnecting.Load() {
+					return creds.cache.cachedPassword()
+				}
 				d.enableRemoteReadTimeoutRetry()
 				defer d.disableRemoteReadTimeoutRetry()
 				wErr := d.w.SendManual(
@@ -136,17 +179,49 @@ func (a SSHAuthModes) build(
 				if !passphraseReceived {
 					return "", ErrSSHAuthCancelled
 				}
+				creds.cache.savePassword(string(passphraseBytes))
 				return string(passphraseBytes), nil
 			}), retries),
 		)
 	}
-	if a.supports(SSHAuthMethodPrivateKey) {
+	keyPassphrase, keyPassphraseDefined :=
+		creds.secrets[sshKeyPassphraseMeta]
+	requestKeyPassphrase := func(k ssh.PublicKey) ([]byte, error) {
+		if keyPassphraseDefined {
+			return []byte(keyPassphrase), nil
+		}
+		return d.requestKeyPassphrase(k, b)
+	}
+	keyPassphraseRetries := retries
+	if keyPassphraseDefined {
+		keyPassphraseRetries = 1
+	}
+	if a.supports(SSHAuthMethodPrivateKey) && privateKeyDefined {
+		methods = append(
+			methods,
+			ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				if d.reconnecting.Load() {
+					return creds.cache.cachedSigners()
+				}
+				signers, err := parseSSHPrivateKeys(
+					[]byte(privateKey),
+					keyPassphraseRetries,
+					requestKeyPassphrase,
+				)
+				creds.cache.saveSigners(signers)
+				return signers, err
+			}),
+		)
+	} else if a.supports(SSHAuthMethodPrivateKey) {
 		methods = append(
 			methods,
 			ssh.RetryableAuthMethod(ssh.PublicKeysCallback(func() (
 				[]ssh.Signer,
 				error,
 			) {
+				if d.reconnecting.Load() {
+					return creds.cache.cachedSigners()
+				}
 				d.enableRemoteReadTimeoutRetry()
 				defer d.disableRemoteReadTimeoutRetry()
 				wErr := d.w.SendManual(
@@ -163,11 +238,13 @@ func (a SSHAuthModes) build(
 				if !privateKeyReceived {
 					return nil, ErrSSHAuthCancelled
 				}
-				signer, signerErr := ssh.ParsePrivateKey(privateKeyBytes)
-				if signerErr != nil {
-					return nil, signerErr
-				}
-				return []ssh.Signer{signer}, signerErr
+				signers, err := parseSSHPrivateKeys(
+					privateKeyBytes,
+					keyPassphraseRetries,
+					requestKeyPassphrase,
+				)
+				creds.cache.saveSigners(signers)
+				return signers, err
 			}), retries),
 		)
 	}
@@ -183,6 +260,10 @@ func (a SSHAuthModes) build(
 				if len(questions) <= 0 {
 					return
 				}
+				if d.reconnecting.Load() {
+					return creds.cache.cachedAnswers(
+						name, instruction, questions)
+				}
 				d.enableRemoteReadTimeoutRetry()
 				defer d.disableRemoteReadTimeoutRetry()
 				// Build the question request
@@ -254,6 +335,7 @@ func (a SSHAuthModes) build(
 				for i := range ans {
 					answers[i] = string(ans[i].Data())
 				}
+				creds.cache.saveAnswers(name, instruction, questions, answers)
 				return
 			}), retries),
 		)
@@ -261,6 +343,115 @@ func (a SSHAuthModes) build(
 	return methods
 }
 
+// splitSSHPrivateKeys splits `data` into individual PEM encoded private keys
+// and OpenSSH certificates (in the `authorized_keys` format, one per line). If
+// no PEM block is found, `data` is returned as the only key
+func splitSSHPrivateKeys(
+	data []byte,
+) ([][]byte, []*ssh.Certificate, error) {
+	keys := make([][]byte, 0, 1)
+	for rest := data; len(rest) > 0; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		keys = append(keys, pem.EncodeToMemory(block))
+	}
+	if len(keys) <= 0 {
+		return [][]byte{data}, nil, nil
+	}
+	var certs []*ssh.Certificate
+	for line := range bytes.Lines(data) {
+		if !bytes.Contains(line, []byte(sshCertificateKeyTypeSuffix)) {
+			continue
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid certificate: %s", err)
+		}
+		cert, isCert := pub.(*ssh.Certificate)
+		if !isCert || cert.CertType != ssh.UserCert {
+			return nil, nil, ErrSSHInvalidUserCertificate
+		}
+		certs = append(certs, cert)
+	}
+	return keys, certs, nil
+}
+
+// parseSSHPrivateKeys parses all private keys found in `data`, the signers
+// are returned in the same order of the keys so they can be tried one by one.
+// Keys that come with a certificate in `data` will be signed with that
+// certificate. Passphrase of encrypted keys will be acquired through
+// `passphrase`
+func parseSSHPrivateKeys(
+	data []byte,
+	retries int,
+	passphrase func(k ssh.PublicKey) ([]byte, error),
+) ([]ssh.Signer, error) {
+	keys, certs, err := splitSSHPrivateKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	signers := make([]ssh.Signer, 0, len(keys))
+	certUsed := make([]bool, len(certs))
+	for i := range keys {
+		signer, err := ssh.ParsePrivateKey(keys[i])
+		var missingErr *ssh.PassphraseMissingError
+		if errors.As(err, &missingErr) {
+			signer, err = parseSSHEncryptedPrivateKey(
+				keys[i], missingErr.PublicKey, retries, passphrase)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse private key %d: %s",
+				i+1, err)
+		}
+		pub := signer.PublicKey().Marshal()
+		for c := range certs {
+			if !bytes.Equal(certs[c].Key.Marshal(), pub) {
+				continue
+			}
+			if signer, err = ssh.NewCertSigner(certs[c], signer); err != nil {
+				return nil, fmt.Errorf("unable to use certificate %d: %s",
+					c+1, err)
+			}
+			certUsed[c] = true
+			break
+		}
+		signers = append(signers, signer)
+	}
+	for c := range certUsed {
+		if !certUsed[c] {
+			return nil, fmt.Errorf("certificate %d does not match any of "+
+				"the private keys", c+1)
+		}
+	}
+	return signers, nil
+}
+
+// parseSSHEncryptedPrivateKey parses the encrypted private `key` with the
+// passphrase acquired by calling `passphrase` (`pub` will be nil if the public
+// key is unavailable). If the passphrase was incorrect, `passphrase` will be
+// called again until `retries` is exhausted (or forever if `retries` is not
+// greater than 0)
+func parseSSHEncryptedPrivateKey(
+	key []byte,
+	pub ssh.PublicKey,
+	retries int,
+	passphrase func(k ssh.PublicKey) ([]byte, error),
+) (ssh.Signer, error) {
+	for tried := 1; ; tried++ {
+		p, err := passphrase(pub)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(key, p)
+		if !errors.Is(err, x509.IncorrectPasswordError) ||
+			(retries > 0 && tried >= retries) {
+			return signer, err
+		}
+	}
+}
+
 // Errors
 var (
 	ErrSSHAuthCancelled = errors.New(
@@ -278,6 +469,9 @@ var (
 	ErrSSHRemoteFingerprintRefused = errors.New(
 		"server Fingerprint has been refused")
 
+	ErrSSHRemoteFingerprintMismatched = errors.New(
+		"server Fingerprint does not match the one defined by the Preset")
+
 	ErrSSHRemoteConnUnavailable = errors.New(
 		"remote SSH connection is unavailable")
 
@@ -292,6 +486,30 @@ var (
 
 	ErrSSHUnknownClientSignal = errors.New(
 		"unknown client signal")
+
+	ErrSSHInvalidJumpHost = errors.New(
+		"jump host must be specified in the \"user@host[:port]\" format")
+
+	ErrSSHTooManyJumpHosts = errors.New(
+		"too many jump hosts")
+
+	ErrSSHInvalidUserCertificate = errors.New(
+		"certificate must be an OpenSSH user certificate")
+
+	ErrSSHCertificateAuthorityUnavailable = errors.New(
+		"the built-in certificate authority is not configured")
+
+	ErrSSHWebUserUnknown = errors.New(
+		"unable to determine the name of the web user")
+
+	ErrSSHSubsystemNotAllowed = errors.New(
+		"the subsystem is not allowed by the configuration")
+
+	ErrSSHRemoteHostKeyChanged = errors.New(
+		"server host key has changed since the last connection")
+
+	ErrSSHReconnectCredentialUnavailable = errors.New(
+		"credential needed to reconnect is unavailable")
 )
 
 var (
@@ -299,7 +517,33 @@ var (
 )
 
 const (
+	sshPresetType        = "SSH"
 	sshDefaultPortString = "22"
+	sshJumpHostsMeta     = "Jump Hosts"
+	sshFingerprintMeta   = "Fingerprint"
+	sshUserMeta          = "User"
+	sshPasswordMeta      = "Password"
+	sshPrivateKeyMeta    = "Private Key"
+	sshKeyPassphraseMeta = "Private Key Passphrase"
+	sshCommandMeta       = "Command"
+	sshSubsystemMeta     = "Subsystem"
+	sshStartupMeta       = "Startup Command"
+	sshEnvironmentPrefix = "Environment:"
+	sshMaxJumpHosts      = 0xfe
+	sshMaxCommandLen     = 4096
+
+	sshCertificateAuthorityMeta    = "Certificate Authority"
+	sshCertificateValidityMeta     = "Certificate Validity"
+	sshCertificatePrincipalsMeta   = "Certificate Principals"
+	sshCertificateExtensionsMeta   = "Certificate Extensions"
+	sshBuiltInCertificateAuthority = "Built-in"
+	sshCertificateWebUserPrincipal = "{web_user}"
+	sshDefaultCertificateValidity  = 5 * time.Minute
+
+	sshUnknownExitStatus = -1
+	sshMaxNoticeTextLen  = 1024
+
+	sshCertificateKeyTypeSuffix = "-cert-v01@openssh.com"
 )
 
 type sshRemoteConnWrapper struct {
@@ -338,7 +582,14 @@ func (s sshRemoteConn) isValid() bool {
 	return s.writer != nil && s.closer != nil && s.session != nil
 }
 
-type sshClient struct {
+// sshConnector handles the connecting phase (Hook, dial, fingerprint
+// verification and authentication) that is shared by all SSH based commands.
+//
+// Commands that embeds sshConnector must reserve the server signals from
+// SSHServerHookOutputBeforeConnecting to SSHServerConnectRequestCredential, as
+// well as the SSHClientRespondFingerprint and SSHClientRespondCredential client
+// signals for the connecting phase
+type sshConnector struct {
 	w                                    command.StreamResponder
 	l                                    log.Logger
 	hooks                                command.Hooks
@@ -346,7 +597,6 @@ type sshClient struct {
 	bufferPool                           *command.BufferPool
 	baseCtx                              context.Context
 	baseCtxCancel                        func()
-	remoteCloseWait                      sync.WaitGroup
 	remoteReadTimeoutRetry               bool
 	remoteReadForceRetryNextTimeout      bool
 	remoteReadTimeoutRetryLock           sync.Mutex
@@ -355,20 +605,22 @@ type sshClient struct {
 	credentialReceiveClosed              bool
 	fingerprintVerifyResultReceive       chan bool
 	fingerprintProcessed                 bool
+	fingerprintPending                   atomic.Int32
 	fingerprintVerifyResultReceiveClosed bool
-	remoteConnReceive                    chan sshRemoteConn
-	remoteConn                           sshRemoteConn
+	keepaliveFailure                     chan error
+	reconnecting                         atomic.Bool
+	credentialCache                      *sshCredentialCache
 }
 
-func newSSH(
+func newSSHConnector(
 	l log.Logger,
 	hooks command.Hooks,
 	w command.StreamResponder,
 	cfg command.Configuration,
 	bufferPool *command.BufferPool,
-) command.FSMMachine {
+) *sshConnector {
 	ctx, ctxCancel := context.WithCancel(context.Background())
-	return &sshClient{
+	return &sshConnector{
 		w:                                    w,
 		l:                                    l,
 		hooks:                                hooks,
@@ -376,7 +628,6 @@ func newSSH(
 		bufferPool:                           bufferPool,
 		baseCtx:                              ctx,
 		baseCtxCancel:                        sync.OnceFunc(ctxCancel),
-		remoteCloseWait:                      sync.WaitGroup{},
 		remoteReadTimeoutRetry:               false,
 		remoteReadForceRetryNextTimeout:      false,
 		remoteReadTimeoutRetryLock:           sync.Mutex{},
@@ -385,9 +636,35 @@ func newSSH(
 		credentialReceiveClosed:              false,
 		fingerprintVerifyResultReceive:       make(chan bool, 1),
 		fingerprintProcessed:                 false,
+		fingerprintPending:                   atomic.Int32{},
 		fingerprintVerifyResultReceiveClosed: false,
-		remoteConnReceive:                    make(chan sshRemoteConn, 1),
-		remoteConn:                           sshRemoteConn{},
+		keepaliveFailure:                     make(chan error, 1),
+		reconnecting:                         atomic.Bool{},
+		credentialCache:                      nil,
+	}
+}
+
+type sshClient struct {
+	*sshConnector
+	remoteCloseWait   sync.WaitGroup
+	remoteConnReceive chan sshRemoteConn
+	remoteConn        sshRemoteConn
+	termSize          atomic.Uint32
+}
+
+func newSSH(
+	l log.Logger,
+	hooks command.Hooks,
+	w command.StreamResponder,
+	cfg command.Configuration,
+	bufferPool *command.BufferPool,
+) command.FSMMachine {
+	return &sshClient{
+		sshConnector:      newSSHConnector(l, hooks, w, cfg, bufferPool),
+		remoteCloseWait:   sync.WaitGroup{},
+		remoteConnReceive: make(chan sshRemoteConn, 1),
+		remoteConn:        sshRemoteConn{},
+		termSize:          atomic.Uint32{},
 	}
 }
 
@@ -400,50 +677,315 @@ func parseSSHConfig(p configuration.Preset) (configuration.Preset, error) {
 	if len(p.Host) <= 0 {
 		p.Host = oldHost
 	}
+	// Credentials are used by the backend directly, never send them to the
+	// client. So are the environment variables, as they may contain secrets
+	envNames, err := sshEnvironmentMetaNames(p.Meta)
+	if err != nil {
+		return p, err
+	}
+	p = p.MoveToSecrets(append(envNames,
+		sshPasswordMeta, sshPrivateKeyMeta, sshKeyPassphraseMeta)...)
+	if _, _, err := parseSSHCertificateSettings(p.Meta); err != nil {
+		return p, err
+	}
+	if _, err := defaultSSHTerminal().applyMeta(p.Meta); err != nil {
+		return p, err
+	}
+	_, err = parseSSHKeepalive(p.Meta, configuration.SSHKeepaliveSettings{})
+	if err != nil {
+		return p, err
+	}
+	_, err = parseSSHAlgorithms(p.Meta, configuration.SSHAlgorithmSettings{})
+	if err != nil {
+		return p, err
+	}
+	if _, err = parseSSHReconnectPolicy(p.Meta); err != nil {
+		return p, err
+	}
+	if subsystem, ok := p.Meta[sshSubsystemMeta]; ok {
+		if len(subsystem) <= 0 {
+			return p, fmt.Errorf("invalid %q Meta: must not be empty",
+				sshSubsystemMeta)
+		}
+		if _, ok := p.Meta[sshCommandMeta]; ok {
+			return p, fmt.Errorf("%q Meta and %q Meta cannot be used together",
+				sshSubsystemMeta, sshCommandMeta)
+		}
+	}
+	if _, ok := p.Meta[sshStartupMeta]; ok {
+		if _, ok := p.Meta[sshCommandMeta]; ok {
+			return p, fmt.Errorf("%q Meta and %q Meta cannot be used together",
+				sshStartupMeta, sshCommandMeta)
+		}
+		if _, ok := p.Meta[sshSubsystemMeta]; ok {
+			return p, fmt.Errorf("%q Meta and %q Meta cannot be used together",
+				sshStartupMeta, sshSubsystemMeta)
+		}
+	}
+	jumpHosts, ok := p.Meta[sshJumpHostsMeta]
+	if !ok {
+		return p, nil
+	}
+	if _, err := parseSSHJumpHosts(jumpHosts); err != nil {
+		return p, fmt.Errorf("invalid %q Meta: %s", sshJumpHostsMeta, err)
+	}
 	return p, nil
 }
 
+// sshEnvironmentMetaNames returns the names of the Meta items that defines
+// environment variables. Such items are named as "Environment:" followed by
+// the name of the variable, for example "Environment:LANG"
+func sshEnvironmentMetaNames(meta map[string]string) ([]string, error) {
+	names := make([]string, 0, len(meta))
+	for k := range meta {
+		name, ok := strings.CutPrefix(k, sshEnvironmentPrefix)
+		if !ok {
+			continue
+		}
+		if len(name) <= 0 || strings.ContainsAny(name, "= \t\r\n") {
+			return nil, fmt.Errorf("invalid %q Meta: bad variable name", k)
+		}
+		names = append(names, k)
+	}
+	return names, nil
+}
+
+// sshEnvironment returns the environment variables defined in `secrets`
+func sshEnvironment(secrets map[string]string) map[string]string {
+	env := make(map[string]string, len(secrets))
+	for k, v := range secrets {
+		if name, ok := strings.CutPrefix(k, sshEnvironmentPrefix); ok {
+			env[name] = v
+		}
+	}
+	return env
+}
+
+// sshCertificateSettings contains settings of the certificates issued by the
+// built-in certificate authority
+type sshCertificateSettings struct {
+	validity   time.Duration
+	principals []string
+	extensions map[string]string
+}
+
+// parseSSHCertificateSettings parses the certificate settings defined in
+// `meta`, and returns whether or not the built-in certificate authority is
+// enabled
+func parseSSHCertificateSettings(
+	meta map[string]string,
+) (sshCertificateSettings, bool, error) {
+	ca, ok := meta[sshCertificateAuthorityMeta]
+	if !ok {
+		return sshCertificateSettings{}, false, nil
+	}
+	if ca != sshBuiltInCertificateAuthority {
+		return sshCertificateSettings{}, false, fmt.Errorf(
+			"invalid %q Meta: only %q is supported",
+			sshCertificateAuthorityMeta, sshBuiltInCertificateAuthority)
+	}
+	cs := sshCertificateSettings{
+		validity:   sshDefaultCertificateValidity,
+		principals: []string{sshCertificateWebUserPrincipal},
+		extensions: map[string]string{"permit-pty": ""},
+	}
+	if v, ok := meta[sshCertificateValidityMeta]; ok {
+		validity, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || validity <= 0 {
+			return cs, false, fmt.Errorf(
+				"invalid %q Meta: must be a positive duration such as \"5m\"",
+				sshCertificateValidityMeta)
+		}
+		cs.validity = validity
+	}
+	if v, ok := meta[sshCertificatePrincipalsMeta]; ok {
+		cs.principals = splitSSHMetaList(v)
+		if len(cs.principals) <= 0 {
+			return cs, false, fmt.Errorf(
+				"invalid %q Meta: at least one principal is required",
+				sshCertificatePrincipalsMeta)
+		}
+	}
+	if v, ok := meta[sshCertificateExtensionsMeta]; ok {
+		extensions := splitSSHMetaList(v)
+		cs.extensions = make(map[string]string, len(extensions))
+		for i := range extensions {
+			cs.extensions[extensions[i]] = ""
+		}
+	}
+	return cs, true, nil
+}
+
+// splitSSHMetaList splits a `,` separated Meta value into a list of non-empty
+// items
+func splitSSHMetaList(s string) []string {
+	items := make([]string, 0, strings.Count(s, ",")+1)
+	for item := range strings.SplitSeq(s, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// sshJumpHost is a SSH server that is used to reach the next SSH server
+type sshJumpHost struct {
+	user    string
+	address string
+}
+
+// parseSSHJumpHosts parses a `,` separated list of jump hosts in the
+// "user@host[:port]" format
+func parseSSHJumpHosts(s string) ([]sshJumpHost, error) {
+	hosts := make([]sshJumpHost, 0, strings.Count(s, ",")+1)
+	for h := range strings.SplitSeq(s, ",") {
+		h = strings.TrimSpace(h)
+		if len(h) <= 0 {
+			continue
+		}
+		userEnd := strings.LastIndex(h, "@")
+		if userEnd <= 0 || userEnd >= len(h)-1 {
+			return nil, ErrSSHInvalidJumpHost
+		}
+		address := h[userEnd+1:]
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, sshDefaultPortString)
+		}
+		hosts = append(hosts, sshJumpHost{
+			user:    h[:userEnd],
+			address: address,
+		})
+	}
+	if len(hosts) > sshMaxJumpHosts {
+		return nil, ErrSSHTooManyJumpHosts
+	}
+	return hosts, nil
+}
+
 const (
 	sshMaxUsernameLen = 127
 	sshMaxHostnameLen = 255
 )
 
-func (d *sshClient) Bootup(
+// parseBootup parses the common part of the Bootup data of SSH based commands,
+// which consists of the user name, the remote address and the auth methods
+func (d *sshConnector) parseBootup(
 	r *rw.LimitedReader,
-	b []byte,
-) (command.FSMState, command.FSMError) {
+) (string, string, SSHAuthModes, command.FSMError) {
 	sBuf := d.bufferPool.Get()
 	defer d.bufferPool.Put(sBuf)
 	// User name
 	userName, _, userNameErr := ParseString(r.Read, (*sBuf)[:sshMaxUsernameLen])
 	if userNameErr != nil {
-		return nil, command.ToFSMError(
+		return "", "", 0, command.ToFSMError(
 			userNameErr, SSHRequestErrorBadUserName)
 	}
 	userNameStr := string(userName.Data())
 	// Address
 	addr, addrErr := ParseAddress(r.Read, (*sBuf)[:sshMaxHostnameLen])
 	if addrErr != nil {
-		return nil, command.ToFSMError(
+		return "", "", 0, command.ToFSMError(
 			addrErr, SSHRequestErrorBadRemoteAddress)
 	}
 	addrStr := addr.String()
 	if len(addrStr) <= 0 {
-		return nil, command.ToFSMError(
+		return "", "", 0, command.ToFSMError(
 			ErrSSHInvalidAddress, SSHRequestErrorBadRemoteAddress)
 	}
 	// Auth method
 	rData, rErr := rw.FetchOneByte(r.Fetch)
 	if rErr != nil {
-		return nil, command.ToFSMError(rErr, SSHRequestErrorBadAuthMethod)
+		return "", "", 0, command.ToFSMError(
+			rErr, SSHRequestErrorBadAuthMethod)
+	}
+	return userNameStr, addrStr, SSHAuthModes(rData[0]), command.NoFSMError()
+}
+
+// parseSSHCommandBootup parses the optional command at the end of the Bootup
+// data. The command is a String that follows the terminal settings, an empty
+// String or the absence of it means to start an interactive Shell instead
+func parseSSHCommandBootup(r *rw.LimitedReader, buf []byte) (string, error) {
+	if r.Completed() {
+		return "", nil
+	}
+	cmd, _, err := ParseString(r.Read, buf[:sshMaxCommandLen])
+	if err != nil {
+		return "", err
+	}
+	return string(cmd.Data()), nil
+}
+
+// sshSessionSettings contains settings of the session opened on the remote
+type sshSessionSettings struct {
+	term        sshTerminal
+	command     string
+	subsystem   string
+	startup     string
+	environment map[string]string
+	reconnect   sshReconnectPolicy
+}
+
+// subsystemAllowed returns whether or not the subsystem of given `name` is
+// allowed by the configuration
+func (d *sshClient) subsystemAllowed(name string) bool {
+	return slices.Contains(d.cfg.SSHSubsystems, name)
+}
+
+// Bootup starts the SSH client
+//
+// The Bootup data can optionally be followed by the terminal settings and the
+// command, see parseSSHTerminalBootup and parseSSHCommandBootup for detail.
+// When a command is given, it will be executed on the remote without a PTY.
+// Subsystems can only be requested by the Preset
+func (d *sshClient) Bootup(
+	r *rw.LimitedReader,
+	b []byte,
+) (command.FSMState, command.FSMError) {
+	userName, addr, authModes, err := d.parseBootup(r)
+	if !err.Succeed() {
+		return nil, err
+	}
+	sBuf := d.bufferPool.Get()
+	defer d.bufferPool.Put(sBuf)
+	term, termErr := parseSSHTerminalBootup(r, *sBuf)
+	if termErr != nil {
+		return nil, command.ToFSMError(termErr, SSHRequestErrorBadTerminal)
+	}
+	cmd, cmdErr := parseSSHCommandBootup(r, *sBuf)
+	if cmdErr != nil {
+		return nil, command.ToFSMError(cmdErr, SSHRequestErrorBadCommand)
+	}
+	settings := sshSessionSettings{term: term, command: cmd}
+	if preset, ok := d.cfg.Preset(sshPresetType, addr); ok {
+		// Already been verified by parseSSHConfig
+		settings.term, _ = term.applyMeta(preset.Meta)
+		if presetCmd, ok := preset.Meta[sshCommandMeta]; ok {
+			settings.command = presetCmd
+		}
+		settings.subsystem = preset.Meta[sshSubsystemMeta]
+		settings.environment = sshEnvironment(preset.Secrets)
+		if len(settings.command) <= 0 {
+			settings.startup = preset.Meta[sshStartupMeta]
+		}
+		settings.reconnect, _ = parseSSHReconnectPolicy(preset.Meta)
+	}
+	if len(settings.subsystem) > 0 {
+		if !d.subsystemAllowed(settings.subsystem) {
+			return nil, command.ToFSMError(
+				ErrSSHSubsystemNotAllowed, SSHRequestErrorBadSubsystem)
+		}
+		settings.command = ""
+	}
+	if settings.reconnect.enabled() {
+		d.credentialCache = newSSHCredentialCache()
 	}
 	// Start up
 	d.remoteCloseWait.Add(1)
-	go d.remote(userNameStr, addrStr, SSHAuthModes(rData[0]))
+	go d.remote(userName, addr, authModes, settings)
 	return d.local, command.NoFSMError()
 }
 
-func (d *sshClient) confirmRemoteFingerprint(
+func (d *sshConnector) confirmRemoteFingerprint(
 	key ssh.PublicKey,
 	buf []byte,
 ) error {
@@ -451,6 +993,7 @@ func (d *sshClient) confirmRemoteFingerprint(
 	defer d.disableRemoteReadTimeoutRetry()
 	fgp := ssh.FingerprintSHA256(key)
 	fgpLen := copy(buf[d.w.HeaderSize():], fgp)
+	d.fingerprintPending.Add(1)
 	wErr := d.w.SendManual(
 		SSHServerConnectVerifyFingerprint,
 		buf[:d.w.HeaderSize()+fgpLen],
@@ -468,26 +1011,87 @@ func (d *sshClient) confirmRemoteFingerprint(
 	return nil
 }
 
-func (d *sshClient) enableRemoteReadTimeoutRetry() {
+// verifyRemoteFingerprint verifies the host `key` of the remote `host`. The
+// key is checked against the `fingerprint` defined by the Preset first, then
+// the known hosts database, and the user will only be asked to confirm it when
+// neither of those knows about the host
+func (d *sshConnector) verifyRemoteFingerprint(
+	host string,
+	key ssh.PublicKey,
+	fingerprint string,
+	buf []byte,
+) error {
+	if len(fingerprint) > 0 {
+		if ssh.FingerprintSHA256(key) != fingerprint {
+			return ErrSSHRemoteFingerprintMismatched
+		}
+		return nil
+	}
+	if !d.cfg.KnownHosts.Enabled() {
+		return d.confirmRemoteFingerprint(key, buf)
+	}
+	err := d.cfg.KnownHosts.Verify(host, key)
+	if err != command.ErrKnownHostsUnknownHost ||
+		!d.cfg.KnownHosts.AllowUnknown() {
+		return err
+	}
+	err = d.confirmRemoteFingerprint(key, buf)
+	if err != nil || !d.cfg.KnownHosts.Persistent() {
+		return err
+	}
+	if err = d.cfg.KnownHosts.Add(host, key); err != nil {
+		d.l.Warning("Unable to save the host key of %s: %s", host, err)
+	}
+	return nil
+}
+
+// requestKeyPassphrase asks the client for the passphrase of an encrypted
+// private key. The SHA256 fingerprint of the public `key` is sent along with
+// the request (when available) so the user can tell which key it is
+func (d *sshConnector) requestKeyPassphrase(
+	key ssh.PublicKey,
+	buf []byte,
+) ([]byte, error) {
+	d.enableRemoteReadTimeoutRetry()
+	defer d.disableRemoteReadTimeoutRetry()
+	h := SSHServerCredentialKeyPassphrase.makeHeader(buf, d.w.HeaderSize())
+	fgpLen := 0
+	if key != nil {
+		fgpLen = copy(buf[len(h):], ssh.FingerprintSHA256(key))
+	}
+	wErr := d.w.SendManual(
+		SSHServerConnectRequestCredential, buf[:len(h)+fgpLen])
+	if wErr != nil {
+		return nil, wErr
+	}
+	passphrase, passphraseReceived := <-d.credentialReceive
+	if !passphraseReceived {
+		return nil, ErrSSHAuthCancelled
+	}
+	return passphrase, nil
+}
+
+func (d *sshConnector) enableRemoteReadTimeoutRetry() {
 	d.remoteReadTimeoutRetryLock.Lock()
 	defer d.remoteReadTimeoutRetryLock.Unlock()
 	d.remoteReadTimeoutRetry = true
 }
 
-func (d *sshClient) disableRemoteReadTimeoutRetry() {
+func (d *sshConnector) disableRemoteReadTimeoutRetry() {
 	d.remoteReadTimeoutRetryLock.Lock()
 	defer d.remoteReadTimeoutRetryLock.Unlock()
 	d.remoteReadTimeoutRetry = false
 	d.remoteReadForceRetryNextTimeout = true
 }
 
-func (d *sshClient) dialRemote(
+func (d *sshConnector) dialRemote(
+	dial network.Dial,
 	networkName,
 	addr string,
 	config *ssh.ClientConfig) (*ssh.Client, func(), error) {
 	dialCtx, dialCtxCancel := context.WithTimeout(d.baseCtx, config.Timeout)
 	defer dialCtxCancel()
-	conn, err := d.cfg.Dial(dialCtx, networkName, addr)
+	conn, err := dial(dialCtx, networkName, addr)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -525,127 +1129,602 @@ func (d *sshClient) dialRemote(
 	}, nil
 }
 
-func (d *sshClient) remote(
+// sendConnectFailed sends given `err` to the client through the
+// SSHServerConnectFailed signal. Nothing will be sent when reconnecting, as
+// the client is no longer in the connecting phase
+func (d *sshConnector) sendConnectFailed(b []byte, err error) error {
+	if d.reconnecting.Load() {
+		return nil
+	}
+	errLen := copy(b[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
+	return d.w.SendManual(SSHServerConnectFailed, b[:errLen])
+}
+
+// connect runs the Hooks, then dial and authenticate with the remote SSH
+// server. Errors are reported to the client before connect returns.
+//
+// If the remote is already connected by another stream of the same
+// connection, the existing client will be returned instead. Either way, the
+// returned client must be released by calling the returned release function
+// rather than being closed directly.
+//
+// The last returned function must be called once the stream has started using
+// the client successfully. It clears the initial deadlines of the connection
+// and starts keepalive, which only happens once for each client
+func (d *sshConnector) connect(
+	remoteType string,
 	user string,
 	address string,
 	authMethods SSHAuthModes,
-) {
-	u := d.bufferPool.Get()
-	defer d.bufferPool.Put(u)
-	defer func() {
-		d.w.Signal(command.HeaderClose)
-		close(d.remoteConnReceive)
-		d.baseCtxCancel()
-		d.remoteCloseWait.Done()
-	}()
+	b []byte,
+) (*ssh.Client, func(), func(), error) {
 	// Run hooks
 	err := d.hooks.Run(
 		d.baseCtx,
 		configuration.HOOK_BEFORE_CONNECTING,
 		command.NewHookParameters(2).
-			Insert("Remote Type", "SSH").
+			Insert("Remote Type", remoteType).
 			Insert("Remote Address", address),
 		command.NewDefaultHookOutput(d.l, func(
-			b []byte,
+			hb []byte,
 		) (wLen int, wErr error) {
-			wLen = len(b)
-			dLen := copy((*u)[d.w.HeaderSize():], b) + d.w.HeaderSize()
+			wLen = len(hb)
+			if d.reconnecting.Load() {
+				return
+			}
+			dLen := copy(b[d.w.HeaderSize():], hb) + d.w.HeaderSize()
 			wErr = d.w.SendManual(
 				SSHServerHookOutputBeforeConnecting,
-				(*u)[:dLen],
+				b[:dLen],
 			)
 			return
 		}),
 	)
 	if err != nil {
-		errLen := copy((*u)[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
-		d.w.SendManual(SSHServerConnectFailed, (*u)[:errLen])
-		return
+		d.sendConnectFailed(b, err)
+		return nil, nil, nil, err
+	}
+	settings, err := d.remoteSettings(remoteType, user, address)
+	if err != nil {
+		d.sendConnectFailed(b, err)
+		return nil, nil, nil, err
+	}
+	poolKey := sshClientPoolKey(remoteType, user, address, settings.fingerprint)
+	// A shared client has been verified and authenticated by another stream,
+	// so nothing would have been cached for reconnecting. Streams that may
+	// reconnect must connect by themselves
+	if d.credentialCache == nil {
+		conn, release, ready, ok := d.cfg.SSHClients.Get(poolKey)
+		if ok {
+			d.l.Debug("Reusing existing connection to %s", address)
+			// The stream that created the client may fail before it's ready,
+			// so every stream that uses the client has to call `ready`
+			return conn, release, ready, nil
+		}
+	}
+	// Connect through the jump hosts defined by the Preset (if any)
+	jumpHosts := settings.jumpHosts
+	dial := d.cfg.Dial
+	if len(jumpHosts) > 0 {
+		// Jump hosts are defined by the operator, so it's OK for them to
+		// bypass the OnlyAllowPresetRemotes restriction
+		dial = d.cfg.PresetDial
+	}
+	jumpClients := make([]*ssh.Client, 0, len(jumpHosts))
+	clearDeadlines := make([]func(), 0, len(jumpHosts)+1)
+	closeJumpClients := func() {
+		for i := len(jumpClients); i > 0; i-- {
+			jumpClients[i-1].Close()
+		}
+	}
+	for i := range jumpHosts {
+		err = d.sendJumpHostNotice(i, len(jumpHosts)+1, jumpHosts[i].address, b)
+		if err != nil {
+			closeJumpClients()
+			return nil, nil, nil, err
+		}
+		// Credentials of the target host must not be sent to the jump hosts,
+		// only the ones defined by the Preset of the jump host itself are used
+		hop, err := d.remoteSettings(
+			remoteType, jumpHosts[i].user, jumpHosts[i].address)
+		if err != nil {
+			closeJumpClients()
+			d.sendConnectFailed(b, err)
+			return nil, nil, nil, err
+		}
+		jumpClient, clearDeadline, err := d.dialRemote(
+			dial,
+			"tcp",
+			jumpHosts[i].address,
+			d.clientConfig(jumpHosts[i].user, jumpHosts[i].address,
+				hop.fingerprint, hop.credentials, hop.algorithms,
+				authMethods, b),
+		)
+		if err != nil {
+			closeJumpClients()
+			err = fmt.Errorf("unable to connect to jump host %d (%s): %s",
+				i+1, jumpHosts[i].address, err)
+			d.sendConnectFailed(b, err)
+			d.l.Debug("Unable to connect to jump host: %s", err)
+			return nil, nil, nil, err
+		}
+		jumpClients = append(jumpClients, jumpClient)
+		err = d.sendTextNotice(
+			SSHServerNoticeVersion, string(jumpClient.ServerVersion()), b)
+		if err != nil {
+			closeJumpClients()
+			return nil, nil, nil, err
+		}
+		clearDeadlines = append(clearDeadlines, clearDeadline)
+		dial = jumpClient.DialContext
+	}
+	if len(jumpHosts) > 0 {
+		err = d.sendJumpHostNotice(len(jumpHosts), len(jumpHosts)+1, address, b)
+		if err != nil {
+			closeJumpClients()
+			return nil, nil, nil, err
+		}
 	}
 	// Start handling SSH handshake
-	errOutWg := sync.WaitGroup{}
-	defer errOutWg.Wait()
-	conn, clearConnInitialDeadline, err :=
-		d.dialRemote("tcp", address, &ssh.ClientConfig{
-			User: user,
-			Auth: authMethods.build(d, (*u)[:], d.cfg.AuthRetries),
-			HostKeyCallback: func(h string, r net.Addr, k ssh.PublicKey) error {
-				return d.confirmRemoteFingerprint(k, (*u)[:])
-			},
-			Timeout: d.cfg.DialTimeout,
-		})
+	conn, clearConnInitialDeadline, err := d.dialRemote(
+		dial,
+		"tcp",
+		address,
+		d.clientConfig(user, address, settings.fingerprint,
+			settings.credentials, settings.algorithms, authMethods, b),
+	)
 	if err != nil {
-		errLen := copy((*u)[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
-		d.w.SendManual(SSHServerConnectFailed, (*u)[:errLen])
+		closeJumpClients()
+		d.sendConnectFailed(b, err)
 		d.l.Debug("Unable to connect to remote machine: %s", err)
+		return nil, nil, nil, err
+	}
+	err = d.sendTextNotice(
+		SSHServerNoticeVersion, string(conn.ServerVersion()), b)
+	if err != nil {
+		conn.Close()
+		closeJumpClients()
+		return nil, nil, nil, err
+	}
+	if len(jumpClients) > 0 {
+		go func() {
+			conn.Wait()
+			closeJumpClients()
+		}()
+	}
+	clearDeadlines = append(clearDeadlines, clearConnInitialDeadline)
+	startKeepalive := d.keepaliveStarter(conn, settings.keepalive)
+	// The client is ready once the first stream has started using it. Until
+	// then, the initial deadlines are kept to detect an unresponsive remote
+	ready := sync.OnceFunc(func() {
+		for i := range clearDeadlines {
+			clearDeadlines[i]()
+		}
+		startKeepalive()
+	})
+	return conn, d.cfg.SSHClients.Put(poolKey, conn, ready), ready, nil
+}
+
+// sshClientPoolKey returns the key of the client in the SSHClientPool
+func sshClientPoolKey(
+	remoteType string,
+	user string,
+	address string,
+	fingerprint string,
+) string {
+	return remoteType + "\x00" + user + "\x00" + address + "\x00" + fingerprint
+}
+
+// keepaliveStarter returns a function that starts keepalive on `conn`
+func (d *sshConnector) keepaliveStarter(
+	conn *ssh.Client,
+	k configuration.SSHKeepaliveSettings,
+) func() {
+	return func() {
+		// Without the deadline, keepalive is the only way to find out whether
+		// or not the remote is still alive
+		if k.Enabled() {
+			go d.keepalive(conn, k)
+		}
+	}
+}
+
+// sshCredentials contains credentials that will be used by the backend
+// directly, without asking the client
+type sshCredentials struct {
+	secrets map[string]string
+	signers []ssh.Signer
+	cache   *sshCachedCredentials
+}
+
+// sshRemoteSettings contains settings defined by the Preset of a remote
+type sshRemoteSettings struct {
+	jumpHosts   []sshJumpHost
+	fingerprint string
+	credentials sshCredentials
+	keepalive   configuration.SSHKeepaliveSettings
+	algorithms  configuration.SSHAlgorithmSettings
+}
+
+// remoteSettings loads the settings that the Preset defined for the remote
+// at `address`
+func (d *sshConnector) remoteSettings(
+	remoteType string,
+	user string,
+	address string,
+) (sshRemoteSettings, error) {
+	settings := sshRemoteSettings{
+		keepalive:  d.cfg.SSHKeepalive,
+		algorithms: d.cfg.SSHAlgorithms,
+	}
+	preset, ok := d.cfg.Preset(remoteType, address)
+	if !ok {
+		return settings, nil
+	}
+	settings.keepalive, _ = parseSSHKeepalive(preset.Meta, settings.keepalive)
+	settings.algorithms, _ = parseSSHAlgorithms(
+		preset.Meta, settings.algorithms)
+	settings.jumpHosts, _ = parseSSHJumpHosts(preset.Meta[sshJumpHostsMeta])
+	settings.fingerprint = preset.Meta[sshFingerprintMeta]
+	// Only use the credentials to login as the user predefined by the Preset
+	presetUser, presetUserDefined := preset.Meta[sshUserMeta]
+	if presetUserDefined && presetUser != user {
+		return settings, nil
+	}
+	settings.credentials.secrets = preset.Secrets
+	cs, csEnabled, _ := parseSSHCertificateSettings(preset.Meta)
+	if !csEnabled {
+		return settings, nil
+	}
+	signer, err := d.issueCertificate(cs)
+	if err != nil {
+		return settings, fmt.Errorf("unable to issue certificate: %s", err)
+	}
+	settings.credentials.signers = []ssh.Signer{signer}
+	return settings, nil
+}
+
+// issueCertificate issues a certificate through the built-in certificate
+// authority for the current web user
+func (d *sshConnector) issueCertificate(
+	cs sshCertificateSettings,
+) (ssh.Signer, error) {
+	if !d.cfg.CertificateAuthority.Enabled() {
+		return nil, ErrSSHCertificateAuthorityUnavailable
+	}
+	principals := make([]string, len(cs.principals))
+	for i := range cs.principals {
+		if cs.principals[i] != sshCertificateWebUserPrincipal {
+			principals[i] = cs.principals[i]
+			continue
+		}
+		if len(d.cfg.WebUser) <= 0 {
+			return nil, ErrSSHWebUserUnknown
+		}
+		principals[i] = d.cfg.WebUser
+	}
+	signer, err := d.cfg.CertificateAuthority.Issue(command.CertificateRequest{
+		KeyID:      "sshwifty:" + d.cfg.WebUser,
+		Principals: principals,
+		Validity:   cs.validity,
+		Extensions: cs.extensions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.l.Debug("Issued certificate for principals %q, valid for %s",
+		principals, cs.validity)
+	return signer, nil
+}
+
+// clientConfig builds the ssh.ClientConfig used to connect to a SSH server.
+// If `fingerprint` is not empty, the server must present a host key of that
+// fingerprint. Credentials in `creds` will be used without asking the client.
+//
+// When reconnecting, the server must present the same host key it presented
+// during the first connection
+func (d *sshConnector) clientConfig(
+	user string,
+	address string,
+	fingerprint string,
+	creds sshCredentials,
+	algorithms configuration.SSHAlgorithmSettings,
+	authMethods SSHAuthModes,
+	b []byte,
+) *ssh.ClientConfig {
+	creds.cache = d.credentialCache.of(user, address)
+	return &ssh.ClientConfig{
+		Config: ssh.Config{
+			Ciphers:      algorithms.Ciphers,
+			KeyExchanges: algorithms.KeyExchanges,
+			MACs:         algorithms.MACs,
+		},
+		User: user,
+		Auth: authMethods.build(d, creds, b, d.cfg.AuthRetries),
+		HostKeyCallback: func(h string, r net.Addr, k ssh.PublicKey) error {
+			if d.reconnecting.Load() {
+				return d.credentialCache.verifyHostKey(h, k)
+			}
+			err := d.verifyRemoteFingerprint(h, k, fingerprint, b)
+			if err == nil {
+				d.credentialCache.saveHostKey(h, k)
+			}
+			return err
+		},
+		BannerCallback: func(message string) error {
+			return d.sendTextNotice(SSHServerNoticeBanner, message, b)
+		},
+		HostKeyAlgorithms: algorithms.HostKeyAlgorithms,
+		Timeout:           d.cfg.DialTimeout,
+	}
+}
+
+// sendJumpHostNotice tells the client which host is being connected, so the
+// following fingerprint verification and credential requests can be related
+// to that host
+//
+// Notice data format:
+// +-----------+-----------------+---------+
+// | 1 byte    | 1 byte          | n bytes |
+// +-----------+-----------------+---------+
+// | Hop index | Total hop count | Address |
+// +-----------+-----------------+---------+
+//
+// The last hop is the final destination
+func (d *sshConnector) sendJumpHostNotice(
+	hop int,
+	hops int,
+	address string,
+	b []byte,
+) error {
+	h := SSHServerNoticeJumpHost.makeHeader(b, d.w.HeaderSize())
+	b[len(h)] = byte(hop)
+	b[len(h)+1] = byte(hops)
+	aLen := copy(b[len(h)+2:], address)
+	return d.w.SendManual(SSHServerNotice, b[:len(h)+2+aLen])
+}
+
+// sendTextNotice sends the text `s` to the client as a notice of type `t`. It
+// is used for the banner (which is sent by the remote before authentication,
+// and often contains legal notices that must be shown to the user) and the
+// version string of the remote
+//
+// Notice data format:
+// +---------+
+// | n bytes |
+// +---------+
+// | Text    |
+// +---------+
+//
+// The text will be truncated if it's too long to fit into one notice. Nothing
+// will be sent when reconnecting, as the client has already received them
+func (d *sshConnector) sendTextNotice(
+	t sshNoticeType,
+	s string,
+	b []byte,
+) error {
+	if d.reconnecting.Load() {
+		return nil
+	}
+	h := t.makeHeader(b, d.w.HeaderSize())
+	tLen := copy(b[len(h):], s)
+	return d.w.SendManual(SSHServerNotice, b[:len(h)+tLen])
+}
+
+// sendExitStatus tells the client how the remote command has exited, `err` is
+// the error returned by the `Wait` method of the session. See
+// marshalSSHExitStatus for the data format
+func (d *sshConnector) sendExitStatus(err error, b []byte) error {
+	h := SSHServerNoticeExitStatus.makeHeader(b, d.w.HeaderSize())
+	n, mErr := marshalSSHExitStatus(err, b[len(h):])
+	if mErr != nil {
+		return mErr
+	}
+	return d.w.SendManual(SSHServerNotice, b[:len(h)+n])
+}
+
+// marshalSSHExitStatus writes the exit status built from `err` (returned by
+// the `Wait` method of the session) into `b`
+//
+// Exit status format:
+// +-------------+-------------+---------------+
+// | 4 bytes     | String      | String        |
+// +-------------+-------------+---------------+
+// | Exit status | Exit signal | Error message |
+// +-------------+-------------+---------------+
+//
+// The exit status is a signed integer in big-endian order, it will be -1 when
+// the remote didn't report one (i.e. the connection was lost). The exit signal
+// is empty when the command was not terminated by a signal
+func marshalSSHExitStatus(err error, b []byte) (int, error) {
+	status, signal, message := int32(0), "", ""
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		status = int32(exitErr.ExitStatus())
+		signal = exitErr.Signal()
+		message = exitErr.Msg()
+	default:
+		status = sshUnknownExitStatus
+		message = err.Error()
+	}
+	binary.BigEndian.PutUint32(b, uint32(status))
+	n := 4
+	for _, str := range []string{signal, message} {
+		str = sshTruncate(str, sshMaxNoticeTextLen)
+		sLen, sErr := MarshalString(str, b[n:])
+		if sErr != nil {
+			return 0, sErr
+		}
+		n += sLen
+	}
+	return n, nil
+}
+
+// sshTruncate returns the first `max` bytes of `s`
+func sshTruncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max]
+}
+
+// remote connects to the remote and serves the session. If the connection is
+// lost after the session has been established, it will be re-established
+// according to the reconnect policy before giving up
+func (d *sshClient) remote(
+	user string,
+	address string,
+	authMethods SSHAuthModes,
+	settings sshSessionSettings,
+) {
+	u := d.bufferPool.Get()
+	defer d.bufferPool.Put(u)
+	defer func() {
+		d.w.Signal(command.HeaderClose)
+		close(d.remoteConnReceive)
+		d.baseCtxCancel()
+		d.remoteCloseWait.Done()
+	}()
+	served, exitErr := d.serve(user, address, authMethods, settings, 0, (*u)[:])
+	if !served {
 		return
 	}
-	defer conn.Close()
+	policy := settings.reconnect
+	for attempt := 1; attempt <= policy.attempts; attempt++ {
+		if !d.shouldReconnect(exitErr) {
+			break
+		}
+		d.l.Debug("Connection lost, reconnecting (attempt %d): %s",
+			attempt, exitErr)
+		err := d.sendReconnectNotice(
+			SSHReconnectStateReconnecting, attempt, exitErr.Error(), (*u)[:])
+		if err != nil {
+			return
+		}
+		if !d.waitReconnect(policy.backoff(attempt)) {
+			return
+		}
+		d.reconnecting.Store(true)
+		served, err = d.serve(
+			user, address, authMethods, settings, attempt, (*u)[:])
+		if served {
+			attempt = 0
+		} else if err == nil {
+			return
+		}
+		exitErr = err
+	}
+	d.sendExitStatus(exitErr, (*u)[:])
+}
+
+// shouldReconnect returns whether or not the session that ended with `err`
+// should be re-established. Sessions that exited normally are never
+// re-established
+func (d *sshClient) shouldReconnect(err error) bool {
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &exitErr):
+		return false
+	case errors.Is(err, ErrSSHRemoteHostKeyChanged):
+		return false
+	default:
+		return d.baseCtx.Err() == nil
+	}
+}
+
+// serve connects to the remote, then relays the session until it ends. The
+// `attempt` is the number of the reconnect attempt, or 0 for the first
+// connection.
+//
+// It returns true and the error returned by the session once an established
+// session has ended. Otherwise, the returned error is the reason why the
+// session couldn't be established, or nil if the client is gone
+func (d *sshClient) serve(
+	user string,
+	address string,
+	authMethods SSHAuthModes,
+	settings sshSessionSettings,
+	attempt int,
+	b []byte,
+) (bool, error) {
+	errOutWg := sync.WaitGroup{}
+	defer errOutWg.Wait()
+	conn, release, ready, err :=
+		d.connect(sshPresetType, user, address, authMethods, b)
+	if err != nil {
+		return false, err
+	}
+	defer release()
 	// Open new session
 	session, err := conn.NewSession()
 	if err != nil {
-		errLen := copy((*u)[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
-		d.w.SendManual(SSHServerConnectFailed, (*u)[:errLen])
+		d.sendConnectFailed(b, err)
 		d.l.Debug("Unable open new session on remote machine: %s", err)
-		return
+		return false, err
 	}
 	defer session.Close()
 	in, err := session.StdinPipe()
 	if err != nil {
-		errLen := copy((*u)[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
-		d.w.SendManual(SSHServerConnectFailed, (*u)[:errLen])
+		d.sendConnectFailed(b, err)
 		d.l.Debug("Unable export Stdin pipe: %s", err)
-		return
+		return false, err
 	}
 	out, err := session.StdoutPipe()
 	if err != nil {
-		errLen := copy((*u)[d.w.HeaderSize():], err.Error()) +
-			d.w.HeaderSize()
-		d.w.SendManual(SSHServerConnectFailed, (*u)[:errLen])
+		d.sendConnectFailed(b, err)
 		d.l.Debug("Unable export Stdout pipe: %s", err)
-		return
+		return false, err
 	}
 	errOut, err := session.StderrPipe()
 	if err != nil {
-		errLen := copy((*u)[d.w.HeaderSize():], err.Error()) +
-			d.w.HeaderSize()
-		d.w.SendManual(SSHServerConnectFailed, (*u)[:errLen])
+		d.sendConnectFailed(b, err)
 		d.l.Debug("Unable export Stderr pipe: %s", err)
-		return
+		return false, err
 	}
-	// Open new terminal
-	err = session.RequestPty("xterm-256color", 80, 40, ssh.TerminalModes{
-		ssh.ECHO:          1,
-		ssh.TTY_OP_ISPEED: 14400,
-		ssh.TTY_OP_OSPEED: 14400,
-	})
-	if err != nil {
-		errLen := copy((*u)[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
-		d.w.SendManual(SSHServerConnectFailed, (*u)[:errLen])
-		d.l.Debug("Unable request PTY: %s", err)
-		return
+	if size := d.termSize.Load(); size > 0 {
+		// The client terminal may have been resized since Bootup
+		settings.term.height = int(size >> 16)
+		settings.term.width = int(size & 0xffff)
 	}
-	err = session.Shell()
+	err = d.startSession(session, settings)
 	if err != nil {
-		errLen := copy((*u)[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
-		d.w.SendManual(SSHServerConnectFailed, (*u)[:errLen])
-		d.l.Debug("Unable to start Shell: %s", err)
-		return
+		d.sendConnectFailed(b, err)
+		return false, err
+	}
+	if len(settings.startup) > 0 {
+		// Type the startup command into the Shell as if the user did
+		_, err = io.WriteString(in, settings.startup+"\r")
+		if err != nil {
+			d.sendConnectFailed(b, err)
+			d.l.Debug("Unable to send startup command: %s", err)
+			return false, err
+		}
 	}
-	defer session.Wait()
-	clearConnInitialDeadline()
-	d.remoteConnReceive <- sshRemoteConn{
+	ready()
+	d.setRemote(sshRemoteConn{
 		writer: in,
 		closer: func() error {
 			session.Close()
+			release()
 
-			return conn.Close()
+			return nil
 		},
 		session: session,
+	})
+	var wErr error
+	if attempt > 0 {
+		d.reconnecting.Store(false)
+		wErr = d.sendReconnectNotice(
+			SSHReconnectStateReconnected, attempt, "", b)
+	} else {
+		wErr = d.w.SendManual(SSHServerConnectSucceed, b[:d.w.HeaderSize()])
 	}
-	wErr := d.w.SendManual(SSHServerConnectSucceed, (*u)[:d.w.HeaderSize()])
 	if wErr != nil {
-		return
+		return false, nil
 	}
 	d.l.Debug("Serving")
 	errOutWg.Go(func() {
@@ -664,20 +1743,87 @@ func (d *sshClient) remote(
 		}
 	})
 	for {
-		rLen, rErr := out.Read((*u)[d.w.HeaderSize():])
+		rLen, rErr := out.Read(b[d.w.HeaderSize():])
 		if rErr != nil {
-			return
+			break
 		}
-		rErr = d.w.SendManual(
-			SSHServerRemoteStdOut, (*u)[:d.w.HeaderSize()+rLen])
+		rErr = d.w.SendManual(SSHServerRemoteStdOut, b[:d.w.HeaderSize()+rLen])
 		if rErr != nil {
-			return
+			return false, nil
 		}
 	}
+	// Report the exit status after all output has been sent
+	errOutWg.Wait()
+	exitErr := session.Wait()
+	if kErr := d.keepaliveError(); kErr != nil {
+		exitErr = kErr
+	}
+	return true, exitErr
 }
 
+// startSession starts the subsystem, the command or the interactive Shell on
+// the remote
+func (d *sshClient) startSession(
+	session *ssh.Session,
+	settings sshSessionSettings,
+) error {
+	for name, value := range settings.environment {
+		// It's ok for it to fail, as the remote may not accept the variable
+		if err := session.Setenv(name, value); err != nil {
+			d.l.Debug("Unable to set environment variable %q: %s", name, err)
+		}
+	}
+	if len(settings.subsystem) > 0 {
+		err := session.RequestSubsystem(settings.subsystem)
+		if err != nil {
+			d.l.Debug("Unable to request subsystem %q: %s",
+				settings.subsystem, err)
+		}
+		return err
+	}
+	if len(settings.command) > 0 {
+		// No PTY, so the output will not be altered by the remote terminal
+		err := session.Start(settings.command)
+		if err != nil {
+			d.l.Debug("Unable to start command: %s", err)
+		}
+		return err
+	}
+	term := settings.term
+	err := session.RequestPty(
+		term.termType, term.height, term.width, term.modes)
+	if err != nil {
+		d.l.Debug("Unable request PTY: %s", err)
+		return err
+	}
+	err = session.Shell()
+	if err != nil {
+		d.l.Debug("Unable to start Shell: %s", err)
+	}
+	return err
+}
+
+// setRemote hands the `remote` over to the local side, replacing the one that
+// hasn't been picked up yet
+func (d *sshClient) setRemote(remote sshRemoteConn) {
+	select {
+	case <-d.remoteConnReceive:
+	default:
+	}
+	d.remoteConnReceive <- remote
+}
+
+// getRemote returns the current remote, which may be replaced when the
+// connection is re-established
 func (d *sshClient) getRemote() (sshRemoteConn, error) {
 	if d.remoteConn.isValid() {
+		select {
+		case remoteConn, ok := <-d.remoteConnReceive:
+			if ok {
+				d.remoteConn = remoteConn
+			}
+		default:
+		}
 		return d.remoteConn, nil
 	}
 	remoteConn, remoteConnFetched := <-d.remoteConnReceive
@@ -728,6 +1874,8 @@ func (d *sshClient) local(
 		cols := int(b[2])
 		cols <<= 8
 		cols |= int(b[3])
+		// Remember it so the size can be restored after reconnect
+		d.termSize.Store(uint32(rows)<<16 | uint32(cols))
 		// It's ok for it to fail
 		wcErr := remote.session.WindowChange(rows, cols)
 		if wcErr != nil {
@@ -736,51 +1884,64 @@ func (d *sshClient) local(
 		d.credentialProcessed = true
 		return nil
 	case SSHClientRespondFingerprint:
-		if d.fingerprintProcessed {
-			return ErrSSHUnexpectedFingerprintVerificationRespond
+		confirmed, err := d.respondFingerprint(r)
+		if err != nil {
+			return err
 		}
-		d.fingerprintProcessed = true
-		rData, rErr := rw.FetchOneByte(r.Fetch)
-		if rErr != nil {
-			return rErr
-		}
-		confirmed := rData[0] == 0
 		if !confirmed {
-			d.fingerprintVerifyResultReceive <- false
 			remote, remoteErr := d.getRemote()
 			if remoteErr == nil {
 				remote.closer()
 			}
-		} else {
-			d.fingerprintVerifyResultReceive <- true
 		}
 		return nil
 	case SSHClientRespondCredential:
-		if d.credentialProcessed {
-			return ErrSSHUnexpectedCredentialDataRespond
-		}
-		sshCredentialBufSize := min(r.Remains(), sshCredentialMaxSize)
-		credentialDataBuf := make([]byte, 0, sshCredentialBufSize)
-		totalCredentialRead := 0
-		for !r.Completed() {
-			rData, rErr := r.Buffered()
-			if rErr != nil {
-				return rErr
-			}
-			totalCredentialRead += len(rData)
-			if totalCredentialRead > sshCredentialBufSize {
-				return ErrSSHCredentialDataTooLarge
-			}
-			credentialDataBuf = append(credentialDataBuf, rData...)
-		}
-		d.credentialReceive <- credentialDataBuf
-		return nil
+		return d.respondCredential(r)
 	default:
 		return ErrSSHUnknownClientSignal
 	}
 }
 
-func (d *sshClient) Close() error {
+// respondFingerprint handles the SSHClientRespondFingerprint signal and returns
+// whether or not the fingerprint has been confirmed by the client
+func (d *sshConnector) respondFingerprint(r *rw.LimitedReader) (bool, error) {
+	if d.fingerprintProcessed || d.fingerprintPending.Add(-1) < 0 {
+		return false, ErrSSHUnexpectedFingerprintVerificationRespond
+	}
+	rData, rErr := rw.FetchOneByte(r.Fetch)
+	if rErr != nil {
+		return false, rErr
+	}
+	confirmed := rData[0] == 0
+	d.fingerprintVerifyResultReceive <- confirmed
+	return confirmed, nil
+}
+
+// respondCredential handles the SSHClientRespondCredential signal
+func (d *sshConnector) respondCredential(r *rw.LimitedReader) error {
+	if d.credentialProcessed {
+		return ErrSSHUnexpectedCredentialDataRespond
+	}
+	sshCredentialBufSize := min(r.Remains(), sshCredentialMaxSize)
+	credentialDataBuf := make([]byte, 0, sshCredentialBufSize)
+	totalCredentialRead := 0
+	for !r.Completed() {
+		rData, rErr := r.Buffered()
+		if rErr != nil {
+			return rErr
+		}
+		totalCredentialRead += len(rData)
+		if totalCredentialRead > sshCredentialBufSize {
+			return ErrSSHCredentialDataTooLarge
+		}
+		credentialDataBuf = append(credentialDataBuf, rData...)
+	}
+	d.credentialReceive <- credentialDataBuf
+	return nil
+}
+
+// close stops the connecting phase so no more client respond will be accepted
+func (d *sshConnector) close() {
 	d.credentialProcessed = true
 	d.fingerprintProcessed = true
 	if !d.credentialReceiveClosed {
@@ -791,11 +1952,17 @@ func (d *sshClient) Close() error {
 		close(d.fingerprintVerifyResultReceive)
 		d.fingerprintVerifyResultReceiveClosed = true
 	}
+}
+
+func (d *sshClient) Close() error {
+	d.close()
+	// Cancel first, so the closing of the remote will not be mistaken as a
+	// lost connection that must be re-established
+	d.baseCtxCancel()
 	remote, remoteErr := d.getRemote()
 	if remoteErr == nil {
 		remote.closer()
 	}
-	d.baseCtxCancel()
 	d.remoteCloseWait.Wait()
 
 	return nil
@@ -0,0 +1,60 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"fmt"
+
+	"github.com/nirui/sshwifty/application/configuration"
+)
+
+const (
+	sshCiphersMeta           = "Ciphers"
+	sshKeyExchangesMeta      = "Key Exchanges"
+	sshMACsMeta              = "MACs"
+	sshHostKeyAlgorithmsMeta = "Host Key Algorithms"
+)
+
+// parseSSHAlgorithms overrides the default algorithm settings `a` with the
+// ones defined in Preset `meta`
+func parseSSHAlgorithms(
+	meta map[string]string,
+	a configuration.SSHAlgorithmSettings,
+) (configuration.SSHAlgorithmSettings, error) {
+	for _, l := range []struct {
+		name string
+		list *[]string
+	}{
+		{sshCiphersMeta, &a.Ciphers},
+		{sshKeyExchangesMeta, &a.KeyExchanges},
+		{sshMACsMeta, &a.MACs},
+		{sshHostKeyAlgorithmsMeta, &a.HostKeyAlgorithms},
+	} {
+		v, ok := meta[l.name]
+		if !ok {
+			continue
+		}
+		if *l.list = splitSSHMetaList(v); len(*l.list) <= 0 {
+			return a, fmt.Errorf("invalid %q Meta: must not be empty", l.name)
+		}
+	}
+	if err := a.Verify(); err != nil {
+		return a, fmt.Errorf("invalid algorithm Meta: %s", err)
+	}
+	return a, nil
+}
@@ -0,0 +1,67 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/nirui/sshwifty/application/configuration"
+)
+
+func TestParseSSHAlgorithms(t *testing.T) {
+	defaults := configuration.SSHAlgorithmSettings{
+		Ciphers: []string{"aes256-gcm@openssh.com"},
+		MACs:    []string{"hmac-sha2-256"},
+	}
+	a, err := parseSSHAlgorithms(map[string]string{
+		sshCiphersMeta:      "aes128-cbc, aes128-ctr",
+		sshKeyExchangesMeta: "diffie-hellman-group1-sha1",
+	}, defaults)
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if !slices.Equal(a.Ciphers, []string{"aes128-cbc", "aes128-ctr"}) {
+		t.Errorf("Unexpected ciphers: %v", a.Ciphers)
+		return
+	}
+	if !slices.Equal(a.KeyExchanges, []string{"diffie-hellman-group1-sha1"}) {
+		t.Errorf("Unexpected key exchanges: %v", a.KeyExchanges)
+		return
+	}
+	if !slices.Equal(a.MACs, defaults.MACs) {
+		t.Errorf("Expecting the default MACs %v, got %v instead",
+			defaults.MACs, a.MACs)
+		return
+	}
+}
+
+func TestParseSSHAlgorithmsInvalid(t *testing.T) {
+	for _, meta := range []map[string]string{
+		{sshCiphersMeta: "rot13"},
+		{sshMACsMeta: ""},
+		{sshHostKeyAlgorithmsMeta: "ssh-ed25519,ssh-unknown"},
+	} {
+		_, err := parseSSHAlgorithms(meta, configuration.SSHAlgorithmSettings{})
+		if err == nil {
+			t.Errorf("Expecting %v to be invalid", meta)
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/configuration"
+)
+
+const (
+	sshKeepaliveIntervalMeta  = "Keepalive Interval"
+	sshKeepaliveMaxMissedMeta = "Keepalive Max Missed"
+	sshKeepaliveRequest       = "keepalive@openssh.com"
+)
+
+// parseSSHKeepalive overrides the default keepalive settings `k` with the ones
+// defined in Preset `meta`
+func parseSSHKeepalive(
+	meta map[string]string,
+	k configuration.SSHKeepaliveSettings,
+) (configuration.SSHKeepaliveSettings, error) {
+	if v, ok := meta[sshKeepaliveIntervalMeta]; ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || interval < 0 {
+			return k, fmt.Errorf(
+				"invalid %q Meta: must be a duration such as \"30s\", or "+
+					"\"0s\" to disable keepalive", sshKeepaliveIntervalMeta)
+		}
+		k.Interval = interval
+	}
+	if v, ok := meta[sshKeepaliveMaxMissedMeta]; ok {
+		maxMissed, err := strconv.ParseUint(strings.TrimSpace(v), 10, 16)
+		if err != nil || maxMissed <= 0 {
+			return k, fmt.Errorf("invalid %q Meta: must be a positive integer",
+				sshKeepaliveMaxMissedMeta)
+		}
+		k.MaxMissed = int(maxMissed)
+	}
+	return k, nil
+}
+
+// keepalive periodically sends keepalive requests to the remote, and closes
+// the `conn` when too many of them are left unanswered. It returns when the
+// `conn` is closed. As keepalive is only started once for each client, it
+// keeps running after the stream that started it has been closed in case the
+// client is still shared with other streams
+func (d *sshConnector) keepalive(
+	conn *ssh.Client,
+	k configuration.SSHKeepaliveSettings,
+) {
+	ticker := time.NewTicker(k.Interval)
+	defer ticker.Stop()
+	replied := make(chan struct{}, 1)
+	closed := make(chan struct{})
+	go func() {
+		conn.Wait()
+		close(closed)
+	}()
+	pending, missed := false, 0
+	for {
+		select {
+		case <-closed:
+			return
+		case <-replied:
+			pending, missed = false, 0
+		case <-ticker.C:
+			if !pending {
+				pending = true
+				go func() {
+					// Any reply (even a failure) means the remote is alive
+					_, _, err := conn.SendRequest(
+						sshKeepaliveRequest, true, nil)
+					if err == nil {
+						replied <- struct{}{}
+					}
+				}()
+				continue
+			}
+			missed++
+			if missed < k.MaxMissed {
+				continue
+			}
+			select {
+			case d.keepaliveFailure <- fmt.Errorf(
+				"remote did not respond to keepalive requests for %s",
+				time.Duration(missed)*k.Interval):
+			default:
+			}
+			d.l.Debug("Remote is unresponsive, disconnecting")
+			conn.Close()
+			return
+		}
+	}
+}
+
+// keepaliveError returns the error that caused the connection to be closed by
+// keepalive, or nil if the connection wasn't closed by it
+func (d *sshConnector) keepaliveError() error {
+	select {
+	case err := <-d.keepaliveFailure:
+		return err
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,141 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+func TestParseSSHKeepalive(t *testing.T) {
+	defaults := configuration.SSHKeepaliveSettings{
+		Interval:  time.Minute,
+		MaxMissed: 3,
+	}
+	k, err := parseSSHKeepalive(map[string]string{}, defaults)
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if k != defaults {
+		t.Errorf("Expecting the default settings %v, got %v instead",
+			defaults, k)
+		return
+	}
+	k, err = parseSSHKeepalive(map[string]string{
+		sshKeepaliveIntervalMeta:  "15s",
+		sshKeepaliveMaxMissedMeta: "5",
+	}, defaults)
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if k.Interval != 15*time.Second || k.MaxMissed != 5 {
+		t.Errorf("Unexpected settings: %v", k)
+		return
+	}
+	k, err = parseSSHKeepalive(map[string]string{
+		sshKeepaliveIntervalMeta: "0s",
+	}, defaults)
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if k.Enabled() {
+		t.Error("Expecting keepalive to be disabled")
+		return
+	}
+}
+
+func TestParseSSHKeepaliveInvalid(t *testing.T) {
+	for _, meta := range []map[string]string{
+		{sshKeepaliveIntervalMeta: "-1s"},
+		{sshKeepaliveIntervalMeta: "soon"},
+		{sshKeepaliveMaxMissedMeta: "0"},
+		{sshKeepaliveMaxMissedMeta: "many"},
+	} {
+		_, err := parseSSHKeepalive(meta, configuration.SSHKeepaliveSettings{})
+		if err == nil {
+			t.Errorf("Expecting %v to be invalid", meta)
+			return
+		}
+	}
+}
+
+// testSSHUnresponsiveClient returns a client connected to a SSH server that
+// never answers any global request
+func testSSHUnresponsiveClient(t *testing.T) *ssh.Client {
+	addr := testSSHServer(t, &ssh.ServerConfig{NoClientAuth: true}, func(
+		conn *ssh.ServerConn,
+		chans <-chan ssh.NewChannel,
+		reqs <-chan *ssh.Request,
+	) {
+		go func() {
+			for range chans {
+			}
+		}()
+		// Receive the requests without ever replying to them
+		for range reqs {
+		}
+	})
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal("Failed to connect:", err)
+	}
+	return client
+}
+
+func TestSSHKeepaliveUnresponsiveRemote(t *testing.T) {
+	client := testSSHUnresponsiveClient(t)
+	defer client.Close()
+	d := newSSHConnector(
+		log.NewDitch(), command.Hooks{}, command.StreamResponder{},
+		command.Configuration{}, nil)
+	defer d.baseCtxCancel()
+	done := make(chan struct{})
+	go func() {
+		d.keepalive(client, configuration.SSHKeepaliveSettings{
+			Interval:  10 * time.Millisecond,
+			MaxMissed: 2,
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("Keepalive did not detect the unresponsive remote")
+		return
+	}
+	if d.keepaliveError() == nil {
+		t.Error("Expecting an error to be reported")
+		return
+	}
+	if client.Wait() == nil {
+		t.Error("Expecting the connection to be closed with an error")
+		return
+	}
+}
@@ -0,0 +1,305 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshReconnectState is the state carried by the SSHServerNoticeReconnect
+// notice
+type sshReconnectState byte
+
+const (
+	SSHReconnectStateReconnecting sshReconnectState = 0x00
+	SSHReconnectStateReconnected  sshReconnectState = 0x01
+)
+
+const (
+	sshReconnectAttemptsMeta     = "Reconnect Attempts"
+	sshReconnectDelayMeta        = "Reconnect Delay"
+	sshDefaultReconnectDelay     = 1 * time.Second
+	sshMaxReconnectDelay         = 1 * time.Minute
+	sshMaxReconnectAttempts      = 0xff
+	sshCachedAnswersKeySeparator = "\x00"
+)
+
+// sshReconnectPolicy decides whether and when to re-establish the connection
+// after it's been lost
+type sshReconnectPolicy struct {
+	attempts int
+	delay    time.Duration
+}
+
+// parseSSHReconnectPolicy parses the reconnect policy defined in Preset `meta`.
+// Reconnect is disabled unless "Reconnect Attempts" is defined
+func parseSSHReconnectPolicy(
+	meta map[string]string,
+) (sshReconnectPolicy, error) {
+	p := sshReconnectPolicy{attempts: 0, delay: sshDefaultReconnectDelay}
+	if v, ok := meta[sshReconnectAttemptsMeta]; ok {
+		attempts, err := strconv.ParseUint(strings.TrimSpace(v), 10, 8)
+		if err != nil {
+			return p, fmt.Errorf("invalid %q Meta: must be an integer "+
+				"between 0 and %d", sshReconnectAttemptsMeta,
+				sshMaxReconnectAttempts)
+		}
+		p.attempts = int(attempts)
+	}
+	if v, ok := meta[sshReconnectDelayMeta]; ok {
+		delay, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || delay <= 0 {
+			return p, fmt.Errorf(
+				"invalid %q Meta: must be a positive duration such as \"1s\"",
+				sshReconnectDelayMeta)
+		}
+		p.delay = delay
+	}
+	return p, nil
+}
+
+// enabled returns whether or not reconnect is enabled
+func (p sshReconnectPolicy) enabled() bool {
+	return p.attempts > 0
+}
+
+// backoff returns how long to wait before the given `attempt` (starts from 1).
+// The delay is doubled after each attempt, up to sshMaxReconnectDelay
+func (p sshReconnectPolicy) backoff(attempt int) time.Duration {
+	delay := p.delay
+	for i := 1; i < attempt && delay < sshMaxReconnectDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, sshMaxReconnectDelay)
+}
+
+// sshCredentialCache keeps the verified host keys and the credentials used to
+// login to the remotes in memory, so the connection can be re-established
+// later without asking the user again. It's never persisted, and lives only
+// as long as the stream
+type sshCredentialCache struct {
+	lock        sync.Mutex
+	hostKeys    map[string][]byte
+	credentials map[string]*sshCachedCredentials
+}
+
+// sshCachedCredentials contains the credentials used to login to one remote
+type sshCachedCredentials struct {
+	lock        *sync.Mutex
+	password    string
+	hasPassword bool
+	signers     []ssh.Signer
+	answers     map[string][]string
+}
+
+func newSSHCredentialCache() *sshCredentialCache {
+	return &sshCredentialCache{
+		lock:        sync.Mutex{},
+		hostKeys:    make(map[string][]byte),
+		credentials: make(map[string]*sshCachedCredentials),
+	}
+}
+
+// saveHostKey remembers the verified host `key` of `host`
+func (c *sshCredentialCache) saveHostKey(host string, key ssh.PublicKey) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.hostKeys[host] = key.Marshal()
+}
+
+// verifyHostKey returns an error unless the host `key` is the same one that
+// has been verified before
+func (c *sshCredentialCache) verifyHostKey(
+	host string,
+	key ssh.PublicKey,
+) error {
+	if c == nil {
+		return ErrSSHRemoteHostKeyChanged
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	known, ok := c.hostKeys[host]
+	if !ok || !bytes.Equal(known, key.Marshal()) {
+		return ErrSSHRemoteHostKeyChanged
+	}
+	return nil
+}
+
+// of returns the cached credentials of `user` at remote `address`, or nil if
+// the cache is disabled
+func (c *sshCredentialCache) of(
+	user string,
+	address string,
+) *sshCachedCredentials {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := user + "@" + address
+	cc, ok := c.credentials[key]
+	if !ok {
+		cc = &sshCachedCredentials{
+			lock:    &c.lock,
+			answers: make(map[string][]string),
+		}
+		c.credentials[key] = cc
+	}
+	return cc
+}
+
+func (c *sshCachedCredentials) savePassword(password string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.password, c.hasPassword = password, true
+}
+
+func (c *sshCachedCredentials) cachedPassword() (string, error) {
+	if c == nil {
+		return "", ErrSSHReconnectCredentialUnavailable
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.hasPassword {
+		return "", ErrSSHReconnectCredentialUnavailable
+	}
+	return c.password, nil
+}
+
+func (c *sshCachedCredentials) saveSigners(signers []ssh.Signer) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, s := range signers {
+		key := s.PublicKey().Marshal()
+		if !slices.ContainsFunc(c.signers, func(cs ssh.Signer) bool {
+			return bytes.Equal(cs.PublicKey().Marshal(), key)
+		}) {
+			c.signers = append(c.signers, s)
+		}
+	}
+}
+
+func (c *sshCachedCredentials) cachedSigners() ([]ssh.Signer, error) {
+	if c == nil {
+		return nil, ErrSSHReconnectCredentialUnavailable
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.signers) <= 0 {
+		return nil, ErrSSHReconnectCredentialUnavailable
+	}
+	return slices.Clone(c.signers), nil
+}
+
+// saveAnswers remembers the `answers` given to a KeyboardInteractive challenge
+func (c *sshCachedCredentials) saveAnswers(
+	name string,
+	instruction string,
+	questions []string,
+	answers []string,
+) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.answers[sshCachedAnswersKey(name, instruction, questions)] = answers
+}
+
+// cachedAnswers returns the answers previously given to the same
+// KeyboardInteractive challenge. Challenges that are different every time
+// (i.e. one-time passwords) can't be answered
+func (c *sshCachedCredentials) cachedAnswers(
+	name string,
+	instruction string,
+	questions []string,
+) ([]string, error) {
+	if c == nil {
+		return nil, ErrSSHReconnectCredentialUnavailable
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	answers, ok := c.answers[sshCachedAnswersKey(name, instruction, questions)]
+	if !ok {
+		return nil, ErrSSHReconnectCredentialUnavailable
+	}
+	return answers, nil
+}
+
+func sshCachedAnswersKey(
+	name string,
+	instruction string,
+	questions []string,
+) string {
+	return strings.Join(append([]string{name, instruction}, questions...),
+		sshCachedAnswersKeySeparator)
+}
+
+// sendReconnectNotice tells the client about the progress of reconnecting
+//
+// Notice data format:
+// +--------+---------+---------+
+// | 1 byte | 1 byte  | n bytes |
+// +--------+---------+---------+
+// | State  | Attempt | Reason  |
+// +--------+---------+---------+
+//
+// The reason is the error that caused the reconnect, and is only sent with the
+// SSHReconnectStateReconnecting state
+func (d *sshConnector) sendReconnectNotice(
+	state sshReconnectState,
+	attempt int,
+	reason string,
+	b []byte,
+) error {
+	h := SSHServerNoticeReconnect.makeHeader(b, d.w.HeaderSize())
+	b[len(h)] = byte(state)
+	b[len(h)+1] = byte(attempt)
+	rLen := copy(b[len(h)+2:], sshTruncate(reason, sshMaxNoticeTextLen))
+	return d.w.SendManual(SSHServerNotice, b[:len(h)+2+rLen])
+}
+
+// waitReconnect waits for `delay`, and returns false if the stream has been
+// closed before that
+func (d *sshConnector) waitReconnect(delay time.Duration) bool {
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-d.baseCtx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
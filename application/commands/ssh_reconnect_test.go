@@ -0,0 +1,226 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/network"
+)
+
+func TestParseSSHReconnectPolicy(t *testing.T) {
+	p, err := parseSSHReconnectPolicy(map[string]string{})
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if p.enabled() {
+		t.Error("Expecting reconnect to be disabled by default")
+		return
+	}
+	p, err = parseSSHReconnectPolicy(map[string]string{
+		sshReconnectAttemptsMeta: "5",
+		sshReconnectDelayMeta:    "2s",
+	})
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if !p.enabled() || p.attempts != 5 || p.delay != 2*time.Second {
+		t.Errorf("Unexpected policy: %v", p)
+		return
+	}
+	for _, meta := range []map[string]string{
+		{sshReconnectAttemptsMeta: "-1"},
+		{sshReconnectAttemptsMeta: "256"},
+		{sshReconnectAttemptsMeta: "forever"},
+		{sshReconnectDelayMeta: "0s"},
+		{sshReconnectDelayMeta: "later"},
+	} {
+		if _, err := parseSSHReconnectPolicy(meta); err == nil {
+			t.Errorf("Expecting %v to be rejected", meta)
+			return
+		}
+	}
+}
+
+func TestSSHReconnectPolicyBackoff(t *testing.T) {
+	p := sshReconnectPolicy{attempts: 10, delay: 10 * time.Second}
+	for i, expected := range []time.Duration{
+		10 * time.Second,
+		20 * time.Second,
+		40 * time.Second,
+		sshMaxReconnectDelay,
+		sshMaxReconnectDelay,
+	} {
+		if d := p.backoff(i + 1); d != expected {
+			t.Errorf("Expecting the delay of attempt %d to be %s, got %s "+
+				"instead", i+1, expected, d)
+			return
+		}
+	}
+}
+
+func TestSSHCredentialCacheHostKey(t *testing.T) {
+	c := newSSHCredentialCache()
+	known, other := testSSHSigner(t), testSSHSigner(t)
+	err := c.verifyHostKey("localhost:22", known.PublicKey())
+	if !errors.Is(err, ErrSSHRemoteHostKeyChanged) {
+		t.Errorf("Expecting unknown host to be rejected, got %v instead", err)
+		return
+	}
+	c.saveHostKey("localhost:22", known.PublicKey())
+	if err = c.verifyHostKey("localhost:22", known.PublicKey()); err != nil {
+		t.Error("Expecting known host key to be accepted, got", err)
+		return
+	}
+	err = c.verifyHostKey("localhost:22", other.PublicKey())
+	if !errors.Is(err, ErrSSHRemoteHostKeyChanged) {
+		t.Errorf("Expecting changed host key to be rejected, got %v instead",
+			err)
+		return
+	}
+}
+
+func TestSSHCredentialCacheCredentials(t *testing.T) {
+	c := newSSHCredentialCache()
+	creds := c.of("root", "localhost:22")
+	if _, err := creds.cachedPassword(); err == nil {
+		t.Error("Expecting no password to be cached")
+		return
+	}
+	creds.savePassword("secret")
+	signer := testSSHSigner(t)
+	creds.saveSigners([]ssh.Signer{signer})
+	creds.saveSigners([]ssh.Signer{signer})
+	creds.saveAnswers("", "", []string{"Password: "}, []string{"secret"})
+	// Credentials are kept per user and remote
+	if c.of("root", "localhost:22") != creds {
+		t.Error("Expecting the same credentials to be returned")
+		return
+	}
+	if _, err := c.of("admin", "localhost:22").cachedPassword(); err == nil {
+		t.Error("Expecting no password to be cached for another user")
+		return
+	}
+	if password, err := creds.cachedPassword(); password != "secret" {
+		t.Errorf("Expecting the cached password, got %q (%v) instead",
+			password, err)
+		return
+	}
+	if signers, err := creds.cachedSigners(); len(signers) != 1 {
+		t.Errorf("Expecting 1 cached signer, got %d (%v) instead",
+			len(signers), err)
+		return
+	}
+	answers, err := creds.cachedAnswers("", "", []string{"Password: "})
+	if err != nil || len(answers) != 1 || answers[0] != "secret" {
+		t.Errorf("Unexpected cached answers: %q (%v)", answers, err)
+		return
+	}
+	_, err = creds.cachedAnswers("", "", []string{"One-time code: "})
+	if !errors.Is(err, ErrSSHReconnectCredentialUnavailable) {
+		t.Errorf("Expecting unknown challenge to be rejected, got %v instead",
+			err)
+		return
+	}
+}
+
+func TestSSHCredentialCacheDisabled(t *testing.T) {
+	var c *sshCredentialCache
+	creds := c.of("root", "localhost:22")
+	creds.savePassword("secret")
+	if _, err := creds.cachedPassword(); err == nil {
+		t.Error("Expecting nothing to be cached when the cache is disabled")
+		return
+	}
+}
+
+func TestSSHReconnectWithPooledClient(t *testing.T) {
+	shells := atomic.Int32{}
+	addr := testSSHServer(t, testSSHPasswordConfig("secret", nil), func(
+		conn *ssh.ServerConn,
+		chans <-chan ssh.NewChannel,
+		reqs <-chan *ssh.Request,
+	) {
+		testSSHSessions(conn, chans, reqs, func(
+			conn *ssh.ServerConn,
+			ch ssh.Channel,
+			req *ssh.Request,
+		) {
+			// Drop the connection that the first Shell was started on
+			if shells.Add(1) == 1 {
+				conn.Close()
+			}
+		})
+	})
+	// Another stream of the same socket has already connected to the remote
+	pooled, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "test",
+		Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal("Unable to connect:", err)
+	}
+	pool := command.NewSSHClientPool()
+	defer pool.Put(
+		sshClientPoolKey(sshPresetType, "test", addr, ""), pooled,
+		func() {})()
+	s := testCommandStream(t, command.Configuration{
+		Dial:        network.TCPDial(),
+		DialTimeout: 5 * time.Second,
+		SSHClients:  pool,
+		Presets: []configuration.Preset{{
+			Type: sshPresetType,
+			Host: addr,
+			Meta: map[string]string{
+				sshReconnectAttemptsMeta: "3",
+				sshReconnectDelayMeta:    "10ms",
+			},
+			Secrets: map[string]string{sshPasswordMeta: "secret"},
+		}},
+	}, 0x01, testSSHBootup(t, "test", addr, SSHAuthMethodPassphrase))
+	for {
+		marker, d := s.receive()
+		switch marker {
+		case SSHServerConnectVerifyFingerprint:
+			s.send(SSHClientRespondFingerprint, []byte{0})
+		case SSHServerConnectFailed:
+			t.Errorf("Unable to connect: %s", d)
+			return
+		case SSHServerNotice:
+			switch sshNoticeType(d[0]) {
+			case SSHServerNoticeExitStatus:
+				t.Error("Expecting the session to be reconnected")
+				return
+			case SSHServerNoticeReconnect:
+				if sshReconnectState(d[1]) == SSHReconnectStateReconnected {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/rw"
+)
+
+const (
+	sshTerminalTypeMeta   = "Terminal Type"
+	sshTerminalModesMeta  = "Terminal Modes"
+	sshDefaultTermType    = "xterm-256color"
+	sshDefaultTermHeight  = 80
+	sshDefaultTermWidth   = 40
+	sshMaxTerminalTypeLen = 255
+)
+
+// sshTerminalModeNames maps the name of terminal modes (as listed in RFC 4254
+// Section 8) to their opcodes
+var sshTerminalModeNames = map[string]uint8{
+	"VINTR":         ssh.VINTR,
+	"VQUIT":         ssh.VQUIT,
+	"VERASE":        ssh.VERASE,
+	"VKILL":         ssh.VKILL,
+	"VEOF":          ssh.VEOF,
+	"VEOL":          ssh.VEOL,
+	"VEOL2":         ssh.VEOL2,
+	"VSTART":        ssh.VSTART,
+	"VSTOP":         ssh.VSTOP,
+	"VSUSP":         ssh.VSUSP,
+	"VDSUSP":        ssh.VDSUSP,
+	"VREPRINT":      ssh.VREPRINT,
+	"VWERASE":       ssh.VWERASE,
+	"VLNEXT":        ssh.VLNEXT,
+	"VFLUSH":        ssh.VFLUSH,
+	"VSWTCH":        ssh.VSWTCH,
+	"VSTATUS":       ssh.VSTATUS,
+	"VDISCARD":      ssh.VDISCARD,
+	"IGNPAR":        ssh.IGNPAR,
+	"PARMRK":        ssh.PARMRK,
+	"INPCK":         ssh.INPCK,
+	"ISTRIP":        ssh.ISTRIP,
+	"INLCR":         ssh.INLCR,
+	"IGNCR":         ssh.IGNCR,
+	"ICRNL":         ssh.ICRNL,
+	"IUCLC":         ssh.IUCLC,
+	"IXON":          ssh.IXON,
+	"IXANY":         ssh.IXANY,
+	"IXOFF":         ssh.IXOFF,
+	"IMAXBEL":       ssh.IMAXBEL,
+	"IUTF8":         ssh.IUTF8,
+	"ISIG":          ssh.ISIG,
+	"ICANON":        ssh.ICANON,
+	"XCASE":         ssh.XCASE,
+	"ECHO":          ssh.ECHO,
+	"ECHOE":         ssh.ECHOE,
+	"ECHOK":         ssh.ECHOK,
+	"ECHONL":        ssh.ECHONL,
+	"NOFLSH":        ssh.NOFLSH,
+	"TOSTOP":        ssh.TOSTOP,
+	"IEXTEN":        ssh.IEXTEN,
+	"ECHOCTL":       ssh.ECHOCTL,
+	"ECHOKE":        ssh.ECHOKE,
+	"PENDIN":        ssh.PENDIN,
+	"OPOST":         ssh.OPOST,
+	"OLCUC":         ssh.OLCUC,
+	"ONLCR":         ssh.ONLCR,
+	"OCRNL":         ssh.OCRNL,
+	"ONOCR":         ssh.ONOCR,
+	"ONLRET":        ssh.ONLRET,
+	"CS7":           ssh.CS7,
+	"CS8":           ssh.CS8,
+	"PARENB":        ssh.PARENB,
+	"PARODD":        ssh.PARODD,
+	"TTY_OP_ISPEED": ssh.TTY_OP_ISPEED,
+	"TTY_OP_OSPEED": ssh.TTY_OP_OSPEED,
+}
+
+// sshTerminal contains settings of the PTY requested on the remote
+type sshTerminal struct {
+	termType string
+	height   int
+	width    int
+	modes    ssh.TerminalModes
+}
+
+// defaultSSHTerminal returns the default settings of the PTY
+func defaultSSHTerminal() sshTerminal {
+	return sshTerminal{
+		termType: sshDefaultTermType,
+		height:   sshDefaultTermHeight,
+		width:    sshDefaultTermWidth,
+		modes: ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		},
+	}
+}
+
+// parseSSHTerminalBootup parses the optional terminal settings at the end of
+// the Bootup data. If the client didn't send it, the default settings are
+// returned
+//
+// Terminal settings format:
+// +---------------+--------+---------+
+// | String        | 2 bytes| 2 bytes |
+// +---------------+--------+---------+
+// | Terminal type | Rows   | Columns |
+// +---------------+--------+---------+
+//
+// Empty terminal type, or 0 rows or columns means to use the default value
+func parseSSHTerminalBootup(
+	r *rw.LimitedReader,
+	buf []byte,
+) (sshTerminal, error) {
+	t := defaultSSHTerminal()
+	if r.Completed() {
+		return t, nil
+	}
+	termType, _, err := ParseString(r.Read, buf[:sshMaxTerminalTypeLen])
+	if err != nil {
+		return t, err
+	}
+	if len(termType.Data()) > 0 {
+		t.termType = string(termType.Data())
+	}
+	size := [4]byte{}
+	if _, err = io.ReadFull(r, size[:]); err != nil {
+		return t, err
+	}
+	if rows := int(size[0])<<8 | int(size[1]); rows > 0 {
+		t.height = rows
+	}
+	if cols := int(size[2])<<8 | int(size[3]); cols > 0 {
+		t.width = cols
+	}
+	return t, nil
+}
+
+// applyMeta overrides the terminal settings with the ones defined in Preset
+// `meta`
+func (t sshTerminal) applyMeta(meta map[string]string) (sshTerminal, error) {
+	if termType, ok := meta[sshTerminalTypeMeta]; ok {
+		termType = strings.TrimSpace(termType)
+		if len(termType) <= 0 || len(termType) > sshMaxTerminalTypeLen {
+			return t, fmt.Errorf("invalid %q Meta: must be a non-empty "+
+				"string no longer than %d", sshTerminalTypeMeta,
+				sshMaxTerminalTypeLen)
+		}
+		t.termType = termType
+	}
+	modesStr, ok := meta[sshTerminalModesMeta]
+	if !ok {
+		return t, nil
+	}
+	modes, err := parseSSHTerminalModes(modesStr)
+	if err != nil {
+		return t, fmt.Errorf("invalid %q Meta: %s", sshTerminalModesMeta, err)
+	}
+	merged := make(ssh.TerminalModes, len(t.modes)+len(modes))
+	for k, v := range t.modes {
+		merged[k] = v
+	}
+	for k, v := range modes {
+		merged[k] = v
+	}
+	t.modes = merged
+	return t, nil
+}
+
+// parseSSHTerminalModes parses terminal modes given in the `,` separated
+// "NAME=value" format, for example: "ECHO=0,TTY_OP_ISPEED=38400"
+func parseSSHTerminalModes(s string) (ssh.TerminalModes, error) {
+	items := splitSSHMetaList(s)
+	modes := make(ssh.TerminalModes, len(items))
+	for _, item := range items {
+		name, value, found := strings.Cut(item, "=")
+		if !found {
+			return nil, fmt.Errorf("mode %q must be given in the "+
+				"\"NAME=value\" format", item)
+		}
+		opcode, known := sshTerminalModeNames[strings.TrimSpace(name)]
+		if !known {
+			return nil, fmt.Errorf("unknown terminal mode %q", name)
+		}
+		v, err := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for mode %q: %s", name, err)
+		}
+		modes[opcode] = uint32(v)
+	}
+	return modes, nil
+}
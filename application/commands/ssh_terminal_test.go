@@ -0,0 +1,99 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseSSHTerminalBootup(t *testing.T) {
+	data := []byte{
+		0x05, 'v', 't', '1', '0', '0',
+		0x00, 0x18, 0x00, 0x50,
+	}
+	term, err := parseSSHTerminalBootup(
+		testLimitedReader(data), make([]byte, 256))
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if term.termType != "vt100" || term.height != 24 || term.width != 80 {
+		t.Errorf("Unexpected terminal: %q, %d, %d",
+			term.termType, term.height, term.width)
+		return
+	}
+}
+
+func TestParseSSHTerminalBootupDefault(t *testing.T) {
+	term, err := parseSSHTerminalBootup(
+		testLimitedReader([]byte{}), make([]byte, 256))
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	def := defaultSSHTerminal()
+	if term.termType != def.termType || term.height != def.height ||
+		term.width != def.width {
+		t.Errorf("Expecting the default terminal, got %q, %d, %d",
+			term.termType, term.height, term.width)
+		return
+	}
+}
+
+func TestSSHTerminalApplyMeta(t *testing.T) {
+	term, err := defaultSSHTerminal().applyMeta(map[string]string{
+		"Terminal Type":  "vt220",
+		"Terminal Modes": "ECHO=0, TTY_OP_ISPEED=38400,IUTF8=1",
+	})
+	if err != nil {
+		t.Error("Failed to apply:", err)
+		return
+	}
+	if term.termType != "vt220" {
+		t.Errorf("Expecting the terminal type to be %q, got %q instead",
+			"vt220", term.termType)
+		return
+	}
+	expected := ssh.TerminalModes{
+		ssh.ECHO:          0,
+		ssh.TTY_OP_ISPEED: 38400,
+		ssh.TTY_OP_OSPEED: 14400,
+		ssh.IUTF8:         1,
+	}
+	if len(term.modes) != len(expected) {
+		t.Errorf("Expecting %d modes, got %d instead",
+			len(expected), len(term.modes))
+		return
+	}
+	for k, v := range expected {
+		if term.modes[k] != v {
+			t.Errorf("Expecting mode %d to be %d, got %d instead",
+				k, v, term.modes[k])
+			return
+		}
+	}
+	_, err = defaultSSHTerminal().applyMeta(map[string]string{
+		"Terminal Modes": "NOT_A_MODE=1",
+	})
+	if err == nil {
+		t.Error("Expecting an error for unknown terminal mode")
+		return
+	}
+}
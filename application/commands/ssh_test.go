@@ -0,0 +1,783 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/network"
+)
+
+func TestParseSSHJumpHosts(t *testing.T) {
+	hosts, err := parseSSHJumpHosts(
+		"admin@bastion1.example.com, ops@10.0.0.1:2222,,root@[::1]:22")
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	expected := []sshJumpHost{
+		{user: "admin", address: "bastion1.example.com:22"},
+		{user: "ops", address: "10.0.0.1:2222"},
+		{user: "root", address: "[::1]:22"},
+	}
+	if len(hosts) != len(expected) {
+		t.Errorf("Expecting %d hosts, got %d instead",
+			len(expected), len(hosts))
+		return
+	}
+	for i := range expected {
+		if hosts[i] == expected[i] {
+			continue
+		}
+		t.Errorf("Expecting host %d to be %+v, got %+v instead",
+			i, expected[i], hosts[i])
+		return
+	}
+}
+
+func TestParseSSHJumpHostsInvalid(t *testing.T) {
+	for _, s := range []string{"bastion", "@bastion", "admin@"} {
+		if _, err := parseSSHJumpHosts(s); err != ErrSSHInvalidJumpHost {
+			t.Errorf("Expecting %q to be invalid, got error %v instead", s, err)
+			return
+		}
+	}
+}
+
+func TestParseSSHConfigSecrets(t *testing.T) {
+	p, err := parseSSHConfig(configuration.Preset{
+		Type: "SSH",
+		Host: "localhost",
+		Meta: map[string]string{
+			"User":     "root",
+			"Password": "Hello",
+		},
+		Secrets: map[string]string{
+			"Password":    "World",
+			"Private Key": "Key",
+		},
+	})
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if _, ok := p.Meta["Password"]; ok {
+		t.Error("Expecting the Password to be removed from the Meta")
+		return
+	}
+	if p.Meta["User"] != "root" {
+		t.Errorf("Expecting the User to be %q, got %q instead",
+			"root", p.Meta["User"])
+		return
+	}
+	if s, _ := p.Secret("Password"); s != "Hello" {
+		t.Errorf("Expecting the Password secret to be %q, got %q instead",
+			"Hello", s)
+		return
+	}
+	if s, _ := p.Secret("Private Key"); s != "Key" {
+		t.Errorf("Expecting the Private Key secret to be %q, got %q instead",
+			"Key", s)
+		return
+	}
+}
+
+func testSSHPrivateKey(
+	t *testing.T,
+	passphrase string,
+) (ssh.PublicKey, []byte) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("Unable to generate key:", err)
+	}
+	var block *pem.Block
+	if len(passphrase) > 0 {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(
+			key, "", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(key, "")
+	}
+	if err != nil {
+		t.Fatal("Unable to marshal key:", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal("Unable to convert public key:", err)
+	}
+	return sshPub, pem.EncodeToMemory(block)
+}
+
+func testSSHSigner(t *testing.T) ssh.Signer {
+	_, key := testSSHPrivateKey(t, "")
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		t.Fatal("Unable to create signer:", err)
+	}
+	return signer
+}
+
+// testSSHServer starts a SSH server of given `cfg` and returns it's address.
+// A host key is added to the `cfg`, and every accepted connection will be
+// served by `serve` once the handshake is done
+func testSSHServer(
+	t *testing.T,
+	cfg *ssh.ServerConfig,
+	serve func(
+		conn *ssh.ServerConn,
+		chans <-chan ssh.NewChannel,
+		reqs <-chan *ssh.Request,
+	),
+) string {
+	cfg.AddHostKey(testSSHSigner(t))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unable to listen:", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				sConn, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				serve(sConn, chans, reqs)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// testSSHPasswordConfig returns a ServerConfig that only accepts `password`.
+// Every password that has been tried will be sent to `tried` if it's not nil
+func testSSHPasswordConfig(
+	password string,
+	tried chan<- string,
+) *ssh.ServerConfig {
+	return &ssh.ServerConfig{
+		PasswordCallback: func(
+			c ssh.ConnMetadata,
+			p []byte,
+		) (*ssh.Permissions, error) {
+			if tried != nil {
+				tried <- string(p)
+			}
+			if string(p) != password {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+	}
+}
+
+// testSSHSessions accepts all sessions opened on `conn`, and grants every PTY,
+// Shell and subsystem request. `start` is called (if not nil) when a Shell or
+// a subsystem is started on the session `ch`
+func testSSHSessions(
+	conn *ssh.ServerConn,
+	chans <-chan ssh.NewChannel,
+	reqs <-chan *ssh.Request,
+	start func(conn *ssh.ServerConn, ch ssh.Channel, req *ssh.Request),
+) {
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "")
+			continue
+		}
+		ch, chReqs, err := newChan.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range chReqs {
+				switch req.Type {
+				case "pty-req":
+					req.Reply(true, nil)
+				case "shell", "subsystem":
+					req.Reply(true, nil)
+					if start != nil {
+						start(conn, ch, req)
+					}
+				default:
+					req.Reply(false, nil)
+				}
+			}
+		}()
+	}
+}
+
+// testSSHForward accepts all "direct-tcpip" channels opened on `conn`, and
+// relays them to the requested targets
+func testSSHForward(
+	conn *ssh.ServerConn,
+	chans <-chan ssh.NewChannel,
+	reqs <-chan *ssh.Request,
+) {
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		var target struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if newChan.ChannelType() != "direct-tcpip" ||
+			ssh.Unmarshal(newChan.ExtraData(), &target) != nil {
+			newChan.Reject(ssh.UnknownChannelType, "")
+			continue
+		}
+		remote, err := net.Dial("tcp", net.JoinHostPort(
+			target.Host, strconv.FormatUint(uint64(target.Port), 10)))
+		if err != nil {
+			newChan.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, chReqs, err := newChan.Accept()
+		if err != nil {
+			remote.Close()
+			return
+		}
+		go ssh.DiscardRequests(chReqs)
+		go func() {
+			io.Copy(ch, remote)
+			ch.Close()
+		}()
+		go func() {
+			io.Copy(remote, ch)
+			remote.Close()
+		}()
+	}
+}
+
+// testAddress converts the IPv4 address `addr` into an Address
+func testAddress(t *testing.T, addr string) Address {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal("Invalid address:", err)
+	}
+	port, _ := strconv.ParseUint(portStr, 10, 16)
+	return NewAddress(IPv4Addr, net.ParseIP(host).To4(), uint16(port))
+}
+
+// testSSHBootup builds the Bootup data of the SSH command to login as `user`
+// to the remote at IPv4 address `addr`
+func testSSHBootup(
+	t *testing.T,
+	user string,
+	addr string,
+	authMethods SSHAuthModes,
+) []byte {
+	b := make([]byte, 64)
+	n, err := MarshalString(user, b)
+	if err != nil {
+		t.Fatal("Unable to marshal user:", err)
+	}
+	aLen, err := testAddress(t, addr).Marshal(b[n:])
+	if err != nil {
+		t.Fatal("Unable to marshal address:", err)
+	}
+	n += aLen
+	b[n] = byte(authMethods)
+	return b[:n+1]
+}
+
+func TestParseSSHPrivateKeys(t *testing.T) {
+	plainPub, plain := testSSHPrivateKey(t, "")
+	encryptedPub, encrypted := testSSHPrivateKey(t, "Secret")
+	passphrases := [][]byte{[]byte("Wrong"), []byte("Secret")}
+	requested := 0
+	signers, err := parseSSHPrivateKeys(
+		append(append([]byte{}, plain...), encrypted...),
+		0,
+		func(k ssh.PublicKey) ([]byte, error) {
+			if !bytes.Equal(k.Marshal(), encryptedPub.Marshal()) {
+				t.Error("Passphrase requested for an unexpected key")
+			}
+			requested++
+			return passphrases[requested-1], nil
+		},
+	)
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if requested != len(passphrases) {
+		t.Errorf("Expecting passphrase to be requested %d times, got %d",
+			len(passphrases), requested)
+		return
+	}
+	expected := []ssh.PublicKey{plainPub, encryptedPub}
+	if len(signers) != len(expected) {
+		t.Errorf("Expecting %d signers, got %d instead",
+			len(expected), len(signers))
+		return
+	}
+	for i := range expected {
+		signerPub := signers[i].PublicKey().Marshal()
+		if bytes.Equal(signerPub, expected[i].Marshal()) {
+			continue
+		}
+		t.Errorf("Unexpected signer %d", i)
+		return
+	}
+}
+
+func TestParseSSHPrivateKeysRetriesExhausted(t *testing.T) {
+	_, encrypted := testSSHPrivateKey(t, "Secret")
+	requested := 0
+	_, err := parseSSHPrivateKeys(encrypted, 2, func(
+		k ssh.PublicKey,
+	) ([]byte, error) {
+		requested++
+		return []byte("Wrong"), nil
+	})
+	if err == nil {
+		t.Error("Expecting an error when the passphrase is incorrect")
+		return
+	}
+	if requested != 2 {
+		t.Errorf("Expecting passphrase to be requested %d times, got %d",
+			2, requested)
+		return
+	}
+}
+
+func TestParseSSHPrivateKeysWithCertificate(t *testing.T) {
+	pub, key := testSSHPrivateKey(t, "")
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("Unable to generate key:", err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal("Unable to create signer:", err)
+	}
+	cert := &ssh.Certificate{
+		Key:             pub,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"root"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal("Unable to sign certificate:", err)
+	}
+	certLine := ssh.MarshalAuthorizedKey(cert)
+	data := append(append([]byte{}, key...), certLine...)
+	signers, err := parseSSHPrivateKeys(data, 0, nil)
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if len(signers) != 1 {
+		t.Errorf("Expecting %d signers, got %d instead", 1, len(signers))
+		return
+	}
+	signerCert, isCert := signers[0].PublicKey().(*ssh.Certificate)
+	if !isCert || !bytes.Equal(signerCert.Marshal(), cert.Marshal()) {
+		t.Error("Expecting the signer to be signed with the certificate")
+		return
+	}
+	_, otherKey := testSSHPrivateKey(t, "")
+	data = append(append([]byte{}, otherKey...), certLine...)
+	if _, err := parseSSHPrivateKeys(data, 0, nil); err == nil {
+		t.Error("Expecting an error when the certificate matches no key")
+		return
+	}
+}
+
+func TestParseSSHCertificateSettings(t *testing.T) {
+	_, enabled, err := parseSSHCertificateSettings(map[string]string{})
+	if err != nil || enabled {
+		t.Error("Expecting the certificate authority to be disabled")
+		return
+	}
+	cs, enabled, err := parseSSHCertificateSettings(map[string]string{
+		"Certificate Authority":  "Built-in",
+		"Certificate Validity":   "1h",
+		"Certificate Principals": "{web_user}, ops",
+		"Certificate Extensions": "permit-pty,permit-port-forwarding",
+	})
+	if err != nil || !enabled {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if cs.validity != time.Hour {
+		t.Errorf("Expecting the validity to be %s, got %s instead",
+			time.Hour, cs.validity)
+		return
+	}
+	if len(cs.principals) != 2 || cs.principals[0] != "{web_user}" ||
+		cs.principals[1] != "ops" {
+		t.Errorf("Unexpected principals: %q", cs.principals)
+		return
+	}
+	if _, ok := cs.extensions["permit-port-forwarding"]; !ok ||
+		len(cs.extensions) != 2 {
+		t.Errorf("Unexpected extensions: %v", cs.extensions)
+		return
+	}
+	_, _, err = parseSSHCertificateSettings(map[string]string{
+		"Certificate Authority": "Built-in",
+		"Certificate Validity":  "-1m",
+	})
+	if err == nil {
+		t.Error("Expecting an error for the invalid validity")
+		return
+	}
+}
+
+func TestMarshalSSHExitStatus(t *testing.T) {
+	b := make([]byte, 64)
+	n, err := marshalSSHExitStatus(nil, b)
+	if err != nil {
+		t.Error("Failed to marshal:", err)
+		return
+	}
+	if !bytes.Equal(b[:n], []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00}) {
+		t.Errorf("Unexpected data for clean exit: %v", b[:n])
+		return
+	}
+	n, err = marshalSSHExitStatus(io.EOF, b)
+	if err != nil {
+		t.Error("Failed to marshal:", err)
+		return
+	}
+	expected := []byte{0xff, 0xff, 0xff, 0xff, 0x00, 0x03, 'E', 'O', 'F'}
+	if !bytes.Equal(b[:n], expected) {
+		t.Errorf("Expecting data %v, got %v instead", expected, b[:n])
+		return
+	}
+}
+
+func TestParseSSHCommandBootup(t *testing.T) {
+	data := []byte{0x07, 'u', 'p', 't', 'i', 'm', 'e', ';'}
+	cmd, err := parseSSHCommandBootup(
+		testLimitedReader(data), make([]byte, sshMaxCommandLen))
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if cmd != "uptime;" {
+		t.Errorf("Expecting the command to be %q, got %q instead",
+			"uptime;", cmd)
+		return
+	}
+	cmd, err = parseSSHCommandBootup(
+		testLimitedReader(nil), make([]byte, sshMaxCommandLen))
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if cmd != "" {
+		t.Errorf("Expecting no command, got %q instead", cmd)
+		return
+	}
+}
+
+func TestParseSSHConfigSubsystem(t *testing.T) {
+	_, err := parseSSHConfig(configuration.Preset{
+		Type: sshPresetType,
+		Host: "localhost",
+		Meta: map[string]string{sshSubsystemMeta: "netconf"},
+	})
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	_, err = parseSSHConfig(configuration.Preset{
+		Type: sshPresetType,
+		Host: "localhost",
+		Meta: map[string]string{
+			sshSubsystemMeta: "netconf",
+			sshCommandMeta:   "uptime",
+		},
+	})
+	if err == nil {
+		t.Error("Subsystem and Command must not be accepted together")
+		return
+	}
+}
+
+func TestParseSSHConfigEnvironment(t *testing.T) {
+	p, err := parseSSHConfig(configuration.Preset{
+		Type: sshPresetType,
+		Host: "localhost",
+		Meta: map[string]string{
+			sshEnvironmentPrefix + "LANG": "en_US.UTF-8",
+			sshStartupMeta:                "tmux attach -t ops",
+		},
+	})
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if _, ok := p.Meta[sshEnvironmentPrefix+"LANG"]; ok {
+		t.Error("Environment variables must not be left in the Meta")
+		return
+	}
+	env := sshEnvironment(p.Secrets)
+	if len(env) != 1 || env["LANG"] != "en_US.UTF-8" {
+		t.Errorf("Unexpected environment variables: %v", env)
+		return
+	}
+	for _, meta := range []map[string]string{
+		{sshEnvironmentPrefix: "value"},
+		{sshEnvironmentPrefix + "A=B": "value"},
+		{sshStartupMeta: "tmux", sshCommandMeta: "uptime"},
+	} {
+		_, err = parseSSHConfig(configuration.Preset{
+			Type: sshPresetType,
+			Host: "localhost",
+			Meta: meta,
+		})
+		if err == nil {
+			t.Errorf("Expecting %v to be invalid", meta)
+			return
+		}
+	}
+}
+
+func TestSSHJumpHostCredentials(t *testing.T) {
+	tried := make(chan string, 16)
+	jump := testSSHServer(
+		t, testSSHPasswordConfig("jump", tried), testSSHForward)
+	addr := testSSHServer(t, testSSHPasswordConfig("target", nil), func(
+		conn *ssh.ServerConn,
+		chans <-chan ssh.NewChannel,
+		reqs <-chan *ssh.Request,
+	) {
+		testSSHSessions(conn, chans, reqs, nil)
+	})
+	s := testCommandStream(t, command.Configuration{
+		Dial:        network.TCPDial(),
+		PresetDial:  network.TCPDial(),
+		DialTimeout: 5 * time.Second,
+		Presets: []configuration.Preset{{
+			Type:    sshPresetType,
+			Host:    addr,
+			Meta:    map[string]string{sshJumpHostsMeta: "test@" + jump},
+			Secrets: map[string]string{sshPasswordMeta: "target"},
+		}},
+	}, 0x01, testSSHBootup(t, "test", addr, SSHAuthMethodPassphrase))
+	for connected := false; !connected; {
+		marker, d := s.receive()
+		switch marker {
+		case SSHServerConnectVerifyFingerprint:
+			s.send(SSHClientRespondFingerprint, []byte{0})
+		case SSHServerConnectRequestCredential:
+			// Only the jump host has to ask for the password
+			s.send(SSHClientRespondCredential, []byte("jump"))
+		case SSHServerConnectFailed:
+			t.Errorf("Unable to connect: %s", d)
+			return
+		case SSHServerConnectSucceed:
+			connected = true
+		}
+	}
+	close(tried)
+	for password := range tried {
+		if password != "jump" {
+			t.Errorf("Expecting only the password of the jump host to be "+
+				"sent to the jump host, got %q", password)
+			return
+		}
+	}
+}
+
+func TestSSHSharedClientCreatorFailed(t *testing.T) {
+	const dialTimeout = 300 * time.Millisecond
+	firstSession := make(chan struct{})
+	addr := testSSHServer(t, testSSHPasswordConfig("secret", nil), func(
+		conn *ssh.ServerConn,
+		chans <-chan ssh.NewChannel,
+		reqs <-chan *ssh.Request,
+	) {
+		go ssh.DiscardRequests(reqs)
+		// Hold the session of the stream that created the client until the
+		// second stream has opened its own, then fail the first one
+		first := <-chans
+		close(firstSession)
+		second := <-chans
+		first.Reject(ssh.Prohibited, "rejected")
+		ch, chReqs, err := second.Accept()
+		if err != nil {
+			return
+		}
+		for req := range chReqs {
+			req.Reply(req.Type == "pty-req" || req.Type == "shell", nil)
+			if req.Type != "shell" {
+				continue
+			}
+			go func() {
+				// Respond after the initial deadline would have expired
+				time.Sleep(3 * dialTimeout)
+				ch.Write([]byte("Hello"))
+			}()
+		}
+	})
+	cfg := command.Configuration{
+		Dial:        network.TCPDial(),
+		DialTimeout: dialTimeout,
+		SSHClients:  command.NewSSHClientPool(),
+		Presets: []configuration.Preset{{
+			Type:    sshPresetType,
+			Host:    addr,
+			Secrets: map[string]string{sshPasswordMeta: "secret"},
+		}},
+	}
+	bootup := testSSHBootup(t, "test", addr, SSHAuthMethodPassphrase)
+	s1 := testCommandStream(t, cfg, 0x01, bootup)
+	for connected := false; !connected; {
+		marker, d := s1.receive()
+		switch marker {
+		case SSHServerConnectVerifyFingerprint:
+			s1.send(SSHClientRespondFingerprint, []byte{0})
+		case SSHServerConnectFailed:
+			t.Errorf("Unable to connect: %s", d)
+			return
+		case SSHServerNotice:
+			connected = sshNoticeType(d[0]) == SSHServerNoticeVersion
+		}
+	}
+	select {
+	case <-firstSession:
+	case <-time.After(10 * time.Second):
+		t.Fatal("The first stream did not open the session")
+	}
+	s2 := testCommandStream(t, cfg, 0x01, bootup)
+	for received := false; !received; {
+		marker, d := s2.receive()
+		switch marker {
+		case SSHServerConnectVerifyFingerprint:
+			t.Error("Expecting the client to be reused without verification")
+			return
+		case SSHServerConnectFailed:
+			t.Errorf("Unable to connect: %s", d)
+			return
+		case SSHServerNotice:
+			if sshNoticeType(d[0]) == SSHServerNoticeExitStatus {
+				t.Errorf("Shared client was disconnected: %s", d[1:])
+				return
+			}
+		case SSHServerRemoteStdOut:
+			if string(d) != "Hello" {
+				t.Errorf("Expecting to receive %q, got %q", "Hello", d)
+				return
+			}
+			received = true
+		}
+	}
+	if marker, _ := s1.receive(); marker != SSHServerConnectFailed {
+		t.Errorf("Expecting the session of the first stream to be rejected, "+
+			"got signal %d instead", marker)
+	}
+}
+
+func TestSSHServerNotices(t *testing.T) {
+	cfg := testSSHPasswordConfig("secret", nil)
+	cfg.ServerVersion = "SSH-2.0-Test"
+	cfg.BannerCallback = func(c ssh.ConnMetadata) string {
+		return "Authorized access only"
+	}
+	shells := atomic.Int32{}
+	addr := testSSHServer(t, cfg, func(
+		conn *ssh.ServerConn,
+		chans <-chan ssh.NewChannel,
+		reqs <-chan *ssh.Request,
+	) {
+		testSSHSessions(conn, chans, reqs, func(
+			conn *ssh.ServerConn,
+			ch ssh.Channel,
+			req *ssh.Request,
+		) {
+			// Drop the first connection so the notices would be sent again
+			// by the reconnect
+			if shells.Add(1) == 1 {
+				conn.Close()
+			}
+		})
+	})
+	s := testCommandStream(t, command.Configuration{
+		Dial:        network.TCPDial(),
+		DialTimeout: 5 * time.Second,
+		Presets: []configuration.Preset{{
+			Type: sshPresetType,
+			Host: addr,
+			Meta: map[string]string{
+				sshReconnectAttemptsMeta: "3",
+				sshReconnectDelayMeta:    "10ms",
+			},
+			Secrets: map[string]string{sshPasswordMeta: "secret"},
+		}},
+	}, 0x01, testSSHBootup(t, "test", addr, SSHAuthMethodPassphrase))
+	notices := map[sshNoticeType]string{}
+	for reconnected := false; !reconnected; {
+		marker, d := s.receive()
+		switch marker {
+		case SSHServerConnectVerifyFingerprint:
+			s.send(SSHClientRespondFingerprint, []byte{0})
+		case SSHServerConnectFailed:
+			t.Errorf("Unable to connect: %s", d)
+			return
+		case SSHServerNotice:
+			switch n := sshNoticeType(d[0]); n {
+			case SSHServerNoticeBanner, SSHServerNoticeVersion:
+				if _, ok := notices[n]; ok {
+					t.Errorf("Expecting notice %d to be sent only once, "+
+						"got %q again", n, d[1:])
+					return
+				}
+				notices[n] = string(d[1:])
+			case SSHServerNoticeExitStatus:
+				t.Error("Expecting the session to be reconnected")
+				return
+			case SSHServerNoticeReconnect:
+				reconnected =
+					sshReconnectState(d[1]) == SSHReconnectStateReconnected
+			}
+		}
+	}
+	if notices[SSHServerNoticeBanner] != "Authorized access only" {
+		t.Errorf("Expecting the banner to be sent, got %q instead",
+			notices[SSHServerNoticeBanner])
+	}
+	if notices[SSHServerNoticeVersion] != "SSH-2.0-Test" {
+		t.Errorf("Expecting the server version to be sent, got %q instead",
+			notices[SSHServerNoticeVersion])
+	}
+}
@@ -0,0 +1,265 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/rw"
+)
+
+// Server -> client signal consts
+const (
+	SSHTunnelServerRemoteData                 = 0x00
+	SSHTunnelServerHookOutputBeforeConnecting = SSHServerHookOutputBeforeConnecting
+	SSHTunnelServerConnectFailed              = SSHServerConnectFailed
+	SSHTunnelServerConnectSucceed             = SSHServerConnectSucceed
+	SSHTunnelServerConnectVerifyFingerprint   = SSHServerConnectVerifyFingerprint
+	SSHTunnelServerConnectRequestCredential   = SSHServerConnectRequestCredential
+	SSHTunnelServerNotice                     = SSHServerNotice
+)
+
+// Client -> server signal consts
+const (
+	SSHTunnelClientData               = 0x00
+	SSHTunnelClientRespondFingerprint = SSHClientRespondFingerprint
+	SSHTunnelClientRespondCredential  = SSHClientRespondCredential
+)
+
+// Error codes
+const (
+	SSHTunnelRequestErrorBadTargetAddress = command.StreamError(0x04)
+	SSHTunnelRequestErrorTargetNotAllowed = command.StreamError(0x05)
+)
+
+// Errors
+var (
+	ErrSSHTunnelTargetNotAllowed = errors.New(
+		"the tunnel target is not allowed")
+)
+
+const (
+	sshTunnelPresetType      = "SSH Tunnel"
+	sshTunnelTargetMeta      = "Tunnel Target"
+	sshTunnelMaxHostnameLen  = 255
+	sshTunnelRemoteQueueSize = 1
+)
+
+type sshTunnel struct {
+	*sshConnector
+	remoteCloseWait   sync.WaitGroup
+	remoteConnReceive chan net.Conn
+	remoteConn        net.Conn
+}
+
+func newSSHTunnel(
+	l log.Logger,
+	hooks command.Hooks,
+	w command.StreamResponder,
+	cfg command.Configuration,
+	bufferPool *command.BufferPool,
+) command.FSMMachine {
+	return &sshTunnel{
+		sshConnector:      newSSHConnector(l, hooks, w, cfg, bufferPool),
+		remoteCloseWait:   sync.WaitGroup{},
+		remoteConnReceive: make(chan net.Conn, sshTunnelRemoteQueueSize),
+		remoteConn:        nil,
+	}
+}
+
+func parseSSHTunnelConfig(
+	p configuration.Preset,
+) (configuration.Preset, error) {
+	p, err := parseSSHConfig(p)
+	if err != nil {
+		return p, err
+	}
+	target, ok := p.Meta[sshTunnelTargetMeta]
+	if !ok {
+		return p, nil
+	}
+	if _, _, err = net.SplitHostPort(target); err != nil {
+		return p, fmt.Errorf("invalid %q Meta: %s", sshTunnelTargetMeta, err)
+	}
+	return p, nil
+}
+
+// targetAllowed returns whether or not the `target` can be reached through
+// the SSH server at `address`
+func (d *sshTunnel) targetAllowed(address string, target string) bool {
+	if !d.cfg.OnlyAllowPresetRemotes {
+		return true
+	}
+	preset, ok := d.cfg.Preset(sshTunnelPresetType, address)
+	if !ok {
+		return false
+	}
+	return preset.Meta[sshTunnelTargetMeta] == target
+}
+
+// Bootup starts the tunnel
+//
+// In addition to the data required by the SSH command, the Bootup data of the
+// tunnel is followed by an Address which indicates the tunnel target
+func (d *sshTunnel) Bootup(
+	r *rw.LimitedReader,
+	b []byte,
+) (command.FSMState, command.FSMError) {
+	userName, addr, authModes, err := d.parseBootup(r)
+	if !err.Succeed() {
+		return nil, err
+	}
+	sBuf := d.bufferPool.Get()
+	defer d.bufferPool.Put(sBuf)
+	target, targetErr := ParseAddress(
+		r.Read, (*sBuf)[:sshTunnelMaxHostnameLen])
+	if targetErr != nil {
+		return nil, command.ToFSMError(
+			targetErr, SSHTunnelRequestErrorBadTargetAddress)
+	}
+	targetStr := target.String()
+	if !d.targetAllowed(addr, targetStr) {
+		return nil, command.ToFSMError(
+			ErrSSHTunnelTargetNotAllowed, SSHTunnelRequestErrorTargetNotAllowed)
+	}
+	d.remoteCloseWait.Add(1)
+	go d.remote(userName, addr, authModes, targetStr)
+	return d.local, command.NoFSMError()
+}
+
+func (d *sshTunnel) remote(
+	user string,
+	address string,
+	authMethods SSHAuthModes,
+	target string,
+) {
+	u := d.bufferPool.Get()
+	defer d.bufferPool.Put(u)
+	defer func() {
+		d.w.Signal(command.HeaderClose)
+		close(d.remoteConnReceive)
+		d.baseCtxCancel()
+		d.remoteCloseWait.Done()
+	}()
+	conn, release, ready, err :=
+		d.connect(sshTunnelPresetType, user, address, authMethods, (*u)[:])
+	if err != nil {
+		return
+	}
+	defer release()
+	dialCtx, dialCtxCancel := context.WithTimeout(d.baseCtx, d.cfg.DialTimeout)
+	defer dialCtxCancel()
+	targetConn, err := conn.DialContext(dialCtx, "tcp", target)
+	if err != nil {
+		d.sendConnectFailed((*u)[:], err)
+		d.l.Debug("Unable to open tunnel to %s: %s", target, err)
+		return
+	}
+	defer targetConn.Close()
+	ready()
+	d.remoteConnReceive <- targetConn
+	wErr := d.w.SendManual(
+		SSHTunnelServerConnectSucceed, (*u)[:d.w.HeaderSize()])
+	if wErr != nil {
+		return
+	}
+	d.l.Debug("Serving")
+	for {
+		rLen, rErr := targetConn.Read((*u)[d.w.HeaderSize():])
+		if rErr != nil {
+			return
+		}
+		rErr = d.w.SendManual(
+			SSHTunnelServerRemoteData, (*u)[:d.w.HeaderSize()+rLen])
+		if rErr != nil {
+			return
+		}
+	}
+}
+
+func (d *sshTunnel) getRemote() (net.Conn, error) {
+	if d.remoteConn != nil {
+		return d.remoteConn, nil
+	}
+	remoteConn, remoteConnFetched := <-d.remoteConnReceive
+	if !remoteConnFetched {
+		return nil, ErrSSHRemoteConnUnavailable
+	}
+	d.remoteConn = remoteConn
+	return d.remoteConn, nil
+}
+
+func (d *sshTunnel) local(
+	f *command.FSM,
+	r *rw.LimitedReader,
+	h command.StreamHeader,
+	b []byte,
+) error {
+	switch h.Marker() {
+	case SSHTunnelClientData:
+		remote, remoteErr := d.getRemote()
+		if remoteErr != nil {
+			return remoteErr
+		}
+		for !r.Completed() {
+			rData, rErr := r.Buffered()
+			if rErr != nil {
+				return rErr
+			}
+			_, wErr := remote.Write(rData)
+			if wErr != nil {
+				remote.Close()
+				d.l.Debug("Failed to write data to remote: %s", wErr)
+			}
+		}
+		d.credentialProcessed = true
+		return nil
+	case SSHTunnelClientRespondFingerprint:
+		_, err := d.respondFingerprint(r)
+		return err
+	case SSHTunnelClientRespondCredential:
+		return d.respondCredential(r)
+	default:
+		return ErrSSHUnknownClientSignal
+	}
+}
+
+func (d *sshTunnel) Close() error {
+	d.close()
+	// Cancel first, otherwise getRemote will be blocked until the tunnel is
+	// either opened or failed
+	d.baseCtxCancel()
+	remote, remoteErr := d.getRemote()
+	if remoteErr == nil {
+		remote.Close()
+	}
+	d.remoteCloseWait.Wait()
+	return nil
+}
+
+func (d *sshTunnel) Release() error {
+	d.baseCtxCancel()
+	return nil
+}
@@ -0,0 +1,186 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/network"
+)
+
+func TestParseSSHTunnelConfig(t *testing.T) {
+	for _, target := range []string{"", "localhost", "[::1"} {
+		_, err := parseSSHTunnelConfig(configuration.Preset{
+			Type: sshTunnelPresetType,
+			Host: "localhost",
+			Meta: map[string]string{sshTunnelTargetMeta: target},
+		})
+		if err == nil {
+			t.Errorf("Expecting target %q to be invalid", target)
+			return
+		}
+	}
+	_, err := parseSSHTunnelConfig(configuration.Preset{
+		Type: sshTunnelPresetType,
+		Host: "localhost",
+		Meta: map[string]string{sshTunnelTargetMeta: "localhost:80"},
+	})
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+}
+
+func TestSSHTunnelTargetAllowed(t *testing.T) {
+	d := &sshTunnel{sshConnector: &sshConnector{cfg: command.Configuration{
+		OnlyAllowPresetRemotes: true,
+		Presets: []configuration.Preset{{
+			Type: sshTunnelPresetType,
+			Host: "localhost:22",
+			Meta: map[string]string{sshTunnelTargetMeta: "localhost:80"},
+		}},
+	}}}
+	if !d.targetAllowed("localhost:22", "localhost:80") {
+		t.Error("Expecting the target of the Preset to be allowed")
+		return
+	}
+	if d.targetAllowed("localhost:22", "localhost:8080") {
+		t.Error("Expecting targets outside of the Preset to be disallowed")
+		return
+	}
+	if d.targetAllowed("localhost:2222", "localhost:80") {
+		t.Error("Expecting remotes outside of the Preset to be disallowed")
+		return
+	}
+}
+
+// testSSHTunnelStream starts the SSH Tunnel command to `target` through the
+// SSH server at `addr`, and waits for the server fingerprint to be confirmed
+func testSSHTunnelStream(t *testing.T, addr string, target string) *testStream {
+	b := testSSHBootup(t, "test", addr, SSHAuthMethodPassphrase)
+	b = append(b, make([]byte, 64)...)
+	n, err := testAddress(t, target).Marshal(b[len(b)-64:])
+	if err != nil {
+		t.Fatal("Unable to marshal target:", err)
+	}
+	s := testCommandStream(t, command.Configuration{
+		Dial:        network.TCPDial(),
+		DialTimeout: 30 * time.Second,
+		Presets: []configuration.Preset{{
+			Type:    sshTunnelPresetType,
+			Host:    addr,
+			Secrets: map[string]string{sshPasswordMeta: "secret"},
+		}},
+	}, 0x03, b[:len(b)-64+n])
+	marker, _ := s.receive()
+	if marker != SSHTunnelServerConnectVerifyFingerprint {
+		t.Fatalf("Expecting fingerprint verification, got %d instead", marker)
+	}
+	s.send(SSHTunnelClientRespondFingerprint, []byte{0})
+	return s
+}
+
+func TestSSHTunnel(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unable to listen:", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	addr := testSSHServer(
+		t, testSSHPasswordConfig("secret", nil), testSSHForward)
+	s := testSSHTunnelStream(t, addr, listener.Addr().String())
+	for {
+		marker, d := s.receive()
+		if marker == SSHTunnelServerConnectSucceed {
+			break
+		}
+		if marker == SSHTunnelServerConnectFailed {
+			t.Errorf("Unable to connect: %s", d)
+			return
+		}
+	}
+	s.send(SSHTunnelClientData, []byte("Hello World"))
+	received := ""
+	for len(received) < len("Hello World") {
+		marker, d := s.receive()
+		if marker != SSHTunnelServerRemoteData {
+			t.Errorf("Expecting remote data, got %d instead", marker)
+			return
+		}
+		received += string(d)
+	}
+	if received != "Hello World" {
+		t.Errorf("Expecting to receive %q, got %q instead",
+			"Hello World", received)
+		return
+	}
+}
+
+func TestSSHTunnelCloseWhileOpening(t *testing.T) {
+	opening := make(chan struct{}, 1)
+	addr := testSSHServer(t, testSSHPasswordConfig("secret", nil), func(
+		conn *ssh.ServerConn,
+		chans <-chan ssh.NewChannel,
+		reqs <-chan *ssh.Request,
+	) {
+		go ssh.DiscardRequests(reqs)
+		// Never answer to the channel, so the tunnel stays in opening until
+		// the DialTimeout is reached
+		for range chans {
+			opening <- struct{}{}
+		}
+	})
+	s := testSSHTunnelStream(t, addr, "127.0.0.1:80")
+	// The server version is sent right before the tunnel is being opened
+	for {
+		if marker, _ := s.receive(); marker == SSHTunnelServerNotice {
+			break
+		}
+	}
+	select {
+	case <-opening:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expecting the tunnel to be opened")
+	}
+	s.input <- []byte{byte(command.HeaderClose)}
+	for {
+		switch h := command.Header(s.read(1)[0]); h.Type() {
+		case command.HeaderStream:
+			h := command.StreamHeader{}
+			copy(h[:], s.read(2))
+			s.read(int(h.Length()))
+		case command.HeaderCompleted:
+			return
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/rw"
+)
+
+// Errors
+var (
+	ErrTCPUnableToReceiveRemoteConn = errors.New(
+		"unable to acquire remote connection handle")
+
+	ErrTCPRemoteNotAllowed = errors.New(
+		"the remote is not allowed")
+
+	ErrTCPUnknownClientSignal = errors.New(
+		"unknown client signal")
+)
+
+// Error codes
+const (
+	TCPRequestErrorBadRemoteAddress = command.StreamError(0x01)
+	TCPRequestErrorRemoteNotAllowed = command.StreamError(0x02)
+)
+
+const (
+	tcpPresetType     = "TCP"
+	tcpMaxHostnameLen = 255
+)
+
+// Server signal codes
+const (
+	TCPServerRemoteData                 = 0x00
+	TCPServerHookOutputBeforeConnecting = 0x01
+	TCPServerDialFailed                 = 0x02
+	TCPServerDialConnected              = 0x03
+)
+
+// Client signal codes
+const (
+	TCPClientData = 0x00
+)
+
+// tcpUpgrader upgrades the freshly dialed `conn` before it is used to relay
+// data, for example, by wrapping it in a TLS client. The `b` is a buffer that
+// can be used to send signals to the client
+type tcpUpgrader func(
+	d *tcpClient,
+	ctx context.Context,
+	conn net.Conn,
+	addr string,
+	b []byte,
+) (net.Conn, error)
+
+// tcpClient relays raw bytes between the client and a TCP remote, without
+// interpreting them
+type tcpClient struct {
+	*relayClient
+	upgrade tcpUpgrader
+}
+
+func newTCP(
+	l log.Logger,
+	hooks command.Hooks,
+	w command.StreamResponder,
+	cfg command.Configuration,
+	bufferPool *command.BufferPool,
+) command.FSMMachine {
+	return newTCPClient(tcpPresetType, nil, l, hooks, w, cfg, bufferPool)
+}
+
+// newTCPClient creates a tcpClient which serves remotes of the `presetType`,
+// and upgrades the connection with `upgrade` if it's not nil
+func newTCPClient(
+	presetType string,
+	upgrade tcpUpgrader,
+	l log.Logger,
+	hooks command.Hooks,
+	w command.StreamResponder,
+	cfg command.Configuration,
+	bufferPool *command.BufferPool,
+) *tcpClient {
+	return &tcpClient{
+		relayClient: newRelayClient(presetType, relayProtocol{
+			remoteData:        TCPServerRemoteData,
+			hookOutput:        TCPServerHookOutputBeforeConnecting,
+			dialFailed:        TCPServerDialFailed,
+			remoteUnavailable: ErrTCPUnableToReceiveRemoteConn,
+		}, l, hooks, w, cfg, bufferPool),
+		upgrade: upgrade,
+	}
+}
+
+func parseTCPConfig(p configuration.Preset) (configuration.Preset, error) {
+	// There is no default port for arbitrary protocols
+	if _, _, err := net.SplitHostPort(p.Host); err != nil {
+		return p, fmt.Errorf("invalid Host: %s", err)
+	}
+
+	return p, nil
+}
+
+// remoteAllowed returns whether or not the remote at `addr` can be connected
+func (d *tcpClient) remoteAllowed(addr string) bool {
+	if !d.cfg.OnlyAllowPresetRemotes {
+		return true
+	}
+
+	_, ok := d.cfg.Preset(d.presetType, addr)
+
+	return ok
+}
+
+// Bootup starts the TCP client
+//
+// The Bootup data contains only the Address of the remote
+func (d *tcpClient) Bootup(
+	r *rw.LimitedReader,
+	b []byte,
+) (command.FSMState, command.FSMError) {
+	sBuf := d.bufferPool.Get()
+	defer d.bufferPool.Put(sBuf)
+
+	addr, addrErr := ParseAddress(r.Read, (*sBuf)[:tcpMaxHostnameLen])
+	if addrErr != nil {
+		return nil, command.ToFSMError(
+			addrErr, TCPRequestErrorBadRemoteAddress)
+	}
+
+	addrStr := addr.String()
+
+	// The Dial enforces it too, but it allows the remotes of other types
+	if !d.remoteAllowed(addrStr) {
+		return nil, command.ToFSMError(
+			ErrTCPRemoteNotAllowed, TCPRequestErrorRemoteNotAllowed)
+	}
+
+	d.start(func() { d.remote(addrStr) })
+
+	return d.client, command.NoFSMError()
+}
+
+func (d *tcpClient) remote(addr string) {
+	u := d.bufferPool.Get()
+	defer d.bufferPool.Put(u)
+
+	dialCtx, dialCtxCancel := context.WithTimeout(d.baseCtx, d.cfg.DialTimeout)
+	defer dialCtxCancel()
+
+	remoteConn, err := d.dial(dialCtx, addr, *u)
+	if err != nil {
+		return
+	}
+	defer remoteConn.Close()
+
+	if d.upgrade != nil {
+		remoteConn, err = d.upgrade(d, dialCtx, remoteConn, addr, *u)
+		if err != nil {
+			d.l.Debug("Unable to upgrade remote connection: %s", err)
+			d.sendDialFailed(*u, err)
+			return
+		}
+		defer remoteConn.Close()
+	}
+
+	err = d.w.SendManual(TCPServerDialConnected, (*u)[:d.w.HeaderSize()])
+	if err != nil {
+		return
+	}
+
+	timeoutRemoteConn := d.writeTimeout(remoteConn)
+
+	d.remoteChan <- &timeoutRemoteConn
+
+	d.relay(remoteConn, *u)
+}
+
+func (d *tcpClient) client(
+	f *command.FSM,
+	r *rw.LimitedReader,
+	h command.StreamHeader,
+	b []byte,
+) error {
+	if h.Marker() != TCPClientData {
+		return ErrTCPUnknownClientSignal
+	}
+
+	remoteConn, remoteConnErr := d.getRemote()
+	if remoteConnErr != nil {
+		return remoteConnErr
+	}
+
+	for !r.Completed() {
+		rBuf, rErr := r.Buffered()
+		if rErr != nil {
+			return rErr
+		}
+
+		_, wErr := remoteConn.Write(rBuf)
+		if wErr != nil {
+			remoteConn.Close()
+			d.l.Debug("Failed to write data to remote: %s", wErr)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/network"
+)
+
+func TestParseTCPConfig(t *testing.T) {
+	if _, err := parseTCPConfig(configuration.Preset{
+		Host: "mail.test:25",
+	}); err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if _, err := parseTCPConfig(configuration.Preset{
+		Host: "mail.test",
+	}); err == nil {
+		t.Error("Expecting Host without port to be rejected")
+		return
+	}
+}
+
+func TestTCPRemoteAllowed(t *testing.T) {
+	d := &tcpClient{relayClient: &relayClient{
+		presetType: tcpPresetType,
+		cfg: command.Configuration{
+			OnlyAllowPresetRemotes: true,
+			Presets: []configuration.Preset{
+				{Type: tcpPresetType, Host: "mail.test:25"},
+				{Type: telnetPresetType, Host: "router.test:23"},
+			},
+		},
+	}}
+	if !d.remoteAllowed("mail.test:25") {
+		t.Error("Expecting the TCP Preset to be allowed")
+		return
+	}
+	if d.remoteAllowed("router.test:23") {
+		t.Error("Expecting the Preset of another type to be refused")
+		return
+	}
+	d.cfg.OnlyAllowPresetRemotes = false
+	if !d.remoteAllowed("router.test:23") {
+		t.Error("Expecting any remote to be allowed")
+		return
+	}
+}
+
+func TestTCPRelay(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unable to listen:", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	b := make([]byte, 64)
+	n, err := testAddress(t, listener.Addr().String()).Marshal(b)
+	if err != nil {
+		t.Fatal("Unable to marshal address:", err)
+	}
+	s := testCommandStream(t, command.Configuration{
+		Dial:        network.TCPDial(),
+		DialTimeout: 5 * time.Second,
+	}, 0x04, b[:n])
+	if marker, d := s.receive(); marker != TCPServerDialConnected {
+		t.Errorf("Unable to connect: %d, %s", marker, d)
+		return
+	}
+	s.send(TCPClientData, []byte("Hello World"))
+	received := ""
+	for len(received) < len("Hello World") {
+		marker, d := s.receive()
+		if marker != TCPServerRemoteData {
+			t.Errorf("Expecting remote data, got %d instead", marker)
+			return
+		}
+		received += string(d)
+	}
+	if received != "Hello World" {
+		t.Errorf("Expecting to receive %q, got %q instead",
+			"Hello World", received)
+		return
+	}
+}
@@ -19,15 +19,17 @@ package commands
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nirui/sshwifty/application/command"
 	"github.com/nirui/sshwifty/application/configuration"
 	"github.com/nirui/sshwifty/application/log"
-	"github.com/nirui/sshwifty/application/network"
 	"github.com/nirui/sshwifty/application/rw"
 )
 
@@ -35,6 +37,15 @@ import (
 var (
 	ErrTelnetUnableToReceiveRemoteConn = errors.New(
 		"unable to acquire remote connection handle")
+
+	ErrTelnetUnknownClientSignal = errors.New(
+		"unknown client signal")
+
+	ErrTelnetStartTLSRefused = errors.New(
+		"the remote refused to start TLS")
+
+	ErrTelnetStartTLSTooLong = errors.New(
+		"too much data was received before the remote started TLS")
 )
 
 // Error codes
@@ -43,7 +54,10 @@ const (
 )
 
 const (
-	telnetDefaultPortString = "23"
+	telnetPresetType           = "Telnet"
+	telnetDefaultPortString    = "23"
+	telnetDefaultTLSPortString = "992"
+	telnetDefaultTermType      = "xterm-256color"
 )
 
 // Server signal codes
@@ -54,17 +68,16 @@ const (
 	TelnetServerDialConnected              = 0x03
 )
 
+// Client signal codes
+const (
+	TelnetClientRemoteBand = 0x00
+	TelnetClientResize     = 0x01
+)
+
 type telnetClient struct {
-	l             log.Logger
-	hooks         command.Hooks
-	w             command.StreamResponder
-	cfg           command.Configuration
-	bufferPool    *command.BufferPool
-	baseCtx       context.Context
-	baseCtxCancel func()
-	remoteChan    chan net.Conn
-	remoteConn    net.Conn
-	closeWait     sync.WaitGroup
+	*relayClient
+	options   *telnetOptions
+	loggingIn atomic.Bool
 }
 
 func newTelnet(
@@ -74,27 +87,63 @@ func newTelnet(
 	cfg command.Configuration,
 	bufferPool *command.BufferPool,
 ) command.FSMMachine {
-	ctx, ctxCancel := context.WithCancel(context.Background())
+	return newTelnetClient(telnetPresetType, l, hooks, w, cfg, bufferPool)
+}
+
+// newTelnetClient creates a telnetClient which serves remotes of the
+// `presetType`
+func newTelnetClient(
+	presetType string,
+	l log.Logger,
+	hooks command.Hooks,
+	w command.StreamResponder,
+	cfg command.Configuration,
+	bufferPool *command.BufferPool,
+) *telnetClient {
 	return &telnetClient{
-		l:             l,
-		hooks:         hooks,
-		w:             w,
-		cfg:           cfg,
-		bufferPool:    bufferPool,
-		baseCtx:       ctx,
-		baseCtxCancel: sync.OnceFunc(ctxCancel),
-		remoteChan:    make(chan net.Conn, 1),
-		remoteConn:    nil,
-		closeWait:     sync.WaitGroup{},
+		relayClient: newRelayClient(presetType, relayProtocol{
+			remoteData:        TelnetServerRemoteBand,
+			hookOutput:        TelnetServerHookOutputBeforeConnecting,
+			dialFailed:        TelnetServerDialFailed,
+			remoteUnavailable: ErrTelnetUnableToReceiveRemoteConn,
+		}, l, hooks, w, cfg, bufferPool),
+		options: newTelnetOptions(telnetDefaultTermType),
 	}
 }
 
 func parseTelnetConfig(p configuration.Preset) (configuration.Preset, error) {
+	// The private key and the login secrets are used by the backend
+	// directly, never send them to the client
+	p = p.MoveToSecrets(append(telnetLoginSendMetaNames(p.Meta),
+		tlsClientPrivateKeyMeta)...)
+
+	if _, err := parseTelnetLoginScript(p.Meta, p.Secrets); err != nil {
+		return p, err
+	}
+
+	mode, err := parseTLSMode(p.Meta)
+	if err != nil {
+		return p, err
+	}
+
+	defaultPort := telnetDefaultPortString
+
+	// STARTTLS is negotiated on the plain Telnet port
+	if mode == tlsModeImplicit {
+		defaultPort = telnetDefaultTLSPortString
+	}
+
+	if mode != tlsModeDisabled {
+		if _, err := parseTLSSettings(p.Meta, p.Secrets); err != nil {
+			return p, err
+		}
+	}
+
 	oldHost := p.Host
 
 	_, _, sErr := net.SplitHostPort(p.Host)
 	if sErr != nil {
-		p.Host = net.JoinHostPort(p.Host, telnetDefaultPortString)
+		p.Host = net.JoinHostPort(p.Host, defaultPort)
 	}
 
 	if len(p.Host) <= 0 {
@@ -120,96 +169,301 @@ func (d *telnetClient) Bootup(
 			addrErr, TelnetRequestErrorBadRemoteAddress)
 	}
 
-	d.closeWait.Add(1)
-	go d.remote(addr.String())
+	addrStr := addr.String()
+
+	// Zero Preset (when not found) enables nothing
+	preset, _ := d.cfg.Preset(d.presetType, addrStr)
+
+	// Already been verified by parseTelnetConfig
+	script, _ := parseTelnetLoginScript(preset.Meta, preset.Secrets)
+
+	// Must be set before the remote is started, otherwise the client input
+	// may be received before the login begins
+	d.loggingIn.Store(len(script.steps) > 0)
+
+	d.start(func() { d.remote(addrStr, preset, script) })
 
 	return d.client, command.NoFSMError()
 }
 
-func (d *telnetClient) remote(addr string) {
+func (d *telnetClient) remote(
+	addr string,
+	preset configuration.Preset,
+	script telnetLoginScript,
+) {
 	u := d.bufferPool.Get()
 	defer d.bufferPool.Put(u)
 
-	defer func() {
-		d.w.Signal(command.HeaderClose)
-		close(d.remoteChan)
-		d.baseCtxCancel()
-		d.closeWait.Done()
-	}()
-
-	err := d.hooks.Run(
-		d.baseCtx,
-		configuration.HOOK_BEFORE_CONNECTING,
-		command.NewHookParameters(2).
-			Insert("Remote Type", "Telnet").
-			Insert("Remote Address", addr),
-		command.NewDefaultHookOutput(d.l, func(
-			b []byte,
-		) (wLen int, wErr error) {
-			wLen = len(b)
-			dLen := copy((*u)[d.w.HeaderSize():], b) + d.w.HeaderSize()
-			wErr = d.w.SendManual(
-				TelnetServerHookOutputBeforeConnecting,
-				(*u)[:dLen],
-			)
-			return
-		}),
-	)
+	dialCtx, dialCtxCancel := context.WithTimeout(d.baseCtx, d.cfg.DialTimeout)
+	defer dialCtxCancel()
+
+	clientConn, err := d.dial(dialCtx, addr, *u)
 	if err != nil {
-		errLen := copy((*u)[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
-		d.w.SendManual(TelnetServerDialFailed, (*u)[:errLen])
 		return
 	}
+	defer clientConn.Close()
 
-	dialCtx, dialCtxCancel := context.WithTimeout(d.baseCtx, d.cfg.DialTimeout)
-	defer dialCtxCancel()
-	clientConn, err := d.cfg.Dial(dialCtx, "tcp", addr)
+	clientConn, err = d.wrapTLS(dialCtx, clientConn, addr, preset)
 	if err != nil {
-		errLen := copy((*u)[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
-		d.w.SendManual(TelnetServerDialFailed, (*u)[:errLen])
+		d.l.Debug("Unable to establish TLS connection: %s", err)
+		d.sendDialFailed(*u, err)
 		return
 	}
-	defer clientConn.Close()
+
+	timeoutClientConn := d.writeTimeout(clientConn)
+
+	if d.options.comPortAllowed {
+		// Already been verified by parseSerialConfig
+		settings, _ := parseSerialSettings(preset.Meta)
+
+		_, err = timeoutClientConn.Write(
+			d.options.offerComPort(settings, nil))
+		if err != nil {
+			d.sendDialFailed(*u, err)
+			return
+		}
+	}
+
+	o := d.bufferPool.Get()
+	defer d.bufferPool.Put(o)
+
+	var received []byte
+
+	if len(script.steps) > 0 {
+		// The remote is not reachable by Close until the login is finished,
+		// so it has to be closed here to stop the login
+		stopLoginInterrupt := context.AfterFunc(d.baseCtx, func() {
+			clientConn.Close()
+		})
+		received, err = d.login(clientConn, &timeoutClientConn, script, *o)
+		stopLoginInterrupt()
+		if err != nil {
+			d.l.Debug("Unable to login: %s", err)
+			d.sendDialFailed(*u, err)
+			return
+		}
+
+		d.loggingIn.Store(false)
+	}
 
 	err = d.w.SendManual(TelnetServerDialConnected, (*u)[:d.w.HeaderSize()])
 	if err != nil {
 		return
 	}
 
-	// Set timeout for writer, otherwise the Timeout writer will never
-	// be triggered
-	clientConn.SetWriteDeadline(time.Now().Add(d.cfg.DialTimeout))
-	timeoutClientConn := network.NewWriteTimeoutConn(
-		clientConn, d.cfg.DialTimeout)
+	if len(received) > 0 {
+		rLen := copy((*u)[d.w.HeaderSize():], received) + d.w.HeaderSize()
+		err = d.w.SendManual(TelnetServerRemoteBand, (*u)[:rLen])
+		if err != nil {
+			return
+		}
+	}
+
+	err = d.sendComPortReports(*u)
+	if err != nil {
+		return
+	}
 
 	d.remoteChan <- &timeoutClientConn
 
+	// Leave room in the output for the bytes carried over from the last read
+	maxReadLen := len(*o) - d.w.HeaderSize() - telnetMaxCarryLen
+
 	for {
-		rLen, err := clientConn.Read((*u)[d.w.HeaderSize():])
+		rLen, err := clientConn.Read((*u)[:maxReadLen])
 		if err != nil {
 			return
 		}
 
-		wErr := d.w.SendManual(
-			TelnetServerRemoteBand, (*u)[:rLen+d.w.HeaderSize()])
+		out, reply := d.options.process(
+			(*u)[:rLen], (*o)[:d.w.HeaderSize()])
+
+		if len(reply) > 0 {
+			_, wErr := timeoutClientConn.Write(reply)
+			if wErr != nil {
+				d.l.Debug("Failed to reply to remote: %s", wErr)
+				return
+			}
+		}
+
+		wErr := d.sendComPortReports(*u)
 		if wErr != nil {
 			return
 		}
+
+		if len(out) <= d.w.HeaderSize() {
+			continue
+		}
+
+		wErr = d.w.SendManual(TelnetServerRemoteBand, out)
+		if wErr != nil {
+			return
+		}
+	}
+}
+
+// wrapTLS wraps `conn` in TLS when it's enabled by the `preset`, otherwise
+// `conn` is returned as is
+func (d *telnetClient) wrapTLS(
+	ctx context.Context,
+	conn net.Conn,
+	addr string,
+	preset configuration.Preset,
+) (net.Conn, error) {
+	// Already been verified by parseTelnetConfig
+	mode, _ := parseTLSMode(preset.Meta)
+	if mode == tlsModeDisabled {
+		return conn, nil
+	}
+
+	settings, err := parseTLSSettings(preset.Meta, preset.Secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode == tlsModeStartTLS {
+		err = telnetStartTLS(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tlsConn, err := tlsHandshake(ctx, conn, settings.config(addr))
+	if err != nil {
+		return nil, err
 	}
+
+	return tlsConn, nil
 }
 
-func (d *telnetClient) getRemote() (net.Conn, error) {
-	if d.remoteConn != nil {
-		return d.remoteConn, nil
+// telnetStartTLS asks the remote to start TLS on `conn` through the Telnet
+// START_TLS option, and returns once the remote is ready for the handshake.
+//
+// All other negotiation requests are refused and the data sent by the remote
+// is discarded, as the Telnet session starts over once TLS is established.
+// `conn` is read one byte at a time, so nothing after the request of the
+// remote to start TLS is consumed
+func telnetStartTLS(ctx context.Context, conn net.Conn) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		err := conn.SetDeadline(deadline)
+		if err != nil {
+			return err
+		}
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	_, err := conn.Write(
+		[]byte{telnetCmdIAC, telnetCmdWill, telnetOptStartTLS})
+	if err != nil {
+		return err
 	}
 
-	remoteConn, ok := <-d.remoteChan
-	if !ok {
-		return nil, ErrTelnetUnableToReceiveRemoteConn
+	follows := []byte{
+		telnetCmdIAC, telnetCmdSB, telnetOptStartTLS, telnetOptStartTLSFollows,
+		telnetCmdIAC, telnetCmdSE,
 	}
-	d.remoteConn = remoteConn
+	followsSent := false
+	state := telnetParseData
+	verb := byte(0)
+	sub := make([]byte, 0, telnetMaxSubnegotiationLen)
+	b := [1]byte{}
+
+	for i := 0; i < telnetMaxStartTLSLen; i++ {
+		_, err = io.ReadFull(conn, b[:])
+		if err != nil {
+			return err
+		}
 
-	return d.remoteConn, nil
+		c := b[0]
+
+		switch state {
+		case telnetParseData:
+			if c == telnetCmdIAC {
+				state = telnetParseCommand
+			}
+
+		case telnetParseCommand:
+			state = telnetParseData
+
+			switch c {
+			case telnetCmdWill, telnetCmdWont, telnetCmdDo, telnetCmdDont:
+				verb = c
+				state = telnetParseOption
+
+			case telnetCmdSB:
+				sub = sub[:0]
+				state = telnetParseSubnegotiation
+			}
+
+		case telnetParseOption:
+			state = telnetParseData
+
+			var reply []byte
+
+			switch {
+			case c == telnetOptStartTLS && verb == telnetCmdDont:
+				return ErrTelnetStartTLSRefused
+
+			case c == telnetOptStartTLS && verb == telnetCmdDo:
+				// Some remotes wait for us to follow before they do
+				if !followsSent {
+					reply = follows
+					followsSent = true
+				}
+
+			case verb == telnetCmdWill:
+				reply = []byte{telnetCmdIAC, telnetCmdDont, c}
+
+			case verb == telnetCmdDo:
+				reply = []byte{telnetCmdIAC, telnetCmdWont, c}
+			}
+
+			if len(reply) <= 0 {
+				continue
+			}
+
+			_, err = conn.Write(reply)
+			if err != nil {
+				return err
+			}
+
+		case telnetParseSubnegotiation:
+			if c == telnetCmdIAC {
+				state = telnetParseSubnegotiationCommand
+			} else if len(sub) < cap(sub) {
+				sub = append(sub, c)
+			}
+
+		case telnetParseSubnegotiationCommand:
+			state = telnetParseSubnegotiation
+
+			switch c {
+			case telnetCmdIAC:
+				if len(sub) < cap(sub) {
+					sub = append(sub, c)
+				}
+
+			case telnetCmdSE:
+				state = telnetParseData
+
+				if len(sub) != 2 || sub[0] != telnetOptStartTLS ||
+					sub[1] != telnetOptStartTLSFollows {
+					continue
+				}
+
+				if !followsSent {
+					_, err = conn.Write(follows)
+					if err != nil {
+						return err
+					}
+				}
+
+				return nil
+			}
+		}
+	}
+
+	return ErrTelnetStartTLSTooLong
 }
 
 func (d *telnetClient) client(
@@ -218,41 +472,509 @@ func (d *telnetClient) client(
 	h command.StreamHeader,
 	b []byte,
 ) error {
+	// The remote is not available to the client until the login is finished,
+	// waiting for it here would stop all other streams from being served.
+	// Input during the login would interfere with the login script anyway,
+	// so it's discarded
+	if d.loggingIn.Load() {
+		return nil
+	}
+
 	remoteConn, remoteConnErr := d.getRemote()
 	if remoteConnErr != nil {
 		return remoteConnErr
 	}
 
-	// All Telnet requests are in-band, so we just directly send them all
-	// to the server
-	for !r.Completed() {
-		rBuf, rErr := r.Buffered()
+	switch h.Marker() {
+	case TelnetClientRemoteBand:
+		// Options are negotiated by us, so the negotiation commands sent by
+		// the client are removed before the rest is sent to the server
+		for !r.Completed() {
+			rBuf, rErr := r.Fetch(len(b) - telnetMaxCarryLen)
+			if rErr != nil {
+				return rErr
+			}
+
+			_, wErr := remoteConn.Write(d.options.filter(rBuf, b[:0]))
+			if wErr != nil {
+				remoteConn.Close()
+				d.l.Debug("Failed to write data to remote: %s", wErr)
+			}
+		}
+
+		return nil
+
+	case TelnetClientResize:
+		_, rErr := io.ReadFull(r, b[:4])
+		if rErr != nil {
+			return rErr
+		}
+
+		rows := int(b[0])<<8 | int(b[1])
+		cols := int(b[2])<<8 | int(b[3])
+
+		// It's ok for it to fail
+		_, wErr := remoteConn.Write(d.options.resize(rows, cols, b[:0]))
+		if wErr != nil {
+			d.l.Debug("Failed to resize to %d, %d: %s", rows, cols, wErr)
+		}
+
+		return nil
+
+	case SerialClientSetLine:
+		if !d.options.comPortAllowed {
+			return ErrTelnetUnknownClientSignal
+		}
+
+		_, rErr := io.ReadFull(r, b[:8])
+		if rErr != nil {
+			return rErr
+		}
+
+		settings, sErr := parseSerialSettingsUpdate(b[:8])
+		if sErr != nil {
+			return sErr
+		}
+
+		_, wErr := remoteConn.Write(d.options.setComPort(settings, b[:0]))
+		if wErr != nil {
+			d.l.Debug("Failed to set serial line: %s", wErr)
+		}
+
+		return nil
+
+	case SerialClientBreak:
+		if !d.options.comPortAllowed {
+			return ErrTelnetUnknownClientSignal
+		}
+
+		_, rErr := io.ReadFull(r, b[:2])
 		if rErr != nil {
 			return rErr
 		}
 
-		_, wErr := remoteConn.Write(rBuf)
+		length := time.Duration(binary.BigEndian.Uint16(b[:2])) *
+			time.Millisecond
+		if length <= 0 {
+			length = serialDefaultBreakLength
+		}
+
+		wErr := d.sendBreak(remoteConn, length, b)
 		if wErr != nil {
-			remoteConn.Close()
-			d.l.Debug("Failed to write data to remote: %s", wErr)
+			d.l.Debug("Failed to send BREAK: %s", wErr)
 		}
+
+		return nil
+
+	default:
+		return ErrTelnetUnknownClientSignal
 	}
+}
+
+const (
+	telnetCmdSE   = 240
+	telnetCmdSB   = 250
+	telnetCmdWill = 251
+	telnetCmdWont = 252
+	telnetCmdDo   = 253
+	telnetCmdDont = 254
+	telnetCmdIAC  = 255
+)
+
+// Telnet options
+const (
+	telnetOptBinary            = 0  // RFC 856
+	telnetOptEcho              = 1  // RFC 857
+	telnetOptSuppressGoAhead   = 3  // RFC 858
+	telnetOptTerminalType      = 24 // RFC 1091
+	telnetOptNegotiateWinSize  = 31 // RFC 1073
+	telnetOptStartTLS          = 46 // draft-altman-telnet-starttls
+	telnetOptTerminalTypeIs    = 0
+	telnetOptTerminalTypeSend  = 1
+	telnetOptStartTLSFollows   = 1
+	telnetMaxStartTLSLen       = 4096
+	telnetMaxSubnegotiationLen = 64
+
+	// The max amount of bytes that can be carried over from the last input
+	// and written to the output together with the current input
+	telnetMaxCarryLen = 2
+)
 
-	return nil
+// telnetLocalOptions are options we are willing to enable on our side when
+// the remote asks us to
+var telnetLocalOptions = map[byte]bool{
+	telnetOptBinary:           true,
+	telnetOptSuppressGoAhead:  true,
+	telnetOptTerminalType:     true,
+	telnetOptNegotiateWinSize: true,
 }
 
-func (d *telnetClient) Close() error {
-	remoteConn, remoteConnErr := d.getRemote()
-	if remoteConnErr == nil {
-		remoteConn.Close()
+// telnetRemoteOptions are options we allow the remote to enable on its side
+var telnetRemoteOptions = map[byte]bool{
+	telnetOptBinary:          true,
+	telnetOptEcho:            true,
+	telnetOptSuppressGoAhead: true,
+}
+
+type telnetParseState byte
+
+const (
+	telnetParseData telnetParseState = iota
+	telnetParseCommand
+	telnetParseOption
+	telnetParseSubnegotiation
+	telnetParseSubnegotiationCommand
+)
+
+// telnetOptions negotiates Telnet options with the remote on behalf of the
+// client.
+//
+// Data received from the remote is parsed by process, which answers the
+// negotiation requests and removes them from the data. The only exception is
+// the ECHO option, whose changes are also sent to the client so it can turn
+// its local echo on and off accordingly. Escaped 0xff bytes (IAC IAC) are left
+// as is for the client to unescape.
+//
+// Data sent by the client is parsed by filter, which removes the negotiation
+// requests as they are already handled by us
+type telnetOptions struct {
+	lock        sync.Mutex
+	termType    string
+	rows        int
+	cols        int
+	local       [256]bool
+	remote      [256]bool
+	offered     [256]bool
+	state       telnetParseState
+	verb        byte
+	sub         []byte
+	clientState telnetParseState
+	forwardEcho bool
+
+	// COM Port Control (RFC 2217) is only allowed for the serial consoles
+	comPortAllowed  bool
+	comPortAccepted bool
+	comPort         serialSettings
+	comPortReports  []telnetComPortReport
+}
+
+func newTelnetOptions(termType string) *telnetOptions {
+	return &telnetOptions{
+		lock:        sync.Mutex{},
+		termType:    termType,
+		rows:        0,
+		cols:        0,
+		local:       [256]bool{},
+		remote:      [256]bool{},
+		offered:     [256]bool{},
+		state:       telnetParseData,
+		verb:        0,
+		sub:         make([]byte, 0, telnetMaxSubnegotiationLen),
+		clientState: telnetParseData,
+		forwardEcho: true,
+
+		comPortAllowed:  false,
+		comPortAccepted: false,
+		comPort:         serialSettings{},
+		comPortReports:  nil,
 	}
+}
 
-	d.baseCtxCancel()
-	d.closeWait.Wait()
-	return nil
+// process parses the data `b` received from the remote. The data that should
+// be sent to the client is appended to `out`, and the returned reply should be
+// sent back to the remote.
+//
+// At most telnetMaxCarryLen bytes more than len(b) will be appended to `out`
+func (t *telnetOptions) process(b []byte, out []byte) ([]byte, []byte) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var reply []byte
+
+	for _, c := range b {
+		switch t.state {
+		case telnetParseData:
+			if c == telnetCmdIAC {
+				t.state = telnetParseCommand
+				continue
+			}
+
+			out = append(out, c)
+
+		case telnetParseCommand:
+			t.state = telnetParseData
+
+			switch c {
+			case telnetCmdIAC:
+				out = append(out, telnetCmdIAC, telnetCmdIAC)
+
+			case telnetCmdWill, telnetCmdWont, telnetCmdDo, telnetCmdDont:
+				t.verb = c
+				t.state = telnetParseOption
+
+			case telnetCmdSB:
+				t.sub = t.sub[:0]
+				t.state = telnetParseSubnegotiation
+
+			default:
+				// Other commands (GA, NOP etc) mean nothing to the client
+			}
+
+		case telnetParseOption:
+			t.state = telnetParseData
+			out, reply = t.negotiate(t.verb, c, out, reply)
+
+		case telnetParseSubnegotiation:
+			if c == telnetCmdIAC {
+				t.state = telnetParseSubnegotiationCommand
+				continue
+			}
+
+			if len(t.sub) < cap(t.sub) {
+				t.sub = append(t.sub, c)
+			}
+
+		case telnetParseSubnegotiationCommand:
+			switch c {
+			case telnetCmdSE:
+				t.state = telnetParseData
+				reply = t.subnegotiate(reply)
+
+			case telnetCmdIAC:
+				t.state = telnetParseSubnegotiation
+
+				if len(t.sub) < cap(t.sub) {
+					t.sub = append(t.sub, c)
+				}
+
+			default:
+				t.state = telnetParseSubnegotiation
+			}
+		}
+	}
+
+	return out, reply
 }
 
-func (d *telnetClient) Release() error {
-	d.baseCtxCancel()
-	return nil
+// negotiate answers the `verb` request of option `opt`. A reply is only sent
+// when the state of the option changes, so the negotiation will not loop
+func (t *telnetOptions) negotiate(
+	verb byte,
+	opt byte,
+	out []byte,
+	reply []byte,
+) ([]byte, []byte) {
+	switch verb {
+	case telnetCmdWill:
+		if !telnetRemoteOptions[opt] {
+			return out, append(reply, telnetCmdIAC, telnetCmdDont, opt)
+		}
+
+		if t.remote[opt] {
+			return out, reply
+		}
+
+		t.remote[opt] = true
+		reply = append(reply, telnetCmdIAC, telnetCmdDo, opt)
+
+		if opt == telnetOptEcho && t.forwardEcho {
+			out = append(out, telnetCmdIAC, telnetCmdWill, opt)
+		}
+
+	case telnetCmdWont:
+		if !t.remote[opt] {
+			return out, reply
+		}
+
+		t.remote[opt] = false
+		reply = append(reply, telnetCmdIAC, telnetCmdDont, opt)
+
+		if opt == telnetOptEcho && t.forwardEcho {
+			out = append(out, telnetCmdIAC, telnetCmdWont, opt)
+		}
+
+	case telnetCmdDo:
+		if !telnetLocalOptions[opt] &&
+			(opt != telnetOptComPort || !t.comPortAllowed) {
+			return out, append(reply, telnetCmdIAC, telnetCmdWont, opt)
+		}
+
+		// No need to answer when the remote is accepting our offer
+		if !t.local[opt] {
+			t.local[opt] = true
+
+			if !t.offered[opt] {
+				reply = append(reply, telnetCmdIAC, telnetCmdWill, opt)
+			}
+		}
+
+		if opt == telnetOptNegotiateWinSize {
+			reply = t.appendWindowSize(reply)
+		}
+
+		if opt == telnetOptComPort {
+			t.comPortAccepted = true
+			reply = appendComPortSettings(reply, t.comPort)
+		}
+
+	case telnetCmdDont:
+		if !t.local[opt] {
+			return out, reply
+		}
+
+		t.local[opt] = false
+		reply = append(reply, telnetCmdIAC, telnetCmdWont, opt)
+
+		if opt == telnetOptComPort {
+			t.comPortAccepted = false
+			t.reportComPort(telnetComPortReport{refused: true})
+		}
+	}
+
+	return out, reply
+}
+
+// subnegotiate answers the subnegotiation that has just been received
+func (t *telnetOptions) subnegotiate(reply []byte) []byte {
+	if len(t.sub) >= 2 && t.sub[0] == telnetOptComPort && t.comPortAccepted {
+		t.reportComPort(telnetComPortReport{
+			refused: false,
+			data:    append([]byte{}, t.sub[1:]...),
+		})
+
+		return reply
+	}
+
+	if len(t.sub) < 2 || t.sub[0] != telnetOptTerminalType ||
+		t.sub[1] != telnetOptTerminalTypeSend ||
+		!t.local[telnetOptTerminalType] {
+		return reply
+	}
+
+	reply = append(reply,
+		telnetCmdIAC, telnetCmdSB,
+		telnetOptTerminalType, telnetOptTerminalTypeIs)
+	reply = append(reply, t.termType...)
+
+	return append(reply, telnetCmdIAC, telnetCmdSE)
+}
+
+// appendWindowSize appends the NAWS subnegotiation to `reply`. Nothing will be
+// appended when the window size is still unknown
+func (t *telnetOptions) appendWindowSize(reply []byte) []byte {
+	if t.rows <= 0 || t.cols <= 0 {
+		return reply
+	}
+
+	reply = append(reply, telnetCmdIAC, telnetCmdSB, telnetOptNegotiateWinSize)
+
+	for _, v := range []int{t.cols, t.rows} {
+		for _, c := range []byte{byte(v >> 8), byte(v)} {
+			if c == telnetCmdIAC {
+				reply = append(reply, telnetCmdIAC)
+			}
+
+			reply = append(reply, c)
+		}
+	}
+
+	return append(reply, telnetCmdIAC, telnetCmdSE)
+}
+
+// pauseEchoForwarding stops sending the changes of the ECHO option to the
+// client until resumeEchoForwarding is called
+func (t *telnetOptions) pauseEchoForwarding() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.forwardEcho = false
+}
+
+// resumeEchoForwarding resumes sending the changes of the ECHO option to the
+// client. If the remote has enabled ECHO in the meantime, the change will be
+// appended to `out`
+func (t *telnetOptions) resumeEchoForwarding(out []byte) []byte {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.forwardEcho = true
+
+	if !t.remote[telnetOptEcho] {
+		return out
+	}
+
+	return append(out, telnetCmdIAC, telnetCmdWill, telnetOptEcho)
+}
+
+// resize updates the window size, and appends the request that should be sent
+// to the remote to `reply`. If NAWS has not been enabled, it will be offered
+// to the remote once, and the window size is sent after the remote accepts it
+func (t *telnetOptions) resize(rows int, cols int, reply []byte) []byte {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.rows, t.cols = rows, cols
+
+	if t.local[telnetOptNegotiateWinSize] {
+		return t.appendWindowSize(reply)
+	}
+
+	if t.offered[telnetOptNegotiateWinSize] {
+		return reply
+	}
+
+	t.offered[telnetOptNegotiateWinSize] = true
+
+	return append(reply,
+		telnetCmdIAC, telnetCmdWill, telnetOptNegotiateWinSize)
+}
+
+// filter removes the negotiation requests from the data `b` sent by the
+// client, and appends the rest to `out`.
+//
+// At most 1 byte more than len(b) will be appended to `out`
+func (t *telnetOptions) filter(b []byte, out []byte) []byte {
+	for _, c := range b {
+		switch t.clientState {
+		case telnetParseData:
+			if c == telnetCmdIAC {
+				t.clientState = telnetParseCommand
+				continue
+			}
+
+			out = append(out, c)
+
+		case telnetParseCommand:
+			t.clientState = telnetParseData
+
+			switch c {
+			case telnetCmdWill, telnetCmdWont, telnetCmdDo, telnetCmdDont:
+				t.clientState = telnetParseOption
+
+			case telnetCmdSB:
+				t.clientState = telnetParseSubnegotiation
+
+			default:
+				// Including IAC IAC, and commands such as Interrupt Process
+				out = append(out, telnetCmdIAC, c)
+			}
+
+		case telnetParseOption:
+			t.clientState = telnetParseData
+
+		case telnetParseSubnegotiation:
+			if c == telnetCmdIAC {
+				t.clientState = telnetParseSubnegotiationCommand
+			}
+
+		case telnetParseSubnegotiationCommand:
+			if c == telnetCmdSE {
+				t.clientState = telnetParseData
+			} else {
+				t.clientState = telnetParseSubnegotiation
+			}
+		}
+	}
+
+	return out
 }
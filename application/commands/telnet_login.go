@@ -0,0 +1,205 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors
+var (
+	ErrTelnetLoginStepsNotContinuous = errors.New(
+		"login steps must be numbered continuously from 1")
+)
+
+const (
+	telnetLoginExpectMetaPrefix = "Login Expect "
+	telnetLoginSendMetaPrefix   = "Login Send "
+	telnetLoginTimeoutMeta      = "Login Timeout"
+	telnetDefaultLoginTimeout   = 10 * time.Second
+	telnetMaxLoginReceivedLen   = 1024
+	telnetLoginNewLine          = "\r\n"
+)
+
+// telnetLoginStep waits for the output of the remote to match `expect`, then
+// sends `send` followed by a new line (if `hasSend` is true)
+type telnetLoginStep struct {
+	expect  *regexp.Regexp
+	send    string
+	hasSend bool
+}
+
+// telnetLoginScript is a list of steps to login to the remote automatically
+type telnetLoginScript struct {
+	steps   []telnetLoginStep
+	timeout time.Duration
+}
+
+// telnetLoginSendMetaNames returns the names of the Meta items that defines
+// what to send during login. They may contain secrets
+func telnetLoginSendMetaNames(meta map[string]string) []string {
+	names := make([]string, 0, len(meta))
+	for k := range meta {
+		if strings.HasPrefix(k, telnetLoginSendMetaPrefix) {
+			names = append(names, k)
+		}
+	}
+	return names
+}
+
+// telnetLoginStepIndex returns the step index in the Meta item name `k`, or 0
+// if it's not a login step item
+func telnetLoginStepIndex(k string) (int, bool) {
+	for _, prefix := range []string{
+		telnetLoginExpectMetaPrefix,
+		telnetLoginSendMetaPrefix,
+	} {
+		n, ok := strings.CutPrefix(k, prefix)
+		if !ok {
+			continue
+		}
+		i, err := strconv.ParseUint(n, 10, 16)
+		if err != nil {
+			return 0, true
+		}
+		return int(i), true
+	}
+	return 0, false
+}
+
+// parseTelnetLoginScript parses the login script defined by the Preset. Each
+// step is defined by a "Login Expect N" Meta item which is a regular
+// expression, and an optional "Login Send N" item (which is expected to be
+// found in `secrets`). Steps are executed in the order of N, starting from 1
+func parseTelnetLoginScript(
+	meta map[string]string,
+	secrets map[string]string,
+) (telnetLoginScript, error) {
+	script := telnetLoginScript{timeout: telnetDefaultLoginTimeout}
+	for i := 1; ; i++ {
+		expect, ok := meta[telnetLoginExpectMetaPrefix+strconv.Itoa(i)]
+		if !ok {
+			break
+		}
+		re, err := regexp.Compile(expect)
+		if err != nil {
+			return script, fmt.Errorf("invalid %q Meta: %s",
+				telnetLoginExpectMetaPrefix+strconv.Itoa(i), err)
+		}
+		send, hasSend := secrets[telnetLoginSendMetaPrefix+strconv.Itoa(i)]
+		script.steps = append(script.steps, telnetLoginStep{
+			expect:  re,
+			send:    send,
+			hasSend: hasSend,
+		})
+	}
+	for _, m := range []map[string]string{meta, secrets} {
+		for k := range m {
+			i, ok := telnetLoginStepIndex(k)
+			if ok && (i <= 0 || i > len(script.steps)) {
+				return script, fmt.Errorf("invalid %q Meta: %s",
+					k, ErrTelnetLoginStepsNotContinuous)
+			}
+		}
+	}
+	if v, ok := meta[telnetLoginTimeoutMeta]; ok {
+		timeout, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || timeout <= 0 {
+			return script, fmt.Errorf(
+				"invalid %q Meta: must be a positive duration such as \"10s\"",
+				telnetLoginTimeoutMeta)
+		}
+		script.timeout = timeout
+	}
+	return script, nil
+}
+
+// telnetEscape escapes the 0xff bytes in `s` so they will not be mistaken as
+// Telnet commands
+func telnetEscape(s string) []byte {
+	return bytes.ReplaceAll(
+		[]byte(s), []byte{telnetCmdIAC}, []byte{telnetCmdIAC, telnetCmdIAC})
+}
+
+// login runs the login `script` against the output read from `conn`, and
+// writes the responds to `w`. Output of the remote is not sent to the client
+// during login, so the secrets will not be echoed back. The output received
+// after the last match is returned so it can be sent to the client later.
+//
+// Telnet options are negotiated as usual, except the changes of the ECHO
+// option are held back until the login is finished
+func (d *telnetClient) login(
+	conn net.Conn,
+	w io.Writer,
+	script telnetLoginScript,
+	b []byte,
+) ([]byte, error) {
+	d.options.pauseEchoForwarding()
+	received := make([]byte, 0, telnetMaxLoginReceivedLen+len(b))
+	for i, step := range script.steps {
+		conn.SetReadDeadline(time.Now().Add(script.timeout))
+		for {
+			if m := step.expect.FindIndex(received); m != nil {
+				received = append(received[:0], received[m[1]:]...)
+				break
+			}
+			if len(received) > telnetMaxLoginReceivedLen {
+				received = append(received[:0],
+					received[len(received)-telnetMaxLoginReceivedLen:]...)
+			}
+			rLen, err := conn.Read(b[:len(b)-telnetMaxCarryLen])
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, fmt.Errorf("login step %d timed out waiting for "+
+					"the remote to output %q", i+1, step.expect)
+			} else if err != nil {
+				return nil, fmt.Errorf("login step %d failed: %s", i+1, err)
+			}
+			var reply []byte
+			received, reply = d.options.process(b[:rLen], received)
+			if len(reply) <= 0 {
+				continue
+			}
+			if _, err = w.Write(reply); err != nil {
+				return nil, fmt.Errorf("login step %d failed: %s", i+1, err)
+			}
+		}
+		if !step.hasSend {
+			continue
+		}
+		_, err := w.Write(telnetEscape(step.send + telnetLoginNewLine))
+		if err != nil {
+			return nil, fmt.Errorf("login step %d failed: %s", i+1, err)
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+	if len(received) > telnetMaxLoginReceivedLen {
+		// Don't leave half of an escaped 0xff at the beginning
+		received = bytes.TrimLeft(
+			received[len(received)-telnetMaxLoginReceivedLen:], "\xff")
+	}
+	return d.options.resumeEchoForwarding(received), nil
+}
@@ -0,0 +1,244 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/network"
+)
+
+func TestParseTelnetLoginScript(t *testing.T) {
+	p, err := parseTelnetConfig(configuration.Preset{
+		Host: "router.test",
+		Meta: map[string]string{
+			"Login Expect 1": "login: $",
+			"Login Send 1":   "admin",
+			"Login Expect 2": "Password: $",
+			"Login Send 2":   "secret",
+			"Login Expect 3": "[>#] $",
+			"Login Timeout":  "3s",
+		},
+	})
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if len(telnetLoginSendMetaNames(p.Meta)) > 0 {
+		t.Errorf("Expecting the login secrets to be moved to the Secrets, "+
+			"got %v", p.Meta)
+		return
+	}
+	script, err := parseTelnetLoginScript(p.Meta, p.Secrets)
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if len(script.steps) != 3 || script.timeout != 3*time.Second {
+		t.Errorf("Unexpected script: %v", script)
+		return
+	}
+	if script.steps[1].send != "secret" || script.steps[2].hasSend {
+		t.Errorf("Unexpected steps: %v", script.steps)
+		return
+	}
+}
+
+func TestParseTelnetLoginScriptInvalid(t *testing.T) {
+	for _, meta := range []map[string]string{
+		{"Login Expect 1": "("},
+		{"Login Expect 2": "login:"},
+		{"Login Expect 1": "login:", "Login Send 2": "admin"},
+		{"Login Expect one": "login:"},
+		{"Login Expect 1": "login:", "Login Timeout": "0s"},
+	} {
+		_, err := parseTelnetConfig(configuration.Preset{
+			Host: "router.test",
+			Meta: meta,
+		})
+		if err == nil {
+			t.Errorf("Expecting %v to be rejected", meta)
+			return
+		}
+	}
+}
+
+func testTelnetLoginClient() *telnetClient {
+	return &telnetClient{options: newTelnetOptions(telnetDefaultTermType)}
+}
+
+func TestTelnetLogin(t *testing.T) {
+	script, err := parseTelnetLoginScript(map[string]string{
+		"Login Expect 1": "login: $",
+		"Login Expect 2": "Password: $",
+		"Login Expect 3": "Welcome",
+	}, map[string]string{
+		"Login Send 1": "admin",
+		"Login Send 2": "secret",
+	})
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	local, remote := net.Pipe()
+	defer local.Close()
+	remoteErr := make(chan string, 1)
+	go func() {
+		defer remote.Close()
+		r := bufio.NewReader(remote)
+		for _, step := range []struct{ output, expect string }{
+			{"\xff\xfb\x01Router\r\nlogin: ", "admin\r\n"},
+			{"Password: ", "secret\r\n"},
+		} {
+			if _, err := remote.Write([]byte(step.output)); err != nil {
+				remoteErr <- err.Error()
+				return
+			}
+			if strings.HasPrefix(step.output, "\xff") {
+				// Reply of the WILL ECHO
+				if _, err := r.Discard(3); err != nil {
+					remoteErr <- err.Error()
+					return
+				}
+			}
+			line, err := r.ReadString('\n')
+			if err != nil || line != step.expect {
+				remoteErr <- "unexpected input: " + line
+				return
+			}
+		}
+		remote.Write([]byte("\r\nWelcome\r\n$ "))
+		remoteErr <- ""
+	}()
+	d := testTelnetLoginClient()
+	received, err := d.login(local, local, script, make([]byte, 4096))
+	if err != nil {
+		t.Error("Failed to login:", err)
+		return
+	}
+	if e := <-remoteErr; e != "" {
+		t.Error("Remote failed:", e)
+		return
+	}
+	// The ECHO enabled during login must be sent to the client afterwards
+	expected := "\r\n$ \xff\xfb\x01"
+	if string(received) != expected {
+		t.Errorf("Expecting %q to be received, got %q instead",
+			expected, received)
+		return
+	}
+}
+
+func TestTelnetLoginTimeout(t *testing.T) {
+	script, err := parseTelnetLoginScript(map[string]string{
+		"Login Expect 1": "login: $",
+		"Login Timeout":  "100ms",
+	}, nil)
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	go remote.Write([]byte("Router\r\n"))
+	d := testTelnetLoginClient()
+	_, err = d.login(local, local, script, make([]byte, 4096))
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expecting a timeout error, got %v instead", err)
+		return
+	}
+}
+
+func TestTelnetLoginClientInput(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unable to listen:", err)
+	}
+	defer listener.Close()
+	proceed := make(chan struct{})
+	lines := make(chan string, 2)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-proceed
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("login: "))
+		line, _ := r.ReadString('\n')
+		lines <- line
+		// Nothing follows, so there is no output for the client to read
+		conn.Write([]byte("\r\nWelcome"))
+		line, _ = r.ReadString('\n')
+		lines <- line
+	}()
+	b := make([]byte, 64)
+	n, err := testAddress(t, listener.Addr().String()).Marshal(b)
+	if err != nil {
+		t.Fatal("Unable to marshal address:", err)
+	}
+	s := testCommandStream(t, command.Configuration{
+		Dial:        network.TCPDial(),
+		DialTimeout: 5 * time.Second,
+		Presets: []configuration.Preset{{
+			Type: telnetPresetType,
+			Host: listener.Addr().String(),
+			Meta: map[string]string{
+				"Login Expect 1": "login: $",
+				"Login Expect 2": "Welcome",
+			},
+			Secrets: map[string]string{"Login Send 1": "admin"},
+		}},
+	}, 0x00, b[:n])
+	s.send(TelnetClientRemoteBand, []byte("typed\r\n"))
+	// The handler must still be responsive while the login is running
+	s.input <- []byte{byte(command.HeaderControl | 2),
+		command.HeaderControlEcho, 'E'}
+	if e := s.read(3); e[2] != 'E' {
+		t.Errorf("Expecting an echo, got %v instead", e)
+		return
+	}
+	close(proceed)
+	if marker, d := s.receive(); marker != TelnetServerDialConnected {
+		t.Errorf("Unable to connect: %d, %s", marker, d)
+		return
+	}
+	s.send(TelnetClientRemoteBand, []byte("ls\r\n"))
+	for _, expected := range []string{"admin\r\n", "ls\r\n"} {
+		select {
+		case line := <-lines:
+			if line == expected {
+				continue
+			}
+			t.Errorf("Expecting the remote to receive %q, got %q instead",
+				expected, line)
+			return
+		case <-time.After(10 * time.Second):
+			t.Errorf("Expecting the remote to receive %q", expected)
+			return
+		}
+	}
+}
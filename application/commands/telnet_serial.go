@@ -0,0 +1,413 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// Errors
+var (
+	ErrSerialInvalidLineSettings = errors.New(
+		"invalid serial line settings")
+)
+
+// Server signal codes, the ones shared with Telnet have the same values
+const (
+	SerialServerRemoteBand                 = 0x00
+	SerialServerHookOutputBeforeConnecting = 0x01
+	SerialServerDialFailed                 = 0x02
+	SerialServerDialConnected              = 0x03
+	SerialServerComPort                    = 0x04
+	SerialServerComPortRefused             = 0x05
+)
+
+// Client signal codes, the ones shared with Telnet have the same values
+const (
+	SerialClientRemoteBand = 0x00
+	SerialClientResize     = 0x01
+	SerialClientSetLine    = 0x02
+	SerialClientBreak      = 0x03
+)
+
+const (
+	serialPresetType         = "Serial"
+	serialBaudRateMeta       = "Baud Rate"
+	serialDataBitsMeta       = "Data Bits"
+	serialParityMeta         = "Parity"
+	serialStopBitsMeta       = "Stop Bits"
+	serialFlowControlMeta    = "Flow Control"
+	serialDefaultBreakLength = 250 * time.Millisecond
+	serialMaxComPortReports  = 32
+)
+
+// Telnet COM Port Control option, see RFC 2217. The commands sent by the
+// server are the ones sent by the client plus telnetComPortServerOffset
+const (
+	telnetOptComPort                 = 44
+	telnetComPortSetBaudRate         = 1
+	telnetComPortSetDataSize         = 2
+	telnetComPortSetParity           = 3
+	telnetComPortSetStopSize         = 4
+	telnetComPortSetControl          = 5
+	telnetComPortServerOffset        = 100
+	telnetComPortControlNoFlow       = 1
+	telnetComPortControlHardwareFlow = 3
+	telnetComPortControlBreakOn      = 5
+	telnetComPortControlBreakOff     = 6
+	telnetComPortParitySpace         = 5
+	telnetComPortStopSizeOneHalf     = 3
+)
+
+var (
+	serialParities = map[string]byte{
+		"none":  1,
+		"odd":   2,
+		"even":  3,
+		"mark":  4,
+		"space": telnetComPortParitySpace,
+	}
+
+	serialStopBits = map[string]byte{
+		"1":   1,
+		"2":   2,
+		"1.5": telnetComPortStopSizeOneHalf,
+	}
+
+	serialFlowControls = map[string]byte{
+		"none":     telnetComPortControlNoFlow,
+		"xon/xoff": 2,
+		"hardware": telnetComPortControlHardwareFlow,
+	}
+)
+
+// serialSettings contains the line settings of a serial port. The values are
+// the ones defined by RFC 2217, 0 means the setting is left unchanged
+type serialSettings struct {
+	baudRate    uint32
+	dataSize    byte
+	parity      byte
+	stopSize    byte
+	flowControl byte
+}
+
+// defaultSerialSettings returns the settings of 9600 baud, 8 data bits, no
+// parity, 1 stop bit and no flow control
+func defaultSerialSettings() serialSettings {
+	return serialSettings{
+		baudRate:    9600,
+		dataSize:    8,
+		parity:      serialParities["none"],
+		stopSize:    serialStopBits["1"],
+		flowControl: serialFlowControls["none"],
+	}
+}
+
+// parseSerialName looks up the value of Meta `name` in `values`
+func parseSerialName(
+	meta map[string]string,
+	name string,
+	values map[string]byte,
+	v *byte,
+) error {
+	s, ok := meta[name]
+	if !ok {
+		return nil
+	}
+	parsed, ok := values[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		names := make([]string, 0, len(values))
+		for k := range values {
+			names = append(names, strconv.Quote(k))
+		}
+		return fmt.Errorf("invalid %q Meta: must be one of %s",
+			name, strings.Join(names, ", "))
+	}
+	*v = parsed
+	return nil
+}
+
+// parseSerialSettings parses the line settings defined by the Preset, the
+// settings that are not defined are set to the defaults
+func parseSerialSettings(meta map[string]string) (serialSettings, error) {
+	s := defaultSerialSettings()
+	if v, ok := meta[serialBaudRateMeta]; ok {
+		baudRate, err := strconv.ParseUint(strings.TrimSpace(v), 10, 32)
+		if err != nil || baudRate <= 0 {
+			return s, fmt.Errorf("invalid %q Meta: must be a positive "+
+				"integer such as \"115200\"", serialBaudRateMeta)
+		}
+		s.baudRate = uint32(baudRate)
+	}
+	if v, ok := meta[serialDataBitsMeta]; ok {
+		dataSize, err := strconv.ParseUint(strings.TrimSpace(v), 10, 8)
+		if err != nil || dataSize < 5 || dataSize > 8 {
+			return s, fmt.Errorf("invalid %q Meta: must be 5, 6, 7 or 8",
+				serialDataBitsMeta)
+		}
+		s.dataSize = byte(dataSize)
+	}
+	for _, n := range []struct {
+		name   string
+		values map[string]byte
+		v      *byte
+	}{
+		{serialParityMeta, serialParities, &s.parity},
+		{serialStopBitsMeta, serialStopBits, &s.stopSize},
+		{serialFlowControlMeta, serialFlowControls, &s.flowControl},
+	} {
+		if err := parseSerialName(meta, n.name, n.values, n.v); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// parseSerialSettingsUpdate parses the SerialClientSetLine data `b`
+//
+// Line settings format:
+// +---------+-----------+--------+-----------+--------------+
+// | 4 bytes |  1 byte   | 1 byte |  1 byte   |    1 byte    |
+// +---------+-----------+--------+-----------+--------------+
+// |  Baud   | Data Bits | Parity | Stop Bits | Flow Control |
+// +---------+-----------+--------+-----------+--------------+
+//
+// The values are the ones defined by RFC 2217, 0 leaves the setting unchanged
+func parseSerialSettingsUpdate(b []byte) (serialSettings, error) {
+	s := serialSettings{
+		baudRate:    binary.BigEndian.Uint32(b[0:4]),
+		dataSize:    b[4],
+		parity:      b[5],
+		stopSize:    b[6],
+		flowControl: b[7],
+	}
+	if (s.dataSize != 0 && (s.dataSize < 5 || s.dataSize > 8)) ||
+		s.parity > telnetComPortParitySpace ||
+		s.stopSize > telnetComPortStopSizeOneHalf ||
+		s.flowControl > telnetComPortControlHardwareFlow {
+		return s, ErrSerialInvalidLineSettings
+	}
+	return s, nil
+}
+
+// telnetComPortReport is a COM port subnegotiation received from the remote,
+// or the refusal of the COM Port Control option if `refused` is true
+type telnetComPortReport struct {
+	refused bool
+	data    []byte
+}
+
+func newSerial(
+	l log.Logger,
+	hooks command.Hooks,
+	w command.StreamResponder,
+	cfg command.Configuration,
+	bufferPool *command.BufferPool,
+) command.FSMMachine {
+	d := newTelnetClient(serialPresetType, l, hooks, w, cfg, bufferPool)
+	d.options.comPortAllowed = true
+	return d
+}
+
+func parseSerialConfig(p configuration.Preset) (configuration.Preset, error) {
+	// There is no default port for RFC 2217
+	if _, _, err := net.SplitHostPort(p.Host); err != nil {
+		return p, fmt.Errorf("invalid Host: %s", err)
+	}
+	p, err := parseTelnetConfig(p)
+	if err != nil {
+		return p, err
+	}
+	if _, err := parseSerialSettings(p.Meta); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// appendComPortCommand appends the COM port subnegotiation of `cmd` with
+// `value` to `reply`
+func appendComPortCommand(reply []byte, cmd byte, value ...byte) []byte {
+	reply = append(reply, telnetCmdIAC, telnetCmdSB, telnetOptComPort, cmd)
+	for _, c := range value {
+		if c == telnetCmdIAC {
+			reply = append(reply, telnetCmdIAC)
+		}
+		reply = append(reply, c)
+	}
+	return append(reply, telnetCmdIAC, telnetCmdSE)
+}
+
+// appendComPortSettings appends the commands that apply the non-zero settings
+// in `s` to `reply`
+func appendComPortSettings(reply []byte, s serialSettings) []byte {
+	if s.baudRate > 0 {
+		reply = appendComPortCommand(reply, telnetComPortSetBaudRate,
+			binary.BigEndian.AppendUint32(nil, s.baudRate)...)
+	}
+	for _, c := range []struct {
+		cmd   byte
+		value byte
+	}{
+		{telnetComPortSetDataSize, s.dataSize},
+		{telnetComPortSetParity, s.parity},
+		{telnetComPortSetStopSize, s.stopSize},
+		{telnetComPortSetControl, s.flowControl},
+	} {
+		if c.value > 0 {
+			reply = appendComPortCommand(reply, c.cmd, c.value)
+		}
+	}
+	return reply
+}
+
+// offerComPort offers the COM Port Control option to the remote, and appends
+// the request to `reply`. The settings `s` are applied once the remote agrees
+func (t *telnetOptions) offerComPort(s serialSettings, reply []byte) []byte {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.comPort = s
+	t.local[telnetOptComPort] = true
+
+	return append(reply, telnetCmdIAC, telnetCmdWill, telnetOptComPort)
+}
+
+// setComPort updates the non-zero settings in `s`, and appends the commands
+// that apply them to `reply` if the remote has agreed to COM Port Control
+func (t *telnetOptions) setComPort(s serialSettings, reply []byte) []byte {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if s.baudRate > 0 {
+		t.comPort.baudRate = s.baudRate
+	}
+	for _, v := range []struct {
+		from byte
+		to   *byte
+	}{
+		{s.dataSize, &t.comPort.dataSize},
+		{s.parity, &t.comPort.parity},
+		{s.stopSize, &t.comPort.stopSize},
+		{s.flowControl, &t.comPort.flowControl},
+	} {
+		if v.from > 0 {
+			*v.to = v.from
+		}
+	}
+
+	if !t.comPortAccepted {
+		return reply
+	}
+
+	return appendComPortSettings(reply, s)
+}
+
+// controlComPort appends the SET-CONTROL command of `value` to `reply` if the
+// remote has agreed to COM Port Control
+func (t *telnetOptions) controlComPort(value byte, reply []byte) []byte {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.comPortAccepted {
+		return reply
+	}
+
+	return appendComPortCommand(reply, telnetComPortSetControl, value)
+}
+
+// reportComPort saves `r` so it can be taken by takeComPortReports. Reports
+// will be dropped if too many of them have not been taken
+func (t *telnetOptions) reportComPort(r telnetComPortReport) {
+	if len(t.comPortReports) >= serialMaxComPortReports {
+		return
+	}
+
+	t.comPortReports = append(t.comPortReports, r)
+}
+
+// takeComPortReports returns and removes the saved COM port reports
+func (t *telnetOptions) takeComPortReports() []telnetComPortReport {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	reports := t.comPortReports
+	t.comPortReports = nil
+
+	return reports
+}
+
+// sendComPortReports sends the saved COM port reports to the client. The
+// reports that are received after the COM Port Control has been agreed
+// contain the actual settings of the serial port
+func (d *telnetClient) sendComPortReports(b []byte) error {
+	for _, r := range d.options.takeComPortReports() {
+		if r.refused {
+			err := d.w.SendManual(
+				SerialServerComPortRefused, b[:d.w.HeaderSize()])
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		rLen := copy(b[d.w.HeaderSize():], r.data) + d.w.HeaderSize()
+
+		err := d.w.SendManual(SerialServerComPort, b[:rLen])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendBreak sends BREAK to the serial port for the given `length`. Because
+// the client signals are handled one after another, the BREAK is ended in the
+// background
+func (d *telnetClient) sendBreak(
+	conn net.Conn,
+	length time.Duration,
+	b []byte,
+) error {
+	_, err := conn.Write(
+		d.options.controlComPort(telnetComPortControlBreakOn, b[:0]))
+	if err != nil {
+		return err
+	}
+
+	time.AfterFunc(length, func() {
+		_, err := conn.Write(
+			d.options.controlComPort(telnetComPortControlBreakOff, nil))
+		if err != nil {
+			d.l.Debug("Failed to end BREAK: %s", err)
+		}
+	})
+
+	return nil
+}
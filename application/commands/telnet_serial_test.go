@@ -0,0 +1,330 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/configuration"
+)
+
+// testSerialCommand is a COM port command received by testRFC2217Server
+type testSerialCommand struct {
+	cmd   byte
+	value string
+}
+
+// testRFC2217Server starts a stub RFC 2217 server which accepts the COM Port
+// Control option, and acknowledges each command by sending it back with the
+// server command code. The received commands are sent to the returned channel
+func testRFC2217Server(t *testing.T) (string, chan testSerialCommand) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	commands := make(chan testSerialCommand, 16)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var received []byte
+		buf := make([]byte, 256)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			received = append(received, buf[:n]...)
+			for {
+				if bytes.HasPrefix(received, []byte{
+					telnetCmdIAC, telnetCmdWill, telnetOptComPort,
+				}) {
+					conn.Write([]byte{
+						telnetCmdIAC, telnetCmdDo, telnetOptComPort})
+					received = received[3:]
+					continue
+				}
+				end := bytes.Index(received, []byte{telnetCmdIAC, telnetCmdSE})
+				if end < 0 || !bytes.HasPrefix(received, []byte{
+					telnetCmdIAC, telnetCmdSB, telnetOptComPort,
+				}) {
+					break
+				}
+				value := bytes.ReplaceAll(received[4:end],
+					[]byte{telnetCmdIAC, telnetCmdIAC}, []byte{telnetCmdIAC})
+				commands <- testSerialCommand{received[3], string(value)}
+				conn.Write(appendComPortCommand(nil,
+					received[3]+telnetComPortServerOffset, value...))
+				received = received[end+2:]
+			}
+		}
+	}()
+	return listener.Addr().String(), commands
+}
+
+func testExpectSerialCommands(
+	t *testing.T,
+	commands chan testSerialCommand,
+	expected []testSerialCommand,
+) bool {
+	for _, e := range expected {
+		select {
+		case c := <-commands:
+			if c != e {
+				t.Errorf("Expecting command %v, got %v instead", e, c)
+				return false
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("Timed out waiting for command %v", e)
+			return false
+		}
+	}
+	return true
+}
+
+// testSerialReports reads from `conn` until `n` COM port reports are received
+func testSerialReports(
+	t *testing.T,
+	o *telnetOptions,
+	conn net.Conn,
+	n int,
+) []telnetComPortReport {
+	var reports []telnetComPortReport
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(reports) < n {
+		rLen, err := conn.Read(buf[:len(buf)-telnetMaxCarryLen])
+		if err != nil {
+			t.Fatal("Failed to read:", err)
+		}
+		out, reply := o.process(buf[:rLen], nil)
+		if len(out) > 0 {
+			t.Fatalf("Unexpected output %v", out)
+		}
+		if _, err := conn.Write(reply); err != nil {
+			t.Fatal("Failed to reply:", err)
+		}
+		reports = append(reports, o.takeComPortReports()...)
+	}
+	return reports
+}
+
+func TestParseSerialSettings(t *testing.T) {
+	s, err := parseSerialSettings(map[string]string{
+		serialBaudRateMeta:    "115200",
+		serialDataBitsMeta:    "7",
+		serialParityMeta:      "Even",
+		serialStopBitsMeta:    "1.5",
+		serialFlowControlMeta: "Hardware",
+	})
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	expected := serialSettings{
+		baudRate:    115200,
+		dataSize:    7,
+		parity:      3,
+		stopSize:    telnetComPortStopSizeOneHalf,
+		flowControl: telnetComPortControlHardwareFlow,
+	}
+	if s != expected {
+		t.Errorf("Expecting %v, got %v instead", expected, s)
+		return
+	}
+	if s, _ := parseSerialSettings(nil); s != defaultSerialSettings() {
+		t.Errorf("Expecting the default settings, got %v instead", s)
+		return
+	}
+	for _, meta := range []map[string]string{
+		{serialBaudRateMeta: "0"},
+		{serialDataBitsMeta: "9"},
+		{serialParityMeta: "strange"},
+		{serialStopBitsMeta: "3"},
+		{serialFlowControlMeta: "pigeon"},
+	} {
+		if _, err := parseSerialSettings(meta); err == nil {
+			t.Errorf("Expecting %v to be rejected", meta)
+			return
+		}
+	}
+}
+
+func TestParseSerialConfig(t *testing.T) {
+	if _, err := parseSerialConfig(configuration.Preset{
+		Host: "console.test:7001",
+		Meta: map[string]string{serialBaudRateMeta: "115200"},
+	}); err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if _, err := parseSerialConfig(configuration.Preset{
+		Host: "console.test",
+	}); err == nil {
+		t.Error("Expecting Host without port to be rejected")
+		return
+	}
+}
+
+func TestParseSerialSettingsUpdate(t *testing.T) {
+	s, err := parseSerialSettingsUpdate([]byte{0, 0, 0x25, 0x80, 0, 0, 2, 0})
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if s != (serialSettings{baudRate: 9600, stopSize: 2}) {
+		t.Errorf("Unexpected settings %v", s)
+		return
+	}
+	for _, b := range [][]byte{
+		{0, 0, 0, 0, 4, 0, 0, 0},
+		{0, 0, 0, 0, 0, 6, 0, 0},
+		{0, 0, 0, 0, 0, 0, 4, 0},
+		{0, 0, 0, 0, 0, 0, 0, telnetComPortControlBreakOn},
+	} {
+		if _, err := parseSerialSettingsUpdate(b); err == nil {
+			t.Errorf("Expecting %v to be rejected", b)
+			return
+		}
+	}
+}
+
+func TestAppendComPortCommandEscape(t *testing.T) {
+	reply := appendComPortCommand(nil, telnetComPortSetBaudRate,
+		0x00, 0x00, telnetCmdIAC, 0x00)
+	expected := []byte{
+		telnetCmdIAC, telnetCmdSB, telnetOptComPort, telnetComPortSetBaudRate,
+		0x00, 0x00, telnetCmdIAC, telnetCmdIAC, 0x00,
+		telnetCmdIAC, telnetCmdSE,
+	}
+	if !bytes.Equal(reply, expected) {
+		t.Errorf("Expecting %v, got %v instead", expected, reply)
+		return
+	}
+}
+
+func TestTelnetOptionsComPort(t *testing.T) {
+	address, commands := testRFC2217Server(t)
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Error("Failed to dial:", err)
+		return
+	}
+	defer conn.Close()
+	o := newTelnetOptions("xterm")
+	o.comPortAllowed = true
+	settings := serialSettings{
+		baudRate:    115200,
+		dataSize:    8,
+		parity:      1,
+		stopSize:    1,
+		flowControl: telnetComPortControlNoFlow,
+	}
+	// Settings can't be changed before the remote agrees
+	if r := o.setComPort(serialSettings{dataSize: 7}, nil); len(r) > 0 {
+		t.Errorf("Unexpected request %v", r)
+		return
+	}
+	if _, err := conn.Write(o.offerComPort(settings, nil)); err != nil {
+		t.Error("Failed to offer:", err)
+		return
+	}
+	reports := testSerialReports(t, o, conn, 5)
+	if !testExpectSerialCommands(t, commands, []testSerialCommand{
+		{telnetComPortSetBaudRate, "\x00\x01\xc2\x00"},
+		{telnetComPortSetDataSize, "\x08"},
+		{telnetComPortSetParity, "\x01"},
+		{telnetComPortSetStopSize, "\x01"},
+		{telnetComPortSetControl, "\x01"},
+	}) {
+		return
+	}
+	expected := []byte{
+		telnetComPortServerOffset + telnetComPortSetBaudRate,
+		0x00, 0x01, 0xc2, 0x00,
+	}
+	if reports[0].refused || !bytes.Equal(reports[0].data, expected) {
+		t.Errorf("Expecting report %v, got %v instead", expected, reports[0])
+		return
+	}
+	_, err = conn.Write(o.setComPort(serialSettings{
+		baudRate: 9600,
+		parity:   3,
+	}, nil))
+	if err != nil {
+		t.Error("Failed to set:", err)
+		return
+	}
+	_, err = conn.Write(o.controlComPort(telnetComPortControlBreakOn, nil))
+	if err != nil {
+		t.Error("Failed to send BREAK:", err)
+		return
+	}
+	if !testExpectSerialCommands(t, commands, []testSerialCommand{
+		{telnetComPortSetBaudRate, "\x00\x00\x25\x80"},
+		{telnetComPortSetParity, "\x03"},
+		{telnetComPortSetControl, "\x05"},
+	}) {
+		return
+	}
+	if o.comPort.baudRate != 9600 || o.comPort.dataSize != 8 {
+		t.Errorf("Unexpected settings %v", o.comPort)
+		return
+	}
+}
+
+func TestTelnetOptionsComPortRefused(t *testing.T) {
+	o := newTelnetOptions("xterm")
+	o.comPortAllowed = true
+	o.offerComPort(defaultSerialSettings(), nil)
+	_, reply := o.process([]byte{
+		telnetCmdIAC, telnetCmdDont, telnetOptComPort,
+	}, nil)
+	expected := []byte{telnetCmdIAC, telnetCmdWont, telnetOptComPort}
+	if !bytes.Equal(reply, expected) {
+		t.Errorf("Expecting reply %v, got %v instead", expected, reply)
+		return
+	}
+	reports := o.takeComPortReports()
+	if len(reports) != 1 || !reports[0].refused {
+		t.Errorf("Expecting the refusal to be reported, got %v", reports)
+		return
+	}
+	if r := o.controlComPort(telnetComPortControlBreakOn, nil); len(r) > 0 {
+		t.Errorf("Unexpected request %v", r)
+		return
+	}
+}
+
+func TestTelnetOptionsComPortNotAllowed(t *testing.T) {
+	o := newTelnetOptions("xterm")
+	_, reply := o.process([]byte{
+		telnetCmdIAC, telnetCmdDo, telnetOptComPort,
+	}, nil)
+	expected := []byte{telnetCmdIAC, telnetCmdWont, telnetOptComPort}
+	if !bytes.Equal(reply, expected) {
+		t.Errorf("Expecting reply %v, got %v instead", expected, reply)
+		return
+	}
+}
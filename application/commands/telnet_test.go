@@ -0,0 +1,193 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTelnetOptionsProcess(t *testing.T) {
+	o := newTelnetOptions("xterm")
+	out, reply := o.process([]byte{
+		'a', telnetCmdIAC, telnetCmdIAC, 'b',
+		telnetCmdIAC, telnetCmdDo, telnetOptTerminalType,
+		telnetCmdIAC, telnetCmdWill, telnetOptEcho,
+		telnetCmdIAC, telnetCmdDo, 0x99,
+		telnetCmdIAC, telnetCmdWill, 0x99,
+		telnetCmdIAC, telnetCmdSB, telnetOptTerminalType,
+		telnetOptTerminalTypeSend, telnetCmdIAC, telnetCmdSE,
+		'c',
+	}, nil)
+	expectedOut := []byte{
+		'a', telnetCmdIAC, telnetCmdIAC, 'b',
+		telnetCmdIAC, telnetCmdWill, telnetOptEcho,
+		'c',
+	}
+	if !bytes.Equal(out, expectedOut) {
+		t.Errorf("Expecting output %v, got %v instead", expectedOut, out)
+		return
+	}
+	expectedReply := []byte{
+		telnetCmdIAC, telnetCmdWill, telnetOptTerminalType,
+		telnetCmdIAC, telnetCmdDo, telnetOptEcho,
+		telnetCmdIAC, telnetCmdWont, 0x99,
+		telnetCmdIAC, telnetCmdDont, 0x99,
+		telnetCmdIAC, telnetCmdSB, telnetOptTerminalType,
+		telnetOptTerminalTypeIs, 'x', 't', 'e', 'r', 'm',
+		telnetCmdIAC, telnetCmdSE,
+	}
+	if !bytes.Equal(reply, expectedReply) {
+		t.Errorf("Expecting reply %v, got %v instead", expectedReply, reply)
+		return
+	}
+	// Repeated requests must not be answered again
+	out, reply = o.process([]byte{
+		telnetCmdIAC, telnetCmdWill, telnetOptEcho,
+	}, nil)
+	if len(out) != 0 || len(reply) != 0 {
+		t.Errorf("Unexpected output %v and reply %v", out, reply)
+		return
+	}
+}
+
+func TestTelnetOptionsProcessSplit(t *testing.T) {
+	o := newTelnetOptions("xterm")
+	input := []byte{
+		'a', telnetCmdIAC, telnetCmdIAC,
+		telnetCmdIAC, telnetCmdWill, telnetOptEcho, 'b',
+	}
+	out := make([]byte, 0, len(input)+telnetMaxCarryLen)
+	for i := range input {
+		before := len(out)
+		out, _ = o.process(input[i:i+1], out)
+		if len(out)-before > 1+telnetMaxCarryLen {
+			t.Errorf("Too many bytes appended: %d", len(out)-before)
+			return
+		}
+	}
+	expected := []byte{
+		'a', telnetCmdIAC, telnetCmdIAC,
+		telnetCmdIAC, telnetCmdWill, telnetOptEcho, 'b',
+	}
+	if !bytes.Equal(out, expected) {
+		t.Errorf("Expecting output %v, got %v instead", expected, out)
+		return
+	}
+}
+
+func TestTelnetOptionsResize(t *testing.T) {
+	o := newTelnetOptions("xterm")
+	// Window size is unknown, so only WILL is sent
+	_, reply := o.process([]byte{
+		telnetCmdIAC, telnetCmdDo, telnetOptNegotiateWinSize,
+	}, nil)
+	expected := []byte{telnetCmdIAC, telnetCmdWill, telnetOptNegotiateWinSize}
+	if !bytes.Equal(reply, expected) {
+		t.Errorf("Expecting reply %v, got %v instead", expected, reply)
+		return
+	}
+	reply = o.resize(24, 0x01ff, nil)
+	expected = []byte{
+		telnetCmdIAC, telnetCmdSB, telnetOptNegotiateWinSize,
+		0x01, telnetCmdIAC, telnetCmdIAC, 0x00, 24,
+		telnetCmdIAC, telnetCmdSE,
+	}
+	if !bytes.Equal(reply, expected) {
+		t.Errorf("Expecting reply %v, got %v instead", expected, reply)
+		return
+	}
+}
+
+func TestTelnetOptionsResizeOffer(t *testing.T) {
+	o := newTelnetOptions("xterm")
+	// Only WILL is sent until the remote accepts it
+	reply := o.resize(24, 80, nil)
+	expected := []byte{telnetCmdIAC, telnetCmdWill, telnetOptNegotiateWinSize}
+	if !bytes.Equal(reply, expected) {
+		t.Errorf("Expecting reply %v, got %v instead", expected, reply)
+		return
+	}
+	// WILL is not sent again while waiting for the answer
+	reply = o.resize(25, 80, nil)
+	if len(reply) > 0 {
+		t.Errorf("Expecting no reply, got %v instead", reply)
+		return
+	}
+	// The remote accepted, so the last window size is sent without WILL
+	_, reply = o.process([]byte{
+		telnetCmdIAC, telnetCmdDo, telnetOptNegotiateWinSize,
+	}, nil)
+	expected = []byte{
+		telnetCmdIAC, telnetCmdSB, telnetOptNegotiateWinSize,
+		0x00, 80, 0x00, 25,
+		telnetCmdIAC, telnetCmdSE,
+	}
+	if !bytes.Equal(reply, expected) {
+		t.Errorf("Expecting reply %v, got %v instead", expected, reply)
+		return
+	}
+}
+
+func TestTelnetOptionsResizeOfferRefused(t *testing.T) {
+	o := newTelnetOptions("xterm")
+	reply := o.resize(24, 80, nil)
+	expected := []byte{telnetCmdIAC, telnetCmdWill, telnetOptNegotiateWinSize}
+	if !bytes.Equal(reply, expected) {
+		t.Errorf("Expecting reply %v, got %v instead", expected, reply)
+		return
+	}
+	// The refusal of our offer must not be answered
+	_, reply = o.process([]byte{
+		telnetCmdIAC, telnetCmdDont, telnetOptNegotiateWinSize,
+	}, nil)
+	if len(reply) > 0 {
+		t.Errorf("Expecting no reply, got %v instead", reply)
+		return
+	}
+	// And NAWS is never offered again
+	reply = o.resize(25, 80, nil)
+	if len(reply) > 0 {
+		t.Errorf("Expecting no reply, got %v instead", reply)
+		return
+	}
+}
+
+func TestTelnetOptionsFilter(t *testing.T) {
+	o := newTelnetOptions("xterm")
+	input := []byte{
+		'a', telnetCmdIAC, telnetCmdIAC,
+		telnetCmdIAC, telnetCmdDo, telnetOptEcho,
+		telnetCmdIAC, telnetCmdSB, telnetOptNegotiateWinSize,
+		0x00, 80, 0x00, 24, telnetCmdIAC, telnetCmdSE,
+		telnetCmdIAC, 244, 'b',
+	}
+	expected := []byte{'a', telnetCmdIAC, telnetCmdIAC, telnetCmdIAC, 244, 'b'}
+	if out := o.filter(input, nil); !bytes.Equal(out, expected) {
+		t.Errorf("Expecting output %v, got %v instead", expected, out)
+		return
+	}
+	var out []byte
+	for i := range input {
+		out = o.filter(input[i:i+1], out)
+	}
+	if !bytes.Equal(out, expected) {
+		t.Errorf("Expecting split output %v, got %v instead", expected, out)
+		return
+	}
+}
@@ -0,0 +1,187 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Errors
+var (
+	ErrTLSIncompleteClientCertificate = errors.New(
+		"client certificate and its private key must be defined together")
+
+	ErrTLSNoCACertificate = errors.New(
+		"no certificate was found in the CA bundle")
+
+	ErrTLSNoPeerCertificate = errors.New(
+		"no certificate was sent by the remote")
+)
+
+const (
+	tlsMeta                  = "TLS"
+	tlsServerNameMeta        = "TLS Server Name"
+	tlsCABundleMeta          = "TLS CA Bundle"
+	tlsClientCertificateMeta = "TLS Client Certificate"
+	tlsClientPrivateKeyMeta  = "TLS Client Private Key"
+	tlsALPNMeta              = "TLS ALPN"
+)
+
+// tlsMode is the way TLS is enabled by the "TLS" Meta
+type tlsMode byte
+
+const (
+	tlsModeDisabled tlsMode = iota
+	tlsModeImplicit
+	tlsModeStartTLS
+)
+
+const (
+	tlsStartTLSMetaValue = "starttls"
+)
+
+// parseTLSMode returns how TLS is enabled by the "TLS" Meta. TLS is either
+// established right after the connection is made ("true"), or after it's
+// negotiated with the remote through the protocol itself ("starttls")
+func parseTLSMode(meta map[string]string) (tlsMode, error) {
+	v, ok := meta[tlsMeta]
+	if !ok {
+		return tlsModeDisabled, nil
+	}
+	v = strings.TrimSpace(v)
+	if strings.EqualFold(v, tlsStartTLSMetaValue) {
+		return tlsModeStartTLS, nil
+	}
+	enabled, err := strconv.ParseBool(v)
+	if err != nil {
+		return tlsModeDisabled, fmt.Errorf("invalid %q Meta: must be "+
+			"either \"true\", \"false\" or %q", tlsMeta, tlsStartTLSMetaValue)
+	}
+	if !enabled {
+		return tlsModeDisabled, nil
+	}
+	return tlsModeImplicit, nil
+}
+
+// tlsSettings contains settings of the TLS connection to a remote
+type tlsSettings struct {
+	serverName   string
+	roots        *x509.CertPool
+	certificates []tls.Certificate
+	protocols    []string
+}
+
+// parseTLSSettings parses the TLS settings defined by the Preset. The private
+// key of the client certificate is expected to be found in `secrets`
+func parseTLSSettings(
+	meta map[string]string,
+	secrets map[string]string,
+) (tlsSettings, error) {
+	s := tlsSettings{
+		serverName: strings.TrimSpace(meta[tlsServerNameMeta]),
+		protocols:  splitSSHMetaList(meta[tlsALPNMeta]),
+	}
+	if bundle, ok := meta[tlsCABundleMeta]; ok {
+		s.roots = x509.NewCertPool()
+		if !s.roots.AppendCertsFromPEM([]byte(bundle)) {
+			return s, fmt.Errorf("invalid %q Meta: %s",
+				tlsCABundleMeta, ErrTLSNoCACertificate)
+		}
+	}
+	cert, certDefined := meta[tlsClientCertificateMeta]
+	key, keyDefined := secrets[tlsClientPrivateKeyMeta]
+	if certDefined != keyDefined {
+		return s, ErrTLSIncompleteClientCertificate
+	}
+	if !certDefined {
+		return s, nil
+	}
+	pair, err := tls.X509KeyPair([]byte(cert), []byte(key))
+	if err != nil {
+		return s, fmt.Errorf("invalid %q Meta: %s",
+			tlsClientCertificateMeta, err)
+	}
+	s.certificates = []tls.Certificate{pair}
+	return s, nil
+}
+
+// config builds the tls.Config used to connect to the remote at `address`
+func (s tlsSettings) config(address string) *tls.Config {
+	serverName := s.serverName
+	if len(serverName) <= 0 {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		serverName = host
+	}
+	return &tls.Config{
+		ServerName:   serverName,
+		RootCAs:      s.roots,
+		Certificates: s.certificates,
+		NextProtos:   s.protocols,
+	}
+}
+
+// verify verifies the certificate `chain` sent by the remote against the
+// `serverName` the same way the tls package does
+func (s tlsSettings) verify(
+	serverName string,
+	chain []*x509.Certificate,
+) error {
+	if len(chain) <= 0 {
+		return ErrTLSNoPeerCertificate
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         s.roots,
+		DNSName:       serverName,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// tlsHandshake wraps `conn` in a TLS client and performs the handshake. The
+// returned error explains why the handshake has failed in a readable way
+func tlsHandshake(
+	ctx context.Context,
+	conn net.Conn,
+	cfg *tls.Config,
+) (*tls.Conn, error) {
+	tlsConn := tls.Client(conn, cfg)
+	err := tlsConn.HandshakeContext(ctx)
+	if err == nil {
+		return tlsConn, nil
+	}
+	var verifyErr *tls.CertificateVerificationError
+	if errors.As(err, &verifyErr) {
+		return nil, fmt.Errorf("unable to verify the certificate of the "+
+			"remote: %s", verifyErr.Err)
+	}
+	return nil, fmt.Errorf("TLS handshake failed: %s", err)
+}
@@ -0,0 +1,261 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// Server signal codes
+const (
+	TLSServerRemoteData                 = TCPServerRemoteData
+	TLSServerHookOutputBeforeConnecting = TCPServerHookOutputBeforeConnecting
+	TLSServerDialFailed                 = TCPServerDialFailed
+	TLSServerDialConnected              = TCPServerDialConnected
+	TLSServerConnectionInfo             = 0x04
+	TLSServerPeerCertificate            = 0x05
+)
+
+// Client signal codes
+const (
+	TLSClientData = TCPClientData
+)
+
+const (
+	tlsClientPresetType       = "TLS"
+	tlsClientMaxChainLen      = 16
+	tlsClientMaxServerNameLen = 255
+	tlsClientMaxNameLen       = 512
+	tlsClientMaxErrorLen      = 1024
+)
+
+// newTLSClient creates a command that performs a TLS handshake with the
+// remote, reports the details of the handshake to the client, and then
+// relays data through the TLS connection just like the TCP command does
+func newTLSClient(
+	l log.Logger,
+	hooks command.Hooks,
+	w command.StreamResponder,
+	cfg command.Configuration,
+	bufferPool *command.BufferPool,
+) command.FSMMachine {
+	return newTCPClient(
+		tlsClientPresetType, tlsClientUpgrade, l, hooks, w, cfg, bufferPool)
+}
+
+func parseTLSClientConfig(
+	p configuration.Preset,
+) (configuration.Preset, error) {
+	p, err := parseTCPConfig(p)
+	if err != nil {
+		return p, err
+	}
+
+	p = p.MoveToSecrets(tlsClientPrivateKeyMeta)
+
+	if _, err := parseTLSSettings(p.Meta, p.Secrets); err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// tlsClientUpgrade performs the TLS handshake on `conn` and reports the
+// result to the client
+//
+// Unlike the Telnet command, a certificate that cannot be verified does not
+// fail the handshake. Instead, the verification error is reported to the
+// client alongside the certificates, so broken setups can be inspected
+func tlsClientUpgrade(
+	d *tcpClient,
+	ctx context.Context,
+	conn net.Conn,
+	addr string,
+	b []byte,
+) (net.Conn, error) {
+	preset, _ := d.cfg.Preset(tlsClientPresetType, addr)
+
+	settings, err := parseTLSSettings(preset.Meta, preset.Secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := settings.config(addr)
+	cfg.InsecureSkipVerify = true
+
+	tlsConn, err := tlsHandshake(ctx, conn, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	state := tlsConn.ConnectionState()
+	verifyErr := settings.verify(cfg.ServerName, state.PeerCertificates)
+
+	err = d.sendTLSConnectionInfo(state, cfg.ServerName, verifyErr, b)
+	if err != nil {
+		tlsConn.Close()
+
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// sendTLSConnectionInfo sends the details of the TLS connection to the
+// client. The TLSServerConnectionInfo signal is sent first, followed by one
+// TLSServerPeerCertificate signal for each certificate in the chain
+//
+// Connection info format:
+// +---------+---------+----------+-------------+--------------+--------+
+// | 2 bytes | 2 bytes |  String  |   String    |    String    | 1 byte |
+// +---------+---------+----------+-------------+--------------+--------+
+// | Version | Cipher  | Protocol | Server Name | Verify Error | Chain  |
+// +---------+---------+----------+-------------+--------------+--------+
+//
+// The Version and the Cipher are the IANA assigned values. The Protocol is
+// the one negotiated through ALPN. The Verify Error is empty if the chain has
+// been verified. The Chain is the number of the certificates that will follow
+func (d *tcpClient) sendTLSConnectionInfo(
+	state tls.ConnectionState,
+	serverName string,
+	verifyErr error,
+	b []byte,
+) error {
+	chain := state.PeerCertificates
+	if len(chain) > tlsClientMaxChainLen {
+		chain = chain[:tlsClientMaxChainLen]
+	}
+
+	verifyErrStr := ""
+	if verifyErr != nil {
+		verifyErrStr = sshTruncate(verifyErr.Error(), tlsClientMaxErrorLen)
+	}
+
+	start := d.w.HeaderSize()
+	binary.BigEndian.PutUint16(b[start:], state.Version)
+	binary.BigEndian.PutUint16(b[start+2:], state.CipherSuite)
+	start += 4
+
+	for _, s := range []string{
+		state.NegotiatedProtocol,
+		sshTruncate(serverName, tlsClientMaxServerNameLen),
+		verifyErrStr,
+	} {
+		n, err := MarshalString(s, b[start:])
+		if err != nil {
+			return err
+		}
+		start += n
+	}
+
+	b[start] = byte(len(chain))
+	start++
+
+	err := d.w.SendManual(TLSServerConnectionInfo, b[:start])
+	if err != nil {
+		return err
+	}
+
+	for i, cert := range chain {
+		n, err := marshalTLSPeerCertificate(
+			byte(i), cert, b[d.w.HeaderSize():])
+		if err != nil {
+			return err
+		}
+
+		err = d.w.SendManual(
+			TLSServerPeerCertificate, b[:n+d.w.HeaderSize()])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// marshalTLSPeerCertificate marshals the summary of `cert` into `b`
+//
+// Certificate format:
+// +--------+---------+--------+------------+-----------+----------+---------+
+// | 1 byte | String  | String |  8 bytes   |  8 bytes  | 32 bytes | Strings |
+// +--------+---------+--------+------------+-----------+----------+---------+
+// | Index  | Subject | Issuer | Not Before | Not After | SHA256   | Names   |
+// +--------+---------+--------+------------+-----------+----------+---------+
+//
+// The Index is the position of the certificate in the chain, starting from
+// the leaf. Not Before and Not After are Unix timestamps in seconds. The
+// Names are the DNS names and the IP addresses the certificate is issued to,
+// and are truncated when there are too many of them to fit into `b`
+func marshalTLSPeerCertificate(
+	index byte,
+	cert *x509.Certificate,
+	b []byte,
+) (int, error) {
+	b[0] = index
+	start := 1
+
+	for _, s := range []string{
+		sshTruncate(cert.Subject.String(), tlsClientMaxNameLen),
+		sshTruncate(cert.Issuer.String(), tlsClientMaxNameLen),
+	} {
+		n, err := MarshalString(s, b[start:])
+		if err != nil {
+			return 0, err
+		}
+		start += n
+	}
+
+	binary.BigEndian.PutUint64(b[start:], uint64(cert.NotBefore.Unix()))
+	binary.BigEndian.PutUint64(b[start+8:], uint64(cert.NotAfter.Unix()))
+	start += 16
+
+	fingerprint := sha256.Sum256(cert.Raw)
+	start += copy(b[start:], fingerprint[:])
+
+	names := append([]string{}, cert.DNSNames...)
+	namesLen := MaxIntegerBytes
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for i, name := range names {
+		name = sshTruncate(name, tlsClientMaxServerNameLen)
+		namesLen += MaxIntegerBytes + len(name)
+		if start+namesLen > len(b) {
+			names = names[:i]
+			break
+		}
+		names[i] = name
+	}
+
+	n, err := MarshalStrings(names, b[start:])
+	if err != nil {
+		return 0, fmt.Errorf("unable to marshal certificate names: %s", err)
+	}
+
+	return start + n, nil
+}
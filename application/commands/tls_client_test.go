@@ -0,0 +1,193 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/nirui/sshwifty/application/configuration"
+)
+
+func TestParseTLSClientConfig(t *testing.T) {
+	client := testIssueTLSCertificate(t, "client", nil, x509.ExtKeyUsageAny)
+	p, err := parseTLSClientConfig(configuration.Preset{
+		Host: "mail.test:465",
+		Meta: map[string]string{
+			tlsALPNMeta:              "h2, http/1.1",
+			tlsClientCertificateMeta: client.certPEM,
+			tlsClientPrivateKeyMeta:  client.keyPEM,
+		},
+	})
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if _, ok := p.Meta[tlsClientPrivateKeyMeta]; ok {
+		t.Error("Expecting the private key to be removed from the Meta")
+		return
+	}
+	s, err := parseTLSSettings(p.Meta, p.Secrets)
+	if err != nil {
+		t.Error("Failed to parse settings:", err)
+		return
+	}
+	if fmt.Sprint(s.protocols) != "[h2 http/1.1]" {
+		t.Errorf("Unexpected protocols %q", s.protocols)
+		return
+	}
+	if _, err := parseTLSClientConfig(configuration.Preset{
+		Host: "mail.test",
+	}); err == nil {
+		t.Error("Expecting Host without port to be rejected")
+		return
+	}
+}
+
+func TestTLSSettingsVerify(t *testing.T) {
+	ca := testIssueTLSCertificate(t, "ca", nil, x509.ExtKeyUsageAny)
+	server := testIssueTLSCertificate(
+		t, "mail.test", &ca, x509.ExtKeyUsageServerAuth)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	s := tlsSettings{roots: roots}
+	chain := []*x509.Certificate{server.cert}
+	if err := s.verify("mail.test", chain); err != nil {
+		t.Error("Failed to verify:", err)
+		return
+	}
+	if err := s.verify("other.test", chain); err == nil {
+		t.Error("Expecting a mismatched name to fail the verification")
+		return
+	}
+	if err := s.verify("mail.test", nil); err != ErrTLSNoPeerCertificate {
+		t.Errorf("Expecting %v, got %v instead", ErrTLSNoPeerCertificate, err)
+		return
+	}
+	s.roots = nil
+	if err := s.verify("mail.test", chain); err == nil {
+		t.Error("Expecting an unknown authority to fail the verification")
+		return
+	}
+}
+
+func TestTLSClientHandshakeUnverified(t *testing.T) {
+	server := testIssueTLSCertificate(
+		t, "mail.test", nil, x509.ExtKeyUsageServerAuth)
+	address := testTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{server.pair(t)},
+		NextProtos:   []string{"smtp", "h2"},
+	})
+	s := tlsSettings{serverName: "mail.test", protocols: []string{"h2"}}
+	cfg := s.config(address)
+	cfg.InsecureSkipVerify = true
+	conn, err := testTLSDial(t, address, cfg)
+	if err != nil {
+		t.Error("Failed to handshake:", err)
+		return
+	}
+	defer conn.Close()
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != "h2" {
+		t.Errorf("Expecting protocol \"h2\", got %q instead",
+			state.NegotiatedProtocol)
+		return
+	}
+	if s.verify(cfg.ServerName, state.PeerCertificates) == nil {
+		t.Error("Expecting the self signed certificate to be reported")
+		return
+	}
+}
+
+func TestMarshalTLSPeerCertificate(t *testing.T) {
+	issued := testIssueTLSCertificate(
+		t, "mail.test", nil, x509.ExtKeyUsageServerAuth)
+	cert := *issued.cert
+	cert.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	b := make([]byte, 4096)
+	n, err := marshalTLSPeerCertificate(3, &cert, b)
+	if err != nil {
+		t.Error("Failed to marshal:", err)
+		return
+	}
+	r := bytes.NewBuffer(b[1:n])
+	if b[0] != 3 {
+		t.Errorf("Expecting index 3, got %d instead", b[0])
+		return
+	}
+	sBuf := make([]byte, 1024)
+	for _, expected := range []string{
+		cert.Subject.String(), cert.Issuer.String(),
+	} {
+		s, _, err := ParseString(r.Read, sBuf)
+		if err != nil {
+			t.Error("Failed to parse:", err)
+			return
+		}
+		if string(s.Data()) != expected {
+			t.Errorf("Expecting %q, got %q instead", expected, s.Data())
+			return
+		}
+	}
+	times := r.Next(16)
+	if int64(binary.BigEndian.Uint64(times)) != cert.NotBefore.Unix() ||
+		int64(binary.BigEndian.Uint64(times[8:])) != cert.NotAfter.Unix() {
+		t.Errorf("Unexpected validity period %v", times)
+		return
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	if !bytes.Equal(r.Next(32), fingerprint[:]) {
+		t.Error("Unexpected fingerprint")
+		return
+	}
+	names, _, err := ParseStrings(r.Read, sBuf)
+	if err != nil {
+		t.Error("Failed to parse names:", err)
+		return
+	}
+	if len(names) != 2 || string(names[0].Data()) != "mail.test" ||
+		string(names[1].Data()) != "127.0.0.1" {
+		t.Errorf("Unexpected names %v", names)
+		return
+	}
+}
+
+func TestMarshalTLSPeerCertificateTruncateNames(t *testing.T) {
+	issued := testIssueTLSCertificate(
+		t, "mail.test", nil, x509.ExtKeyUsageServerAuth)
+	cert := *issued.cert
+	for i := range 1000 {
+		cert.DNSNames = append(cert.DNSNames, fmt.Sprintf("%d.test", i))
+	}
+	b := make([]byte, 1024)
+	n, err := marshalTLSPeerCertificate(0, &cert, b)
+	if err != nil {
+		t.Error("Failed to marshal:", err)
+		return
+	}
+	if n > len(b) {
+		t.Errorf("Expecting at most %d bytes, got %d", len(b), n)
+		return
+	}
+}
@@ -0,0 +1,345 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/configuration"
+)
+
+type testTLSCertificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM string
+	keyPEM  string
+}
+
+func (c testTLSCertificate) pair(t *testing.T) tls.Certificate {
+	pair, err := tls.X509KeyPair([]byte(c.certPEM), []byte(c.keyPEM))
+	if err != nil {
+		t.Fatal("Failed to load key pair:", err)
+	}
+	return pair
+}
+
+// testIssueTLSCertificate issues a certificate signed by `ca`, or a self
+// signed CA certificate if `ca` is nil
+func testIssueTLSCertificate(
+	t *testing.T,
+	name string,
+	ca *testTLSCertificate,
+	usage x509.ExtKeyUsage,
+) testTLSCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Failed to generate key:", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{name},
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	parent, signer := tpl, key
+	if ca == nil {
+		tpl.IsCA = true
+		tpl.BasicConstraintsValid = true
+		tpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, tpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal("Failed to create certificate:", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal("Failed to parse certificate:", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal("Failed to marshal key:", err)
+	}
+	return testTLSCertificate{
+		cert: cert,
+		key:  key,
+		certPEM: string(pem.EncodeToMemory(
+			&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM: string(pem.EncodeToMemory(
+			&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+// testTLSServer starts a TLS server that completes the handshake of one
+// connection and then closes it
+func testTLSServer(t *testing.T, cfg *tls.Config) string {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.(*tls.Conn).Handshake()
+	}()
+	return listener.Addr().String()
+}
+
+func testTLSDial(
+	t *testing.T,
+	address string,
+	cfg *tls.Config,
+) (*tls.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal("Failed to dial:", err)
+	}
+	tlsConn, err := tlsHandshake(ctx, conn, cfg)
+	if err != nil {
+		conn.Close()
+	}
+	return tlsConn, err
+}
+
+func TestParseTLSSettingsInvalid(t *testing.T) {
+	ca := testIssueTLSCertificate(t, "ca", nil, x509.ExtKeyUsageAny)
+	for _, c := range []struct {
+		meta    map[string]string
+		secrets map[string]string
+	}{
+		{map[string]string{tlsCABundleMeta: "not a certificate"}, nil},
+		{map[string]string{tlsClientCertificateMeta: ca.certPEM}, nil},
+		{nil, map[string]string{tlsClientPrivateKeyMeta: ca.keyPEM}},
+		{
+			map[string]string{tlsClientCertificateMeta: "bad"},
+			map[string]string{tlsClientPrivateKeyMeta: ca.keyPEM},
+		},
+	} {
+		if _, err := parseTLSSettings(c.meta, c.secrets); err == nil {
+			t.Errorf("Expecting %v to be rejected", c.meta)
+			return
+		}
+	}
+}
+
+func TestTLSHandshake(t *testing.T) {
+	ca := testIssueTLSCertificate(t, "ca", nil, x509.ExtKeyUsageAny)
+	server := testIssueTLSCertificate(
+		t, "telnet.test", &ca, x509.ExtKeyUsageServerAuth)
+	client := testIssueTLSCertificate(
+		t, "client", &ca, x509.ExtKeyUsageClientAuth)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	serverCfg := &tls.Config{
+		Certificates: []tls.Certificate{server.pair(t)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	s, err := parseTLSSettings(map[string]string{
+		tlsServerNameMeta:        "telnet.test",
+		tlsCABundleMeta:          ca.certPEM,
+		tlsClientCertificateMeta: client.certPEM,
+	}, map[string]string{
+		tlsClientPrivateKeyMeta: client.keyPEM,
+	})
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	address := testTLSServer(t, serverCfg)
+	conn, err := testTLSDial(t, address, s.config(address))
+	if err != nil {
+		t.Error("Failed to handshake:", err)
+		return
+	}
+	conn.Close()
+	// Without the CA bundle, the certificate of the server can't be verified
+	s.roots = nil
+	address = testTLSServer(t, serverCfg)
+	_, err = testTLSDial(t, address, s.config(address))
+	if err == nil || !strings.Contains(err.Error(), "unable to verify") {
+		t.Errorf("Expecting a verification error, got %v instead", err)
+		return
+	}
+}
+
+// testStartTLSServer starts a Telnet server that accepts one connection,
+// answers the STARTTLS request with `answer` and then completes the TLS
+// handshake if the request is accepted
+func testStartTLSServer(t *testing.T, cfg *tls.Config, answer byte) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 6)
+		_, err = io.ReadFull(conn, buf[:3])
+		if err != nil || !bytes.Equal(buf[:3], []byte{
+			telnetCmdIAC, telnetCmdWill, telnetOptStartTLS,
+		}) {
+			return
+		}
+		_, err = conn.Write([]byte{
+			'H', 'i', telnetCmdIAC, telnetCmdDo, telnetOptTerminalType,
+			telnetCmdIAC, answer, telnetOptStartTLS,
+		})
+		if err != nil || answer != telnetCmdDo {
+			return
+		}
+		// The refusal of TERMINAL-TYPE, then the FOLLOWS of the client
+		_, err = io.ReadFull(conn, buf[:3])
+		if err != nil {
+			return
+		}
+		_, err = io.ReadFull(conn, buf)
+		if err != nil || !bytes.Equal(buf, []byte{
+			telnetCmdIAC, telnetCmdSB, telnetOptStartTLS,
+			telnetOptStartTLSFollows, telnetCmdIAC, telnetCmdSE,
+		}) {
+			return
+		}
+		_, err = conn.Write(buf)
+		if err != nil {
+			return
+		}
+		tls.Server(conn, cfg).Handshake()
+	}()
+	return listener.Addr().String()
+}
+
+func TestTelnetStartTLS(t *testing.T) {
+	ca := testIssueTLSCertificate(t, "ca", nil, x509.ExtKeyUsageAny)
+	server := testIssueTLSCertificate(
+		t, "telnet.test", &ca, x509.ExtKeyUsageServerAuth)
+	serverCfg := &tls.Config{Certificates: []tls.Certificate{server.pair(t)}}
+	s, err := parseTLSSettings(map[string]string{
+		tlsServerNameMeta: "telnet.test",
+		tlsCABundleMeta:   ca.certPEM,
+	}, nil)
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, c := range []struct {
+		answer byte
+		err    error
+	}{
+		{telnetCmdDo, nil},
+		{telnetCmdDont, ErrTelnetStartTLSRefused},
+	} {
+		address := testStartTLSServer(t, serverCfg, c.answer)
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Error("Failed to dial:", err)
+			return
+		}
+		defer conn.Close()
+		err = telnetStartTLS(ctx, conn)
+		if err != c.err {
+			t.Errorf("Expecting error %v, got %v instead", c.err, err)
+			return
+		}
+		if err != nil {
+			continue
+		}
+		_, err = tlsHandshake(ctx, conn, s.config(address))
+		if err != nil {
+			t.Error("Failed to handshake:", err)
+			return
+		}
+	}
+}
+
+func TestParseTelnetConfigTLS(t *testing.T) {
+	ca := testIssueTLSCertificate(t, "ca", nil, x509.ExtKeyUsageAny)
+	p, err := parseTelnetConfig(configuration.Preset{
+		Host: "mainframe.test",
+		Meta: map[string]string{
+			tlsMeta:                  "true",
+			tlsClientCertificateMeta: ca.certPEM,
+			tlsClientPrivateKeyMeta:  ca.keyPEM,
+		},
+	})
+	if err != nil {
+		t.Error("Failed to parse:", err)
+		return
+	}
+	if p.Host != "mainframe.test:992" {
+		t.Errorf("Expecting the TLS port to be used, got %q instead", p.Host)
+		return
+	}
+	if _, ok := p.Meta[tlsClientPrivateKeyMeta]; ok {
+		t.Error("Expecting the private key to be moved to the Secrets")
+		return
+	}
+	p, err = parseTelnetConfig(configuration.Preset{Host: "mainframe.test"})
+	if err != nil || p.Host != "mainframe.test:23" {
+		t.Errorf("Expecting the Telnet port to be used, got %q (%v) instead",
+			p.Host, err)
+		return
+	}
+	p, err = parseTelnetConfig(configuration.Preset{
+		Host: "mainframe.test",
+		Meta: map[string]string{tlsMeta: "StartTLS"},
+	})
+	if err != nil || p.Host != "mainframe.test:23" {
+		t.Errorf("Expecting STARTTLS to use the Telnet port, got %q (%v) "+
+			"instead", p.Host, err)
+		return
+	}
+	_, err = parseTelnetConfig(configuration.Preset{
+		Host: "mainframe.test",
+		Meta: map[string]string{tlsMeta: "maybe"},
+	})
+	if err == nil {
+		t.Error("Expecting invalid TLS Meta to be rejected")
+		return
+	}
+}
@@ -0,0 +1,63 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"fmt"
+	"slices"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHAlgorithmSettings contains the algorithms that are allowed to be used
+// when connecting to the SSH remotes. Empty list means to use the defaults of
+// the SSH library
+type SSHAlgorithmSettings struct {
+	Ciphers           []string
+	KeyExchanges      []string
+	MACs              []string
+	HostKeyAlgorithms []string
+}
+
+// Verify returns an error if any of the algorithms is unknown
+func (a SSHAlgorithmSettings) Verify() error {
+	supported := ssh.SupportedAlgorithms()
+	insecure := ssh.InsecureAlgorithms()
+	for _, l := range []struct {
+		name  string
+		given []string
+		known [][]string
+	}{
+		{"cipher", a.Ciphers, [][]string{
+			supported.Ciphers, insecure.Ciphers}},
+		{"key exchange", a.KeyExchanges, [][]string{
+			supported.KeyExchanges, insecure.KeyExchanges}},
+		{"MAC", a.MACs, [][]string{
+			supported.MACs, insecure.MACs}},
+		{"host key algorithm", a.HostKeyAlgorithms, [][]string{
+			supported.HostKeys, insecure.HostKeys}},
+	} {
+		known := slices.Concat(l.known...)
+		for _, g := range l.given {
+			if !slices.Contains(known, g) {
+				return fmt.Errorf("unknown %s %q", l.name, g)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import "testing"
+
+func TestSSHAlgorithmSettingsVerify(t *testing.T) {
+	err := SSHAlgorithmSettings{
+		Ciphers:           []string{"aes128-cbc", "aes256-gcm@openssh.com"},
+		KeyExchanges:      []string{"diffie-hellman-group1-sha1"},
+		MACs:              []string{"hmac-sha2-256"},
+		HostKeyAlgorithms: []string{"ssh-ed25519"},
+	}.Verify()
+	if err != nil {
+		t.Error("Failed to verify:", err)
+		return
+	}
+	for _, a := range []SSHAlgorithmSettings{
+		{Ciphers: []string{"rot13"}},
+		{KeyExchanges: []string{"aes128-cbc"}},
+		{MACs: []string{"hmac-md4"}},
+		{HostKeyAlgorithms: []string{"ssh-dss2"}},
+	} {
+		if err := a.Verify(); err == nil {
+			t.Errorf("Expecting %v to be invalid", a)
+			return
+		}
+	}
+}
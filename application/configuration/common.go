@@ -20,6 +20,8 @@ package configuration
 import (
 	"time"
 
+	"golang.org/x/crypto/ssh"
+
 	"github.com/nirui/sshwifty/application/network"
 )
 
@@ -28,8 +30,16 @@ type Common struct {
 	HostName               string
 	SharedKey              string
 	Dialer                 network.Dial
+	PresetDialer           network.Dial
 	DialTimeout            time.Duration
 	Presets                []Preset
 	Hooks                  HookSettings
 	OnlyAllowPresetRemotes bool
+	KnownHosts             KnownHostsSettings
+	CertificateAuthority   ssh.Signer
+	WebUserHeader          string
+	SSHSubsystems          []string
+	SSHKeepalive           SSHKeepaliveSettings
+	SSHAlgorithms          SSHAlgorithmSettings
+	LocalShell             LocalShellSettings
 }
@@ -22,6 +22,8 @@ import (
 	"fmt"
 	"time"
 
+	"golang.org/x/crypto/ssh"
+
 	"github.com/nirui/sshwifty/application/network"
 )
 
@@ -38,6 +40,17 @@ type Configuration struct {
 	Servers                []Server
 	Presets                []Preset
 	OnlyAllowPresetRemotes bool
+	KnownHostsFile         string
+	KnownHostsAllowUnknown bool
+	KnownHostsPersist      bool
+	CertificateAuthority   ssh.Signer
+	WebUserHeader          string
+	SSHSubsystems          []string
+	SSHKeepaliveInterval   time.Duration
+	SSHKeepaliveMaxMissed  int
+	SSHAlgorithms          SSHAlgorithmSettings
+	LocalShellCommands     []string
+	LocalShellUser         string
 }
 
 // Verify verifies current setting
@@ -60,10 +73,7 @@ func (c Configuration) Verify() error {
 
 // Dialer builds a Dialer
 func (c Configuration) Dialer() network.Dial {
-	d := network.TCPDial()
-	if len(c.Socks5) > 0 {
-		d = network.BuildSocks5Dial(c.Socks5, c.Socks5User, c.Socks5Password, d)
-	}
+	d := c.PresetDialer()
 	if c.OnlyAllowPresetRemotes {
 		accessList := make(network.AllowedHosts, len(c.Presets))
 		for _, k := range c.Presets {
@@ -77,6 +87,17 @@ func (c Configuration) Dialer() network.Dial {
 	return d
 }
 
+// PresetDialer builds a Dial for connecting to the remotes that are defined by
+// the operator in the Presets (for example, jump hosts). Unlike Dialer, the
+// returned Dial is not restricted by OnlyAllowPresetRemotes
+func (c Configuration) PresetDialer() network.Dial {
+	d := network.TCPDial()
+	if len(c.Socks5) > 0 {
+		d = network.BuildSocks5Dial(c.Socks5, c.Socks5User, c.Socks5Password, d)
+	}
+	return d
+}
+
 // hookSettings returns Hooks settings
 func (c Configuration) hookSettings() HookSettings {
 	return HookSettings{
@@ -85,16 +106,49 @@ func (c Configuration) hookSettings() HookSettings {
 	}
 }
 
+// knownHostsSettings returns KnownHosts settings
+func (c Configuration) knownHostsSettings() KnownHostsSettings {
+	return KnownHostsSettings{
+		File:         c.KnownHostsFile,
+		AllowUnknown: c.KnownHostsAllowUnknown,
+		Persist:      c.KnownHostsPersist,
+	}
+}
+
+// sshKeepaliveSettings returns SSHKeepalive settings
+func (c Configuration) sshKeepaliveSettings() SSHKeepaliveSettings {
+	return SSHKeepaliveSettings{
+		Interval:  c.SSHKeepaliveInterval,
+		MaxMissed: c.SSHKeepaliveMaxMissed,
+	}
+}
+
+// localShellSettings returns LocalShell settings
+func (c Configuration) localShellSettings() LocalShellSettings {
+	return LocalShellSettings{
+		Commands: c.LocalShellCommands,
+		User:     c.LocalShellUser,
+	}
+}
+
 // Common returns common settings
 func (c Configuration) Common() Common {
 	return Common{
 		HostName:               c.HostName,
 		SharedKey:              c.SharedKey,
 		Dialer:                 c.Dialer(),
+		PresetDialer:           c.PresetDialer(),
 		DialTimeout:            c.DialTimeout,
 		Presets:                c.Presets,
 		Hooks:                  c.hookSettings(),
 		OnlyAllowPresetRemotes: c.OnlyAllowPresetRemotes,
+		KnownHosts:             c.knownHostsSettings(),
+		CertificateAuthority:   c.CertificateAuthority,
+		WebUserHeader:          c.WebUserHeader,
+		SSHSubsystems:          c.SSHSubsystems,
+		SSHKeepalive:           c.sshKeepaliveSettings(),
+		SSHAlgorithms:          c.SSHAlgorithms,
+		LocalShell:             c.localShellSettings(),
 	}
 }
 
@@ -0,0 +1,38 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import "fmt"
+
+// Credentials is a vault of named secrets. Presets can reference a group of
+// secrets in it through the name of the group, and the secrets will only be
+// used by the backend, never sent to the client
+type Credentials map[string]Meta
+
+// concretize returns concretized Credentials
+func (c Credentials) concretize() (map[string]map[string]string, error) {
+	cc := make(map[string]map[string]string, len(c))
+	for k, v := range c {
+		m, err := v.Concretize()
+		if err != nil {
+			return nil, fmt.Errorf("invalid Credential %q: %s", k, err)
+		}
+		cc[k] = m
+	}
+	return cc, nil
+}
@@ -21,6 +21,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
 // serverInput contains configuration input from the user
@@ -81,25 +83,41 @@ func (f serverInputs) concretize() ([]Server, error) {
 
 // presetInput contains user input for a preset
 type presetInput struct {
-	Title    string
-	Type     string
-	Host     string
-	TabColor string
-	Meta     Meta
+	Title      string
+	Type       string
+	Host       string
+	TabColor   string
+	Meta       Meta
+	Credential string
 }
 
 // concretize creates a preset based on current presetInput
-func (f presetInput) concretize() (Preset, error) {
+func (f presetInput) concretize(
+	credentials map[string]map[string]string,
+) (Preset, error) {
 	m, err := f.Meta.Concretize()
 	if err != nil {
 		return Preset{}, err
 	}
+	var secrets map[string]string
+	if len(f.Credential) > 0 {
+		c, ok := credentials[f.Credential]
+		if !ok {
+			return Preset{}, fmt.Errorf(
+				"Credential %q is undefined", f.Credential)
+		}
+		secrets = make(map[string]string, len(c))
+		for k, v := range c {
+			secrets[k] = v
+		}
+	}
 	return Preset{
 		Title:    f.Title,
 		Type:     strings.TrimSpace(f.Type),
 		Host:     f.Host,
 		TabColor: strings.TrimSpace(f.TabColor),
 		Meta:     m,
+		Secrets:  secrets,
 	}, nil
 }
 
@@ -108,10 +126,12 @@ type presetInputs []presetInput
 
 // concretize creates configuration for all Presets based on current
 // presetInputs
-func (f presetInputs) concretize() ([]Preset, error) {
+func (f presetInputs) concretize(
+	credentials map[string]map[string]string,
+) ([]Preset, error) {
 	ps := make([]Preset, 0, len(f))
 	for i, p := range f {
-		pp, err := p.concretize()
+		pp, err := p.concretize(credentials)
 		if err != nil {
 			return nil, fmt.Errorf(
 				"invalid Preset for %d (titled \"%s\"): %s",
@@ -154,8 +174,47 @@ type commonInput struct {
 	// Remotes
 	Presets presetInputs
 
+	// Secrets that can be referenced by the Presets
+	Credentials Credentials
+
 	// Allow predefined remotes only
 	OnlyAllowPresetRemotes bool
+
+	// Location of the SSH known hosts file, optional
+	KnownHostsFile string
+
+	// Ask user to confirm the fingerprint of hosts unknown to KnownHostsFile
+	KnownHostsAllowUnknown bool
+
+	// Save the user confirmed host keys into KnownHostsFile
+	KnownHostsPersist bool
+
+	// Private key of the built-in SSH certificate authority, optional
+	CertificateAuthorityKey String
+
+	// HTTP header that carries the name of the authenticated web user, optional
+	WebUserHeader string
+
+	// SSH subsystems that are allowed to be requested by the Presets
+	SSHSubsystems []string
+
+	// Interval (in seconds) of SSH keepalive requests, default 0 (disabled)
+	SSHKeepaliveInterval int
+
+	// Unanswered SSH keepalive requests before disconnecting, default 3
+	SSHKeepaliveMaxMissed int
+
+	// Allowed SSH algorithms, optional
+	SSHCiphers           []string
+	SSHKeyExchanges      []string
+	SSHMACs              []string
+	SSHHostKeyAlgorithms []string
+
+	// Command lines that are allowed to be launched as local shells, optional
+	LocalShellCommands []string
+
+	// User that the local shells run as, optional
+	LocalShellUser string
 }
 
 // concretize creates Configuration based on current commonInput
@@ -167,7 +226,34 @@ func (f commonInput) concretize() (Configuration, error) {
 	if err != nil {
 		return Configuration{}, err
 	}
-	presets, err := f.Presets.concretize()
+	credentials, err := f.Credentials.concretize()
+	if err != nil {
+		return Configuration{}, err
+	}
+	var ca ssh.Signer
+	if len(f.CertificateAuthorityKey) > 0 {
+		ca, err = parseCertificateAuthorityKey(f.CertificateAuthorityKey)
+		if err != nil {
+			return Configuration{}, err
+		}
+	}
+	algorithms := SSHAlgorithmSettings{
+		Ciphers:           trimNames(f.SSHCiphers),
+		KeyExchanges:      trimNames(f.SSHKeyExchanges),
+		MACs:              trimNames(f.SSHMACs),
+		HostKeyAlgorithms: trimNames(f.SSHHostKeyAlgorithms),
+	}
+	if err := algorithms.Verify(); err != nil {
+		return Configuration{}, fmt.Errorf("invalid SSH algorithms: %s", err)
+	}
+	localShell := LocalShellSettings{
+		Commands: trimNames(f.LocalShellCommands),
+		User:     strings.TrimSpace(f.LocalShellUser),
+	}
+	if err := localShell.Verify(); err != nil {
+		return Configuration{}, fmt.Errorf("invalid local shell: %s", err)
+	}
+	presets, err := f.Presets.concretize(credentials)
 	if err != nil {
 		return Configuration{}, err
 	}
@@ -189,5 +275,49 @@ func (f commonInput) concretize() (Configuration, error) {
 		Servers:                servers,
 		Presets:                presets,
 		OnlyAllowPresetRemotes: f.OnlyAllowPresetRemotes,
+		KnownHostsFile:         f.KnownHostsFile,
+		KnownHostsAllowUnknown: f.KnownHostsAllowUnknown,
+		KnownHostsPersist:      f.KnownHostsPersist,
+		CertificateAuthority:   ca,
+		WebUserHeader:          strings.TrimSpace(f.WebUserHeader),
+		SSHSubsystems:          trimNames(f.SSHSubsystems),
+		SSHKeepaliveInterval: time.Duration(
+			max(f.SSHKeepaliveInterval, 0),
+		) * time.Second,
+		SSHKeepaliveMaxMissed: setZeroUintToDefault(
+			f.SSHKeepaliveMaxMissed,
+			3,
+		),
+		SSHAlgorithms:      algorithms,
+		LocalShellCommands: localShell.Commands,
+		LocalShellUser:     localShell.User,
 	}, nil
 }
+
+// trimNames returns the `names` with surrounding spaces removed, empty ones
+// are dropped
+func trimNames(names []string) []string {
+	result := make([]string, 0, len(names))
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		if len(n) <= 0 {
+			continue
+		}
+		result = append(result, n)
+	}
+	return result
+}
+
+// parseCertificateAuthorityKey parses the private key of the built-in SSH
+// certificate authority
+func parseCertificateAuthorityKey(k String) (ssh.Signer, error) {
+	key, err := k.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load CertificateAuthorityKey: %s", err)
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CertificateAuthorityKey: %s", err)
+	}
+	return signer, nil
+}
@@ -0,0 +1,36 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import "time"
+
+// SSHKeepaliveSettings contains the default settings of the keepalive requests
+// that are sent to the SSH remotes
+type SSHKeepaliveSettings struct {
+	// Interval between two keepalive requests, 0 to disable keepalive
+	Interval time.Duration
+
+	// How many keepalive requests can be left unanswered before the remote is
+	// considered as dead
+	MaxMissed int
+}
+
+// Enabled returns whether or not keepalive is enabled
+func (k SSHKeepaliveSettings) Enabled() bool {
+	return k.Interval > 0 && k.MaxMissed > 0
+}
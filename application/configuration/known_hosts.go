@@ -0,0 +1,40 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+// KnownHostsSettings contains settings of the server-side SSH known hosts
+// database
+type KnownHostsSettings struct {
+	// Location of the database file in the OpenSSH `known_hosts` format,
+	// empty to disable the database
+	File string
+
+	// Whether or not to ask the user to confirm the fingerprint of a host which
+	// is not in the database. Hosts unknown to the database will be refused if
+	// this is disabled
+	AllowUnknown bool
+
+	// Whether or not to save the host keys that are confirmed by the user into
+	// the database
+	Persist bool
+}
+
+// Enabled returns whether or not the known hosts database is enabled
+func (k KnownHostsSettings) Enabled() bool {
+	return len(k.File) > 0
+}
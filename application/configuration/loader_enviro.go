@@ -115,6 +115,17 @@ func Environ() Loader {
 			}
 		}
 
+		// Credentials
+		var credentials Credentials
+		credentialStr := strings.TrimSpace(GetEnv("SSHWIFTY_CREDENTIALS"))
+		if len(credentialStr) > 0 {
+			e := json.Unmarshal([]byte(credentialStr), &credentials)
+			if e != nil {
+				return environTypeName, Configuration{}, fmt.Errorf(
+					"invalid \"SSHWIFTY_CREDENTIALS\": %s", e)
+			}
+		}
+
 		cfg, err := commonInput{
 			HostName:  GetEnv("SSHWIFTY_HOSTNAME"),
 			SharedKey: GetEnv("SSHWIFTY_SHAREDKEY"),
@@ -128,11 +139,39 @@ func Environ() Loader {
 			HookTimeout: castUintToInt(
 				parseEnvUintDefault("SSHWIFTY_HOOKTIMEOUT", 0, 32),
 			),
-			Servers: serverInputs{cfgSer},
-			Presets: presets,
+			Servers:     serverInputs{cfgSer},
+			Presets:     presets,
+			Credentials: credentials,
 			OnlyAllowPresetRemotes: len(
 				GetEnv("SSHWIFTY_ONLYALLOWPRESETREMOTES"),
 			) > 0,
+			KnownHostsFile: GetEnv("SSHWIFTY_KNOWNHOSTSFILE"),
+			KnownHostsAllowUnknown: len(
+				GetEnv("SSHWIFTY_KNOWNHOSTSALLOWUNKNOWN"),
+			) > 0,
+			KnownHostsPersist: len(
+				GetEnv("SSHWIFTY_KNOWNHOSTSPERSIST"),
+			) > 0,
+			CertificateAuthorityKey: String(
+				GetEnv("SSHWIFTY_CERTIFICATEAUTHORITYKEY"),
+			),
+			WebUserHeader: GetEnv("SSHWIFTY_WEBUSERHEADER"),
+			SSHSubsystems: strings.Split(GetEnv("SSHWIFTY_SSHSUBSYSTEMS"), ","),
+			SSHKeepaliveInterval: castUintToInt(
+				parseEnvUintDefault("SSHWIFTY_SSHKEEPALIVEINTERVAL", 0, 32),
+			),
+			SSHKeepaliveMaxMissed: castUintToInt(
+				parseEnvUintDefault("SSHWIFTY_SSHKEEPALIVEMAXMISSED", 0, 32),
+			),
+			SSHCiphers: strings.Split(GetEnv("SSHWIFTY_SSHCIPHERS"), ","),
+			SSHKeyExchanges: strings.Split(
+				GetEnv("SSHWIFTY_SSHKEYEXCHANGES"), ","),
+			SSHMACs: strings.Split(GetEnv("SSHWIFTY_SSHMACS"), ","),
+			SSHHostKeyAlgorithms: strings.Split(
+				GetEnv("SSHWIFTY_SSHHOSTKEYALGORITHMS"), ","),
+			LocalShellCommands: strings.Split(
+				GetEnv("SSHWIFTY_LOCALSHELLCOMMANDS"), ","),
+			LocalShellUser: GetEnv("SSHWIFTY_LOCALSHELLUSER"),
 		}.concretize()
 		return environTypeName, cfg, err
 	}
@@ -0,0 +1,72 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// LocalShellSettings contains settings of the shells that are launched on
+// the host which runs Sshwifty. Local shell is disabled when no command is
+// allowed
+type LocalShellSettings struct {
+	// Command lines that are allowed to be launched. The first field of each
+	// command line is the absolute path to the program, the rest fields are
+	// the arguments
+	Commands []string
+
+	// Name of the user that the commands run as, empty to run as the same
+	// user as Sshwifty
+	User string
+}
+
+// Enabled returns whether or not local shell is enabled
+func (l LocalShellSettings) Enabled() bool {
+	return len(l.Commands) > 0
+}
+
+// Allowed returns whether or not `command` is allowed to be launched
+func (l LocalShellSettings) Allowed(command string) bool {
+	return slices.Contains(l.Commands, command)
+}
+
+// Verify returns an error if any of the settings is invalid
+func (l LocalShellSettings) Verify() error {
+	for _, c := range l.Commands {
+		fields := strings.Fields(c)
+		if len(fields) <= 0 {
+			return errors.New("command must not be empty")
+		}
+		if !filepath.IsAbs(fields[0]) {
+			return fmt.Errorf("program of command %q must be specified "+
+				"with an absolute path", c)
+		}
+	}
+	if len(l.User) <= 0 {
+		return nil
+	}
+	if _, err := user.Lookup(l.User); err != nil {
+		return fmt.Errorf("invalid user %q: %s", l.User, err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import "testing"
+
+func TestLocalShellSettingsVerify(t *testing.T) {
+	if err := (LocalShellSettings{
+		Commands: []string{"/bin/bash", "/usr/bin/tmux new -A -s ops"},
+	}).Verify(); err != nil {
+		t.Error("Failed to verify:", err)
+		return
+	}
+	for _, l := range []LocalShellSettings{
+		{Commands: []string{"bash"}},
+		{Commands: []string{" "}},
+		{Commands: []string{"/bin/bash"}, User: "no-such-user-for-test"},
+	} {
+		if err := l.Verify(); err == nil {
+			t.Errorf("Expecting %v to be rejected", l)
+			return
+		}
+	}
+}
+
+func TestLocalShellSettingsAllowed(t *testing.T) {
+	l := LocalShellSettings{Commands: []string{"/usr/bin/tmux new -s ops"}}
+	if !l.Enabled() || !l.Allowed("/usr/bin/tmux new -s ops") {
+		t.Error("Expecting the command to be allowed")
+		return
+	}
+	if l.Allowed("/usr/bin/tmux") || (LocalShellSettings{}).Enabled() {
+		t.Error("Expecting only the exact command to be allowed")
+		return
+	}
+}
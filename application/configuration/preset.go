@@ -24,4 +24,31 @@ type Preset struct {
 	Host     string
 	TabColor string
 	Meta     map[string]string
+
+	// Secrets contains data that will only be used by the backend, and must
+	// never be sent to the client
+	Secrets map[string]string
+}
+
+// Secret returns the secret of given `name`
+func (p Preset) Secret(name string) (string, bool) {
+	s, ok := p.Secrets[name]
+	return s, ok
+}
+
+// MoveToSecrets moves the Meta items of given `names` into Secrets, so they
+// will no longer be sent to the client. Existing Secrets will be overwritten
+func (p Preset) MoveToSecrets(names ...string) Preset {
+	for _, name := range names {
+		v, ok := p.Meta[name]
+		if !ok {
+			continue
+		}
+		if p.Secrets == nil {
+			p.Secrets = make(map[string]string, len(names))
+		}
+		p.Secrets[name] = v
+		delete(p.Meta, name)
+	}
+	return p
 }
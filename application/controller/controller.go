@@ -158,7 +158,10 @@ func Builder(cmds command.Commands) server.HandlerBuilder {
 		logger log.Logger,
 	) http.Handler {
 		hooks := command.NewHooks(commonCfg.Hooks)
-		socketCtl := newSocketCtl(commonCfg, cfg, cmds, hooks, &socketBuffers)
+		knownHosts := command.NewKnownHosts(commonCfg.KnownHosts)
+		ca := command.NewCertificateAuthority(commonCfg.CertificateAuthority)
+		socketCtl := newSocketCtl(
+			commonCfg, cfg, cmds, hooks, knownHosts, ca, &socketBuffers)
 		return handler{
 			hostNameChecker: commonCfg.HostName + ":",
 			commonCfg:       commonCfg,
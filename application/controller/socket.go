@@ -68,6 +68,8 @@ type socket struct {
 	upgrader         websocket.Upgrader
 	commander        command.Commander
 	hks              command.Hooks
+	knownHosts       *command.KnownHosts
+	ca               *command.CertificateAuthority
 	socketBufferPool *command.BufferPool
 }
 
@@ -84,6 +86,8 @@ func newSocketCtl(
 	cfg configuration.Server,
 	cmds command.Commands,
 	hooks command.Hooks,
+	knownHosts *command.KnownHosts,
+	ca *command.CertificateAuthority,
 	socketBufferPool *command.BufferPool,
 ) socket {
 	return socket{
@@ -92,6 +96,8 @@ func newSocketCtl(
 		upgrader:         buildWebsocketUpgrader(cfg),
 		commander:        command.New(cmds),
 		hks:              hooks,
+		knownHosts:       knownHosts,
+		ca:               ca,
 		socketBufferPool: socketBufferPool,
 	}
 }
@@ -222,6 +228,15 @@ func (s socket) buildCipherKey(r *http.Request) [16]byte {
 	return key
 }
 
+// webUser returns the name of the web user, which was authenticated by a
+// trusted reverse proxy and passed through the WebUserHeader
+func (s socket) webUser(r *http.Request) string {
+	if len(s.commonCfg.WebUserHeader) <= 0 {
+		return ""
+	}
+	return r.Header.Get(s.commonCfg.WebUserHeader)
+}
+
 func (s socket) Get(
 	w *ResponseWriter, r *http.Request, l log.Logger) error {
 	// Error will not be returned when Websocket already handled
@@ -284,8 +299,21 @@ func (s socket) Get(
 	senderLock := sync.Mutex{}
 	cmdExec, cmdExecErr := s.commander.New(
 		command.Configuration{
-			Dial:        s.commonCfg.Dialer,
-			DialTimeout: s.commonCfg.DecideDialTimeout(s.serverCfg.ReadTimeout),
+			Dial:       s.commonCfg.Dialer,
+			PresetDial: s.commonCfg.PresetDialer,
+			DialTimeout: s.commonCfg.DecideDialTimeout(
+				s.serverCfg.ReadTimeout,
+			),
+			Presets:                s.commonCfg.Presets,
+			OnlyAllowPresetRemotes: s.commonCfg.OnlyAllowPresetRemotes,
+			KnownHosts:             s.knownHosts,
+			CertificateAuthority:   s.ca,
+			WebUser:                s.webUser(r),
+			SSHSubsystems:          s.commonCfg.SSHSubsystems,
+			SSHKeepalive:           s.commonCfg.SSHKeepalive,
+			SSHAlgorithms:          s.commonCfg.SSHAlgorithms,
+			SSHClients:             command.NewSSHClientPool(),
+			LocalShell:             s.commonCfg.LocalShell,
 		},
 		rw.NewFetchReader(func() ([]byte, error) {
 			defer s.increaseNonce(readNonce[:])
@@ -21,8 +21,10 @@ go 1.27.0
 
 require (
 	github.com/gorilla/websocket v1.5.3
+	github.com/pkg/sftp v1.13.11
 	golang.org/x/crypto v0.55.0
 	golang.org/x/net v0.58.0
+	golang.org/x/sys v0.47.0
 )
 
-require golang.org/x/sys v0.47.0 // indirect
+require github.com/kr/fs v0.1.0 // indirect
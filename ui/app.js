@@ -21,10 +21,19 @@ import Auth from "./auth.vue";
 import { Colors as ControlColors } from "./commands/color.js";
 import { Commands } from "./commands/commands.js";
 import { Controls } from "./commands/controls.js";
+import * as localshell from "./commands/local_shell.js";
 import { Presets } from "./commands/presets.js";
+import * as serial from "./commands/serial.js";
+import * as sftp from "./commands/sftp.js";
 import * as ssh from "./commands/ssh.js";
+import * as sshtunnel from "./commands/ssh_tunnel.js";
+import * as tcp from "./commands/tcp.js";
 import * as telnet from "./commands/telnet.js";
+import * as tls from "./commands/tls.js";
 import "./common.css";
+import * as rawctl from "./control/raw.js";
+import * as serialctl from "./control/serial.js";
+import * as sftpctl from "./control/sftp.js";
 import * as sshctl from "./control/ssh.js";
 import * as telnetctl from "./control/telnet.js";
 import * as cipher from "./crypto.js";
@@ -122,8 +131,24 @@ function startApp(rootEl) {
         controls: new Controls([
           new telnetctl.Telnet(uiControlColors),
           new sshctl.SSH(uiControlColors),
+          new sftpctl.SFTP(uiControlColors),
+          new rawctl.Raw("SSH Tunnel", uiControlColors),
+          new rawctl.Raw("TCP", uiControlColors),
+          new rawctl.Raw("TLS", uiControlColors),
+          new sshctl.LocalShell(uiControlColors),
+          new serialctl.Serial(uiControlColors),
+        ]),
+        // Indexed by the command ID, keep the order of the backend
+        commands: new Commands([
+          new telnet.Command(),
+          new ssh.Command(),
+          new sftp.Command(),
+          new sshtunnel.Command(),
+          new tcp.Command(),
+          new tls.Command(),
+          new localshell.Command(),
+          new serial.Command(),
         ]),
-        commands: new Commands([new telnet.Command(), new ssh.Command()]),
         tabUpdateIndicator: null,
         viewPort: {
           dim: {
@@ -49,6 +49,18 @@ export class Events {
     }
   }
 
+  /**
+   * Tells whether or not there is a pending placeholder of given event type
+   *
+   * @param {string} type Event Type
+   *
+   * @returns {boolean} true when the callback can be placed, false otherwise
+   *
+   */
+  placeable(type) {
+    return this.placeHolders[type] === null;
+  }
+
   /**
    * Place callbacks to pending placeholder events
    *
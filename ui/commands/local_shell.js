@@ -0,0 +1,670 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import * as header from "../stream/header.js";
+import * as reader from "../stream/reader.js";
+import * as stream from "../stream/stream.js";
+import * as command from "./commands.js";
+import * as common from "./common.js";
+import * as event from "./events.js";
+import Exception from "./exception.js";
+import * as history from "./history.js";
+import * as presets from "./presets.js";
+import * as ssh from "./ssh.js";
+import * as strings from "./string.js";
+
+const COMMAND_ID = 0x06;
+
+const MAX_COMMAND_LEN = 1024;
+
+const SERVER_INITIAL_ERROR_BAD_COMMAND = 0x01;
+const SERVER_INITIAL_ERROR_COMMAND_NOT_ALLOWED = 0x02;
+const SERVER_INITIAL_ERROR_BAD_TERMINAL_SIZE = 0x03;
+
+const SERVER_OUTPUT = 0x00;
+const SERVER_HOOK_OUTPUT_BEFORE_STARTING = 0x01;
+const SERVER_START_FAILED = 0x02;
+const SERVER_STARTED = 0x03;
+const SERVER_EXIT_STATUS = 0x04;
+
+const CLIENT_DATA_INPUT = 0x00;
+const CLIENT_DATA_RESIZE = 0x01;
+
+const CommandMaxSearchResults = 3;
+
+class LocalShell {
+  /**
+   * constructor
+   *
+   * @param {stream.Sender} sd Stream sender
+   * @param {object} config configuration
+   * @param {object} callbacks Event callbacks
+   *
+   */
+  constructor(sd, config, callbacks) {
+    this.sender = sd;
+    this.config = config;
+    this.started = false;
+    this.events = new event.Events(
+      [
+        "initialization.failed",
+        "initialized",
+        "hook.before_started",
+        "start.failed",
+        "start.succeed",
+        "close",
+        "@stdout",
+        "@message",
+        "@completed",
+      ],
+      callbacks,
+    );
+  }
+
+  /**
+   * Send intial request
+   *
+   * @param {stream.InitialSender} initialSender Initial stream request sender
+   *
+   */
+  run(initialSender) {
+    let commandLine = new strings.String(this.config.command).buffer(),
+      termSize = new DataView(new ArrayBuffer(4)),
+      initialSize = ssh.estimateTermSize();
+    termSize.setUint16(0, initialSize.rows);
+    termSize.setUint16(2, initialSize.cols);
+    let data = new Uint8Array(commandLine.length + 4);
+    data.set(commandLine, 0);
+    data.set(new Uint8Array(termSize.buffer), commandLine.length);
+    initialSender.send(data);
+  }
+
+  /**
+   * Receive the initial stream request
+   *
+   * @param {header.InitialStream} streamInitialHeader Server respond on the
+   *                                                   initial stream request
+   *
+   */
+  initialize(streamInitialHeader) {
+    if (!streamInitialHeader.success()) {
+      this.events.fire("initialization.failed", streamInitialHeader);
+      return;
+    }
+    this.events.fire("initialized", streamInitialHeader);
+  }
+
+  /**
+   * Tick the command
+   *
+   * @param {header.Stream} streamHeader Stream data header
+   * @param {reader.Limited} rd Data reader
+   *
+   * @returns {any} The result of the ticking
+   *
+   * @throws {Exception} When the stream header type is unknown
+   *
+   */
+  async tick(streamHeader, rd) {
+    switch (streamHeader.marker()) {
+      case SERVER_STARTED:
+        if (!this.started) {
+          this.started = true;
+          return this.events.fire("start.succeed", rd, this);
+        }
+        break;
+      case SERVER_START_FAILED:
+        if (!this.started) {
+          return this.events.fire("start.failed", rd);
+        }
+        break;
+      case SERVER_HOOK_OUTPUT_BEFORE_STARTING:
+        if (!this.started) {
+          return this.events.fire("hook.before_started", rd);
+        }
+        break;
+      case SERVER_OUTPUT:
+        if (this.started) {
+          return this.events.fire("stdout", rd);
+        }
+        break;
+      case SERVER_EXIT_STATUS:
+        if (this.started) {
+          const d = await reader.readN(rd, 4),
+            code = new DataView(d.buffer, d.byteOffset).getInt32(0),
+            status = strings.toString(
+              await reader.readCompletely(rd),
+              "utf-8",
+            );
+          return this.events.fire(
+            "message",
+            code < 0
+              ? "Process has been terminated: " + status
+              : "Process has exited with code " + code,
+          );
+        }
+        break;
+    }
+
+    throw new Exception("Unknown stream header marker");
+  }
+
+  /**
+   * Send close signal to remote
+   *
+   */
+  sendClose() {
+    return this.sender.close();
+  }
+
+  /**
+   * Send data to remote
+   *
+   * @param {Uint8Array} data
+   *
+   */
+  sendData(data) {
+    return this.sender.sendData(CLIENT_DATA_INPUT, data);
+  }
+
+  /**
+   * Send resize request
+   *
+   * @param {number} rows
+   * @param {number} cols
+   *
+   */
+  sendResize(rows, cols) {
+    let data = new DataView(new ArrayBuffer(4));
+    data.setUint16(0, rows);
+    data.setUint16(2, cols);
+    return this.sender.send(CLIENT_DATA_RESIZE, new Uint8Array(data.buffer));
+  }
+
+  /**
+   * Close the command
+   *
+   */
+  close() {
+    this.sendClose();
+    return this.events.fire("close");
+  }
+
+  /**
+   * Tear down the command completely
+   *
+   */
+  completed() {
+    return this.events.fire("completed");
+  }
+}
+
+const initialFieldDef = {
+  Command: {
+    name: "Command",
+    description:
+      "The command line to start on the host which runs Sshwifty, it must " +
+      "be one of the command lines allowed by the backend",
+    type: "text",
+    value: "",
+    example: "/bin/bash --login",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      if (d.trim().length <= 0) {
+        throw new Error("Command must be specified");
+      }
+      if (d.length > MAX_COMMAND_LEN) {
+        throw new Error(
+          "It's too long, make it shorter than " + MAX_COMMAND_LEN + " bytes",
+        );
+      }
+      return "";
+    },
+  },
+  Encoding: {
+    name: "Encoding",
+    description: "The character encoding of the command",
+    type: "select",
+    value: "utf-8",
+    example: common.charsetPresets.join(","),
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      for (let i in common.charsetPresets) {
+        if (common.charsetPresets[i] !== d) {
+          continue;
+        }
+        return "";
+      }
+      throw new Error('The character encoding "' + d + '" is not supported');
+    },
+  },
+};
+
+class Wizard {
+  /**
+   * constructor
+   *
+   * @param {command.Info} info
+   * @param {presets.Preset} preset
+   * @param {object} session
+   * @param {Array<string>} keptSessions
+   * @param {streams.Streams} streams
+   * @param {subscribe.Subscribe} subs
+   * @param {controls.Controls} controls
+   * @param {history.History} history
+   *
+   */
+  constructor(
+    info,
+    preset,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    this.info = info;
+    this.preset = preset;
+    this.hasStarted = false;
+    this.streams = streams;
+    this.session = session;
+    this.keptSessions = keptSessions;
+    this.step = subs;
+    this.controls = controls.get("Local Shell");
+    this.history = history;
+  }
+
+  run() {
+    this.step.resolve(this.stepInitialPrompt());
+  }
+
+  started() {
+    return this.hasStarted;
+  }
+
+  control() {
+    return this.controls;
+  }
+
+  close() {
+    this.step.resolve(
+      this.stepErrorDone(
+        "Action cancelled",
+        "Action has been cancelled without reach any success",
+      ),
+    );
+  }
+
+  stepErrorDone(title, message) {
+    return command.done(false, null, title, message);
+  }
+
+  stepHookOutputPrompt(title, msg) {
+    return command.wait(
+      title,
+      strings.truncate(
+        msg,
+        common.MAX_HOOK_OUTPUT_LEN,
+        common.HOOK_OUTPUT_STR_ELLIPSIS,
+      ),
+    );
+  }
+
+  stepSuccessfulDone(data) {
+    return command.done(true, data, "Success!", "The command has started");
+  }
+
+  stepWaitForAcceptWait() {
+    return command.wait(
+      "Requesting",
+      "Waiting for the request to be accepted by the backend",
+    );
+  }
+
+  stepWaitForStartWait(commandLine) {
+    return command.wait(
+      "Starting " + commandLine,
+      "Starting the command on the backend host",
+    );
+  }
+
+  /**
+   *
+   * @param {stream.Sender} sender
+   * @param {object} configInput
+   * @param {object} sessionData
+   *
+   */
+  buildCommand(sender, configInput, sessionData) {
+    let self = this;
+    let parsedConfig = {
+      command: strings.fromString(configInput.command),
+      charset: configInput.charset,
+    };
+    // Copy the keptSessions from the record so it will not be overwritten here
+    let keptSessions = self.keptSessions ? [].concat(...self.keptSessions) : [];
+    return new LocalShell(sender, parsedConfig, {
+      "initialization.failed"(streamInitialHeader) {
+        switch (streamInitialHeader.data()) {
+          case SERVER_INITIAL_ERROR_BAD_COMMAND:
+            self.step.resolve(
+              self.stepErrorDone("Request rejected", "Invalid command"),
+            );
+            return;
+          case SERVER_INITIAL_ERROR_COMMAND_NOT_ALLOWED:
+            self.step.resolve(
+              self.stepErrorDone(
+                "Request rejected",
+                "The command is not allowed",
+              ),
+            );
+            return;
+          case SERVER_INITIAL_ERROR_BAD_TERMINAL_SIZE:
+            self.step.resolve(
+              self.stepErrorDone("Request rejected", "Invalid terminal size"),
+            );
+            return;
+        }
+        self.step.resolve(
+          self.stepErrorDone(
+            "Request rejected",
+            "Unknown error code: " + streamInitialHeader.data(),
+          ),
+        );
+      },
+      initialized(streamInitialHeader) {
+        self.step.resolve(self.stepWaitForStartWait(configInput.command));
+      },
+      async "hook.before_started"(rd) {
+        const d = strings.toString(await reader.readCompletely(rd), "utf-8");
+        self.step.resolve(
+          self.stepHookOutputPrompt("Waiting for server hook", d),
+        );
+      },
+      "start.succeed"(rd, commandHandler) {
+        self.step.resolve(
+          self.stepSuccessfulDone(
+            new command.Result(
+              configInput.command,
+              self.info,
+              self.controls.build({
+                charset: parsedConfig.charset,
+                tabColor: configInput.tabColor,
+                send(data) {
+                  return commandHandler.sendData(data);
+                },
+                close() {
+                  return commandHandler.sendClose();
+                },
+                resize(rows, cols) {
+                  return commandHandler.sendResize(rows, cols);
+                },
+                events: commandHandler.events,
+              }),
+              self.controls.ui(),
+            ),
+          ),
+        );
+        self.history.save(
+          self.info.name() + ":" + configInput.command,
+          configInput.command,
+          new Date(),
+          self.info,
+          configInput,
+          sessionData,
+          keptSessions,
+        );
+      },
+      async "start.failed"(rd) {
+        const read = await reader.readCompletely(rd),
+          message = strings.toString(read.buffer, "utf-8");
+        self.step.resolve(self.stepErrorDone("Start failed", message));
+      },
+      "@stdout"(rd) {},
+      "@message"(msg) {},
+      close() {},
+      "@completed"() {},
+    });
+  }
+
+  stepInitialPrompt() {
+    const self = this;
+    return command.prompt(
+      "Local Shell",
+      "Command on the backend host",
+      "Start",
+      (r) => {
+        self.hasStarted = true;
+        self.streams.request(COMMAND_ID, (sd) => {
+          return self.buildCommand(
+            sd,
+            {
+              command: r.command.trim(),
+              charset: r.encoding,
+              tabColor: self.preset ? self.preset.tabColor() : "",
+            },
+            self.session,
+          );
+        });
+        self.step.resolve(self.stepWaitForAcceptWait());
+      },
+      () => {},
+      command.fieldsWithPreset(
+        initialFieldDef,
+        [
+          {
+            name: "Command",
+            suggestions(input) {
+              const commands = self.history.search(
+                "Local Shell",
+                "command",
+                input,
+                CommandMaxSearchResults,
+              );
+
+              let sugg = [];
+
+              for (let i = 0; i < commands.length; i++) {
+                sugg.push({
+                  title: commands[i].title,
+                  value: commands[i].data.command,
+                  meta: {
+                    Encoding: commands[i].data.charset,
+                  },
+                });
+              }
+
+              return sugg;
+            },
+          },
+          { name: "Encoding" },
+        ],
+        self.preset,
+        (r) => {},
+      ),
+    );
+  }
+}
+
+class Executor extends Wizard {
+  /**
+   * constructor
+   *
+   * @param {command.Info} info
+   * @param {object} config
+   * @param {object} session
+   * @param {Array<string>} keptSessions
+   * @param {streams.Streams} streams
+   * @param {subscribe.Subscribe} subs
+   * @param {controls.Controls} controls
+   * @param {history.History} history
+   *
+   */
+  constructor(
+    info,
+    config,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    super(
+      info,
+      presets.emptyPreset(),
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+    this.config = config;
+  }
+
+  stepInitialPrompt() {
+    const self = this;
+    self.hasStarted = true;
+    self.streams.request(COMMAND_ID, (sd) => {
+      return self.buildCommand(
+        sd,
+        {
+          command: self.config.command,
+          charset: self.config.charset ? self.config.charset : "utf-8",
+          tabColor: self.config.tabColor ? self.config.tabColor : "",
+        },
+        self.session,
+      );
+    });
+    return self.stepWaitForAcceptWait();
+  }
+}
+
+export class Command {
+  constructor() {}
+
+  id() {
+    return COMMAND_ID;
+  }
+
+  name() {
+    return "Local Shell";
+  }
+
+  description() {
+    return "Command on the backend host";
+  }
+
+  color() {
+    return "#9a6";
+  }
+
+  wizard(
+    info,
+    preset,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    return new Wizard(
+      info,
+      preset,
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  execute(
+    info,
+    config,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    return new Executor(
+      info,
+      config,
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  launch(info, launcher, streams, subs, controls, history) {
+    // The command line may contain "|", so the charset goes first
+    const sep = launcher.indexOf("|");
+    if (sep < 0) {
+      throw new Exception('Given launcher "' + launcher + '" was invalid');
+    }
+    const charset = launcher.substring(0, sep),
+      commandLine = launcher.substring(sep + 1);
+    try {
+      initialFieldDef["Command"].verify(commandLine);
+      initialFieldDef["Encoding"].verify(charset);
+    } catch (e) {
+      throw new Exception(
+        'Given launcher "' + launcher + '" was invalid: ' + e,
+      );
+    }
+    return this.execute(
+      info,
+      {
+        command: commandLine,
+        charset: charset,
+      },
+      null,
+      null,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  launcher(config) {
+    return (config.charset ? config.charset : "utf-8") + "|" + config.command;
+  }
+
+  represet(preset) {
+    const host = preset.host();
+    if (host.length > 0) {
+      preset.insertMeta("Command", host);
+    }
+    return preset;
+  }
+}
@@ -0,0 +1,536 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import * as reader from "../stream/reader.js";
+import * as address from "./address.js";
+import * as command from "./commands.js";
+import * as common from "./common.js";
+import Exception from "./exception.js";
+import * as history from "./history.js";
+import * as presets from "./presets.js";
+import * as strings from "./string.js";
+import * as telnet from "./telnet.js";
+
+const COMMAND_ID = 0x07;
+
+const SERVER_COM_PORT = 0x04;
+const SERVER_COM_PORT_REFUSED = 0x05;
+
+const CLIENT_DATA_SET_LINE = 0x02;
+const CLIENT_DATA_BREAK = 0x03;
+
+const MAX_BREAK_LENGTH = 0xffff;
+
+// Commands of the COM port reports, which are the RFC 2217 commands that are
+// sent by the remote
+const COM_PORT_SIGNATURE = 100;
+const COM_PORT_SET_BAUD_RATE = 101;
+const COM_PORT_SET_DATA_SIZE = 102;
+const COM_PORT_SET_PARITY = 103;
+const COM_PORT_SET_STOP_SIZE = 104;
+const COM_PORT_SET_CONTROL = 105;
+
+const COM_PORT_CONTROL_BREAK_ON = 5;
+const COM_PORT_CONTROL_BREAK_OFF = 6;
+
+const HostMaxSearchResults = 3;
+
+const parities = {
+  none: 1,
+  odd: 2,
+  even: 3,
+  mark: 4,
+  space: 5,
+};
+
+const stopBits = {
+  1: 1,
+  2: 2,
+  1.5: 3,
+};
+
+const flowControls = {
+  none: 1,
+  "xon/xoff": 2,
+  hardware: 3,
+};
+
+/**
+ * Look up the value of a named line setting
+ *
+ * @param {object} values Known values
+ * @param {string} title Title of the setting
+ * @param {string} v Name of the value
+ *
+ * @returns {number} The value
+ *
+ * @throws {Exception} When the name is unknown
+ *
+ */
+function lineSettingValue(values, title, v) {
+  if (!Object.prototype.hasOwnProperty.call(values, v)) {
+    throw new Exception(
+      title + " must be one of: " + Object.keys(values).join(", "),
+    );
+  }
+  return values[v];
+}
+
+/**
+ * Return the name of a line setting value
+ *
+ * @param {object} values Known values
+ * @param {number} v The value
+ *
+ * @returns {string} The name
+ *
+ */
+function lineSettingName(values, v) {
+  for (let k in values) {
+    if (values[k] === v) {
+      return k;
+    }
+  }
+  return "unknown (" + v + ")";
+}
+
+/**
+ * Build the data of a line setting update, the settings that are not
+ * updated are left as 0, so the remote leaves them unchanged
+ *
+ * @param {string} setting Name of the setting
+ * @param {string} v New value of the setting
+ *
+ * @returns {Uint8Array} The data
+ *
+ * @throws {Exception} When the setting or the value is invalid
+ *
+ */
+function lineSettingUpdate(setting, v) {
+  let d = new DataView(new ArrayBuffer(8));
+  switch (setting) {
+    case "baud": {
+      const n = parseInt(v, 10);
+      if (!/^[0-9]+$/.test(v) || n <= 0 || n > 0xffffffff) {
+        throw new Exception("Baud rate must be a positive integer");
+      }
+      d.setUint32(0, n);
+      break;
+    }
+    case "data":
+      if (!/^[5-8]$/.test(v)) {
+        throw new Exception("Data bits must be one of: 5, 6, 7, 8");
+      }
+      d.setUint8(4, parseInt(v, 10));
+      break;
+    case "parity":
+      d.setUint8(5, lineSettingValue(parities, "Parity", v));
+      break;
+    case "stop":
+      d.setUint8(6, lineSettingValue(stopBits, "Stop bits", v));
+      break;
+    case "flow":
+      d.setUint8(7, lineSettingValue(flowControls, "Flow control", v));
+      break;
+    default:
+      throw new Exception('Unknown line setting "' + setting + '"');
+  }
+  return new Uint8Array(d.buffer);
+}
+
+/**
+ * Describe a COM port report
+ *
+ * @param {Uint8Array} d The report
+ *
+ * @returns {string} The description, or empty if it's not worth mentioning
+ *
+ */
+function comPortReport(d) {
+  if (d.length < 2) {
+    return "";
+  }
+  switch (d[0]) {
+    case COM_PORT_SIGNATURE:
+      return "Serial port: " + strings.toString(d.slice(1), "utf-8");
+    case COM_PORT_SET_BAUD_RATE:
+      if (d.length < 5) {
+        return "";
+      }
+      return (
+        "Baud rate: " +
+        new DataView(d.buffer, d.byteOffset, d.byteLength).getUint32(1)
+      );
+    case COM_PORT_SET_DATA_SIZE:
+      return "Data bits: " + d[1];
+    case COM_PORT_SET_PARITY:
+      return "Parity: " + lineSettingName(parities, d[1]);
+    case COM_PORT_SET_STOP_SIZE:
+      return "Stop bits: " + lineSettingName(stopBits, d[1]);
+    case COM_PORT_SET_CONTROL:
+      switch (d[1]) {
+        case flowControls["none"]:
+        case flowControls["xon/xoff"]:
+        case flowControls["hardware"]:
+          return "Flow control: " + lineSettingName(flowControls, d[1]);
+        case COM_PORT_CONTROL_BREAK_ON:
+          return "BREAK started";
+        case COM_PORT_CONTROL_BREAK_OFF:
+          return "BREAK ended";
+      }
+  }
+  return "";
+}
+
+// Serial talks to a RFC 2217 terminal server, which is a Telnet server that
+// also reports and changes the line settings of a serial port
+class Serial extends telnet.Telnet {
+  connectedEvents() {
+    return ["@inband", "@message"];
+  }
+
+  async tick(streamHeader, rd) {
+    switch (streamHeader.marker()) {
+      case SERVER_COM_PORT:
+        if (this.connected) {
+          const msg = comPortReport(await reader.readCompletely(rd));
+          if (msg.length <= 0) {
+            return;
+          }
+          return this.events.fire("message", msg);
+        }
+        break;
+      case SERVER_COM_PORT_REFUSED:
+        if (this.connected) {
+          return this.events.fire(
+            "message",
+            "The remote does not support RFC 2217, the line settings " +
+              "cannot be changed",
+          );
+        }
+        break;
+    }
+    return super.tick(streamHeader, rd);
+  }
+
+  /**
+   * Send line setting update
+   *
+   * @param {string} setting Name of the setting
+   * @param {string} v New value of the setting
+   *
+   * @throws {Exception} When the setting or the value is invalid
+   *
+   */
+  sendSetLine(setting, v) {
+    return this.sender.send(
+      CLIENT_DATA_SET_LINE,
+      lineSettingUpdate(setting, v),
+    );
+  }
+
+  /**
+   * Send BREAK
+   *
+   * @param {number} length Length of the BREAK in milliseconds, 0 to use the
+   *                        default length
+   *
+   */
+  sendBreak(length) {
+    let data = new DataView(new ArrayBuffer(2));
+    data.setUint16(0, Math.min(length, MAX_BREAK_LENGTH));
+    return this.sender.send(CLIENT_DATA_BREAK, new Uint8Array(data.buffer));
+  }
+}
+
+const initialFieldDef = {
+  ...telnet.initialFieldDef,
+  Host: {
+    name: "Host",
+    description:
+      "The RFC 2217 terminal server to connect to. The line settings can " +
+      "be defined by a Preset, and changed after connected",
+    type: "text",
+    value: "",
+    example: "console.nirui.org:7001",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      if (d.length <= 0) {
+        throw new Error("Hostname must be specified");
+      }
+      let addr = common.splitHostPort(d, 0);
+      if (addr.addr.length <= 0) {
+        throw new Error("Cannot be empty");
+      }
+      if (addr.addr.length > address.MAX_ADDR_LEN) {
+        throw new Error(
+          "Can no longer than " + address.MAX_ADDR_LEN + " bytes",
+        );
+      }
+      if (addr.port <= 0) {
+        throw new Error("Port must be specified");
+      }
+      return "Look like " + addr.type + " address";
+    },
+  },
+};
+
+class Wizard extends telnet.Wizard {
+  controlType() {
+    return "Serial";
+  }
+
+  newCommand(sender, config, callbacks) {
+    return new Serial(sender, config, callbacks);
+  }
+
+  controlData(configInput, commandHandler) {
+    return {
+      ...super.controlData(configInput, commandHandler),
+      setLine(setting, v) {
+        return commandHandler.sendSetLine(setting, v);
+      },
+      sendBreak(length) {
+        return commandHandler.sendBreak(length);
+      },
+    };
+  }
+
+  connectedCallbacks() {
+    return {
+      "@inband"(rd) {},
+      "@message"(msg) {},
+    };
+  }
+
+  stepInitialPrompt() {
+    const self = this;
+    return command.prompt(
+      "Serial",
+      "Serial port through RFC 2217",
+      "Connect",
+      (r) => {
+        self.hasStarted = true;
+        self.streams.request(COMMAND_ID, (sd) => {
+          return self.buildCommand(
+            sd,
+            {
+              host: r.host,
+              charset: r.encoding,
+              tabColor: self.preset ? self.preset.tabColor() : "",
+            },
+            self.session,
+          );
+        });
+        self.step.resolve(self.stepWaitForAcceptWait());
+      },
+      () => {},
+      command.fieldsWithPreset(
+        initialFieldDef,
+        [
+          {
+            name: "Host",
+            suggestions(input) {
+              const hosts = self.history.search(
+                "Serial",
+                "host",
+                input,
+                HostMaxSearchResults,
+              );
+              let sugg = [];
+              for (let i = 0; i < hosts.length; i++) {
+                sugg.push({
+                  title: hosts[i].title,
+                  value: hosts[i].data.host,
+                  meta: {
+                    Encoding: hosts[i].data.charset,
+                  },
+                });
+              }
+              return sugg;
+            },
+          },
+          { name: "Encoding" },
+        ],
+        self.preset,
+        (r) => {},
+      ),
+    );
+  }
+}
+
+class Executor extends Wizard {
+  /**
+   * constructor
+   *
+   * @param {command.Info} info
+   * @param {object} config
+   * @param {object} session
+   * @param {Array<string>} keptSessions
+   * @param {streams.Streams} streams
+   * @param {subscribe.Subscribe} subs
+   * @param {controls.Controls} controls
+   * @param {history.History} history
+   *
+   */
+  constructor(
+    info,
+    config,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    super(
+      info,
+      presets.emptyPreset(),
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+    this.config = config;
+  }
+
+  stepInitialPrompt() {
+    const self = this;
+    self.hasStarted = true;
+    self.streams.request(COMMAND_ID, (sd) => {
+      return self.buildCommand(
+        sd,
+        {
+          host: self.config.host,
+          charset: self.config.charset ? self.config.charset : "utf-8",
+          tabColor: self.config.tabColor ? self.config.tabColor : "",
+        },
+        self.session,
+      );
+    });
+    return self.stepWaitForAcceptWait();
+  }
+}
+
+export class Command {
+  constructor() {}
+
+  id() {
+    return COMMAND_ID;
+  }
+
+  name() {
+    return "Serial";
+  }
+
+  description() {
+    return "Serial port through RFC 2217";
+  }
+
+  color() {
+    return "#a8c";
+  }
+
+  wizard(
+    info,
+    preset,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    return new Wizard(
+      info,
+      preset,
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  execute(
+    info,
+    config,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    return new Executor(
+      info,
+      config,
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  launch(info, launcher, streams, subs, controls, history) {
+    const d = launcher.split("|", 2);
+    if (d.length < 2) {
+      throw new Exception('Given launcher "' + launcher + '" was invalid');
+    }
+    try {
+      initialFieldDef["Host"].verify(d[0]);
+      initialFieldDef["Encoding"].verify(d[1]);
+    } catch (e) {
+      throw new Exception(
+        'Given launcher "' + launcher + '" was invalid: ' + e,
+      );
+    }
+    return this.execute(
+      info,
+      {
+        host: d[0],
+        charset: d[1],
+      },
+      null,
+      null,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  launcher(config) {
+    return config.host + "|" + (config.charset ? config.charset : "utf-8");
+  }
+
+  represet(preset) {
+    const host = preset.host();
+    if (host.length > 0) {
+      preset.insertMeta("Host", host);
+    }
+    return preset;
+  }
+}
@@ -0,0 +1,633 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import * as header from "../stream/header.js";
+import * as reader from "../stream/reader.js";
+import * as stream from "../stream/stream.js";
+import * as command from "./commands.js";
+import Exception from "./exception.js";
+import * as history from "./history.js";
+import * as integer from "./integer.js";
+import * as presets from "./presets.js";
+import * as ssh from "./ssh.js";
+import * as strings from "./string.js";
+
+const COMMAND_ID = 0x02;
+
+const SERVER_RESPOND_SUCCEED = 0x00;
+const SERVER_RESPOND_FAILED = 0x01;
+
+const SERVER_RESPOND_MORE_TO_COME = 0x01;
+
+const CLIENT_REQUEST = 0x00;
+
+const OPERATION_LIST = 0x00;
+const OPERATION_STAT = 0x01;
+const OPERATION_READ = 0x02;
+const OPERATION_WRITE = 0x03;
+const OPERATION_RENAME = 0x04;
+const OPERATION_DELETE = 0x05;
+
+const WRITE_FLAG_TRUNCATE = 0x01;
+
+export const FILE_TYPE_REGULAR = 0x00;
+export const FILE_TYPE_DIRECTORY = 0x01;
+export const FILE_TYPE_SYMLINK = 0x02;
+export const FILE_TYPE_OTHER = 0x03;
+
+// Request ID is one byte, so there can't be more pending requests than this
+const MAX_PENDING_REQUESTS = 256;
+
+const REQUEST_HEADER_LENGTH = 2;
+const FILE_INFO_FIXED_LENGTH = 1 + 2 + 8 + 8;
+
+// Max length of data that is read or written by one request. It's kept under
+// the max length of a stream segment
+export const MAX_TRANSFER_LENGTH = 4096;
+
+const HostMaxSearchResults = 3;
+
+/**
+ * Read an unsigned 64 bits integer from given bytes
+ *
+ * @param {Uint8Array} b Data
+ * @param {number} start Where the integer starts
+ *
+ * @returns {number} The integer
+ *
+ */
+function getUint64(b, start) {
+  const d = new DataView(b.buffer, b.byteOffset, b.byteLength);
+  return d.getUint32(start) * 0x100000000 + d.getUint32(start + 4);
+}
+
+/**
+ * Write an unsigned 64 bits integer to given bytes
+ *
+ * @param {Uint8Array} b Data
+ * @param {number} start Where the integer starts
+ * @param {number} n The integer
+ *
+ */
+function setUint64(b, start, n) {
+  const d = new DataView(b.buffer, b.byteOffset, b.byteLength);
+  d.setUint32(start, Math.floor(n / 0x100000000));
+  d.setUint32(start + 4, n % 0x100000000);
+}
+
+/**
+ * Read a file info
+ *
+ * @param {reader.Limited} rd Data reader
+ *
+ * @returns {object} The file info
+ *
+ */
+async function readFileInfo(rd) {
+  const name = await strings.String.read(rd),
+    d = await reader.readN(rd, FILE_INFO_FIXED_LENGTH);
+  return {
+    name: strings.toString(name.data(), "utf-8"),
+    type: d[0],
+    permissions: (d[1] << 8) | d[2],
+    size: getUint64(d, 3),
+    modified: new Date(getUint64(d, 11) * 1000),
+  };
+}
+
+/**
+ * Read all file infos
+ *
+ * @param {reader.Limited} rd Data reader
+ *
+ * @returns {Array<object>} The file infos
+ *
+ */
+async function readFileInfos(rd) {
+  let infos = [];
+  while (!rd.completed()) {
+    infos.push(await readFileInfo(rd));
+  }
+  return infos;
+}
+
+/**
+ * Build the String of a path
+ *
+ * @param {string} path The path
+ *
+ * @returns {Uint8Array} The String
+ *
+ */
+function pathBuffer(path) {
+  return new strings.String(strings.fromString(path)).buffer();
+}
+
+class SFTP extends ssh.SSH {
+  /**
+   * constructor
+   *
+   * @param {stream.Sender} sd Stream sender
+   * @param {object} config configuration
+   * @param {object} callbacks Event callbacks
+   *
+   */
+  constructor(sd, config, callbacks) {
+    super(sd, config, callbacks);
+    this.requests = {};
+    this.nextRequestID = 0;
+  }
+
+  connectedEvents() {
+    return ["@message"];
+  }
+
+  bootup() {
+    return this.connectionBootup();
+  }
+
+  async tickConnected(marker, rd) {
+    switch (marker) {
+      case SERVER_RESPOND_SUCCEED: {
+        const h = await reader.readN(rd, 2),
+          req = this.requests[h[0]];
+        if (!req) {
+          throw new Exception("Respond to an unknown request");
+        }
+        req.results.push(await req.parse(rd));
+        if (h[1] === SERVER_RESPOND_MORE_TO_COME) {
+          return;
+        }
+        delete this.requests[h[0]];
+        return req.resolve(req.results);
+      }
+      case SERVER_RESPOND_FAILED: {
+        const id = await reader.readOne(rd),
+          req = this.requests[id[0]],
+          msg = strings.toString(await reader.readCompletely(rd), "utf-8");
+        if (!req) {
+          throw new Exception("Respond to an unknown request");
+        }
+        delete this.requests[id[0]];
+        return req.reject(new Exception(msg));
+      }
+    }
+    return super.tickConnected(marker, rd);
+  }
+
+  /**
+   * Send a request to remote
+   *
+   * @param {number} operation Requested operation
+   * @param {Array<Uint8Array>} params Parameters of the operation
+   * @param {function} parse Parses the respond
+   *
+   * @returns {Promise<Array<any>>} Parsed responds
+   *
+   */
+  request(operation, params, parse) {
+    let id = -1;
+    for (let i = 0; i < MAX_PENDING_REQUESTS; i++) {
+      const next = (this.nextRequestID + i) % MAX_PENDING_REQUESTS;
+      if (this.requests[next]) {
+        continue;
+      }
+      id = next;
+      break;
+    }
+    if (id < 0) {
+      return Promise.reject(new Exception("Too many pending requests"));
+    }
+    this.nextRequestID = (id + 1) % MAX_PENDING_REQUESTS;
+    let dataLen = REQUEST_HEADER_LENGTH;
+    for (let i = 0; i < params.length; i++) {
+      dataLen += params[i].length;
+    }
+    let data = new Uint8Array(dataLen),
+      start = REQUEST_HEADER_LENGTH;
+    data[0] = id;
+    data[1] = operation;
+    for (let i = 0; i < params.length; i++) {
+      data.set(params[i], start);
+      start += params[i].length;
+    }
+    return new Promise((resolve, reject) => {
+      this.requests[id] = {
+        parse: parse,
+        results: [],
+        resolve: resolve,
+        reject: reject,
+      };
+      try {
+        this.sender.send(CLIENT_REQUEST, data);
+      } catch (e) {
+        delete this.requests[id];
+        reject(e);
+      }
+    });
+  }
+
+  /**
+   * List the directory
+   *
+   * @param {string} path Path of the directory
+   *
+   * @returns {Array<object>} File infos of the directory items
+   *
+   */
+  async list(path) {
+    const results = await this.request(
+      OPERATION_LIST,
+      [pathBuffer(path)],
+      readFileInfos,
+    );
+    return [].concat(...results);
+  }
+
+  /**
+   * Get the file info
+   *
+   * @param {string} path Path of the file
+   *
+   * @returns {object} The file info
+   *
+   */
+  async stat(path) {
+    const results = await this.request(
+      OPERATION_STAT,
+      [pathBuffer(path)],
+      readFileInfo,
+    );
+    return results[0];
+  }
+
+  /**
+   * Read the file
+   *
+   * @param {string} path Path of the file
+   * @param {number} offset Where to start reading
+   * @param {number} length Max length of the data to read
+   *
+   * @returns {Uint8Array} The data, empty when reached the end of the file
+   *
+   */
+  async read(path, offset, length) {
+    let offsetBuf = new Uint8Array(8);
+    setUint64(offsetBuf, 0, offset);
+    const results = await this.request(
+      OPERATION_READ,
+      [
+        pathBuffer(path),
+        offsetBuf,
+        new integer.Integer(Math.min(length, MAX_TRANSFER_LENGTH)).marshal(),
+      ],
+      reader.readCompletely,
+    );
+    return results[0];
+  }
+
+  /**
+   * Write the file, the file is created if it's not existed
+   *
+   * @param {string} path Path of the file
+   * @param {number} offset Where to start writing
+   * @param {boolean} truncate Whether or not to truncate the file first
+   * @param {Uint8Array} data Data to write, can't be longer than
+   *                          MAX_TRANSFER_LENGTH
+   *
+   */
+  async write(path, offset, truncate, data) {
+    if (data.length > MAX_TRANSFER_LENGTH) {
+      throw new Exception("Data is too long to be written at once");
+    }
+    const p = pathBuffer(path);
+    let params = new Uint8Array(9);
+    if (
+      REQUEST_HEADER_LENGTH + p.length + params.length + data.length >
+      header.STREAM_MAX_LENGTH
+    ) {
+      throw new Exception("Path is too long");
+    }
+    setUint64(params, 0, offset);
+    params[8] = truncate ? WRITE_FLAG_TRUNCATE : 0;
+    await this.request(
+      OPERATION_WRITE,
+      [p, params, data],
+      reader.readCompletely,
+    );
+  }
+
+  /**
+   * Rename the file
+   *
+   * @param {string} path Path of the file
+   * @param {string} newPath New path of the file
+   *
+   */
+  async rename(path, newPath) {
+    await this.request(
+      OPERATION_RENAME,
+      [pathBuffer(path), pathBuffer(newPath)],
+      reader.readCompletely,
+    );
+  }
+
+  /**
+   * Delete the file
+   *
+   * @param {string} path Path of the file
+   *
+   */
+  async delete(path) {
+    await this.request(
+      OPERATION_DELETE,
+      [pathBuffer(path)],
+      reader.readCompletely,
+    );
+  }
+
+  /**
+   * Tear down the command completely
+   *
+   */
+  completed() {
+    for (let id in this.requests) {
+      this.requests[id].reject(new Exception("Connection has been closed"));
+    }
+    this.requests = {};
+    return super.completed();
+  }
+}
+
+class Wizard extends ssh.Wizard {
+  controlType() {
+    return "SFTP";
+  }
+
+  newCommand(sender, config, callbacks) {
+    return new SFTP(sender, config, callbacks);
+  }
+
+  controlData(configInput, commandHandler) {
+    return {
+      tabColor: configInput.tabColor,
+      sftp: commandHandler,
+      close() {
+        return commandHandler.sendClose();
+      },
+      events: commandHandler.events,
+    };
+  }
+
+  connectedCallbacks() {
+    return {
+      "@message"(msg) {},
+    };
+  }
+
+  stepInitialPrompt() {
+    let self = this;
+    return command.prompt(
+      "SFTP",
+      "SSH File Transfer Protocol",
+      "Connect",
+      (r) => {
+        self.hasStarted = true;
+        self.streams.request(COMMAND_ID, (sd) => {
+          return self.buildCommand(
+            sd,
+            {
+              user: r.user,
+              authentication: r.authentication,
+              host: r.host,
+              tabColor: self.preset ? self.preset.tabColor() : "",
+              fingerprint: self.preset
+                ? self.preset.metaDefault("Fingerprint", "")
+                : "",
+            },
+            self.session,
+          );
+        });
+        self.step.resolve(self.stepWaitForAcceptWait());
+      },
+      () => {},
+      command.fieldsWithPreset(
+        ssh.initialFieldDef,
+        [
+          {
+            name: "Host",
+            suggestions(input) {
+              const hosts = self.history.search(
+                "SFTP",
+                "host",
+                input,
+                HostMaxSearchResults,
+              );
+              let sugg = [];
+              for (let i = 0; i < hosts.length; i++) {
+                sugg.push({
+                  title: hosts[i].title,
+                  value: hosts[i].data.host,
+                  meta: {
+                    User: hosts[i].data.user,
+                    Authentication: hosts[i].data.authentication,
+                  },
+                });
+              }
+              return sugg;
+            },
+          },
+          { name: "User" },
+          { name: "Authentication" },
+          { name: "Notice" },
+        ],
+        self.preset,
+        (r) => {},
+      ),
+    );
+  }
+}
+
+class Executer extends Wizard {
+  /**
+   * constructor
+   *
+   * @param {command.Info} info
+   * @param {config} config
+   * @param {object} session
+   * @param {Array<string>} keptSessions
+   * @param {streams.Streams} streams
+   * @param {subscribe.Subscribe} subs
+   * @param {controls.Controls} controls
+   * @param {history.History} history
+   *
+   */
+  constructor(
+    info,
+    config,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    super(
+      info,
+      presets.emptyPreset(),
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+    this.config = config;
+  }
+
+  stepInitialPrompt() {
+    const self = this;
+    self.hasStarted = true;
+    self.streams.request(COMMAND_ID, (sd) => {
+      return self.buildCommand(
+        sd,
+        {
+          user: self.config.user,
+          authentication: self.config.authentication,
+          host: self.config.host,
+          tabColor: self.config.tabColor ? self.config.tabColor : "",
+          fingerprint: self.config.fingerprint,
+        },
+        self.session,
+      );
+    });
+    return self.stepWaitForAcceptWait();
+  }
+}
+
+export class Command {
+  constructor() {}
+
+  id() {
+    return COMMAND_ID;
+  }
+
+  name() {
+    return "SFTP";
+  }
+
+  description() {
+    return "SSH File Transfer Protocol";
+  }
+
+  color() {
+    return "#8c4";
+  }
+
+  wizard(
+    info,
+    preset,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    return new Wizard(
+      info,
+      preset,
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  execute(
+    info,
+    config,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    return new Executer(
+      info,
+      config,
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  launch(info, launcher, streams, subs, controls, history) {
+    const d = launcher.split("|", 2);
+    if (d.length < 2) {
+      throw new Exception('Given launcher "' + launcher + '" was invalid');
+    }
+    const userHostName = d[0].match(new RegExp("^(.*)\\@(.*)$"));
+    if (!userHostName || userHostName.length !== 3) {
+      throw new Exception('Given launcher "' + launcher + '" was malformed');
+    }
+    let user = userHostName[1],
+      host = userHostName[2],
+      auth = d[1];
+    try {
+      ssh.initialFieldDef["User"].verify(user);
+      ssh.initialFieldDef["Host"].verify(host);
+      ssh.initialFieldDef["Authentication"].verify(auth);
+    } catch (e) {
+      throw new Exception(
+        'Given launcher "' + launcher + '" was malformed ' + e,
+      );
+    }
+    return this.execute(
+      info,
+      {
+        user: user,
+        host: host,
+        authentication: auth,
+      },
+      null,
+      null,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  launcher(config) {
+    return config.user + "@" + config.host + "|" + config.authentication;
+  }
+
+  represet(preset) {
+    const host = preset.host();
+    if (host.length > 0) {
+      preset.insertMeta("Host", host);
+    }
+    return preset;
+  }
+}
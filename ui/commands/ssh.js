@@ -38,8 +38,18 @@ const COMMAND_ID = 0x01;
 const MAX_USERNAME_LEN = 127;
 const MAX_PASSWORD_LEN = 4096;
 const MAX_CHALLENGE_ANSWER_LEN = 4096;
+const MAX_COMMAND_LEN = 4096;
 const DEFAULT_PORT = 22;
 
+// The type of the terminal emulated by the console
+const TERM_TYPE = "xterm-256color";
+
+// Estimated size (in pixels) of a character cell of the console, it's used to
+// guess the initial size of the terminal before the console is displayed. The
+// console will report the actual size once it's ready
+const TERM_CELL_WIDTH = 11;
+const TERM_CELL_HEIGHT = 21;
+
 const SERVER_REMOTE_STDOUT = 0x00;
 const SERVER_REMOTE_STDERR = 0x01;
 const SERVER_HOOK_OUTPUT_BEFORE_CONNECTING = 0x02;
@@ -47,10 +57,22 @@ const SERVER_CONNECT_FAILED = 0x03;
 const SERVER_CONNECTED = 0x04;
 const SERVER_CONNECT_REQUEST_FINGERPRINT = 0x05;
 const SERVER_CONNECT_REQUEST_CREDENTIAL = 0x06;
+const SERVER_NOTICE = 0x07;
+
+const SERVER_NOTICE_JUMP_HOST = 0x00;
+const SERVER_NOTICE_EXIT_STATUS = 0x01;
+const SERVER_NOTICE_BANNER = 0x02;
+const SERVER_NOTICE_VERSION = 0x03;
+const SERVER_NOTICE_RECONNECT = 0x04;
+
+const SERVER_NOTICE_EXIT_STATUS_UNKNOWN = -1;
+
+const SERVER_NOTICE_RECONNECT_RECONNECTED = 0x01;
 
 const SERVER_CONNECT_REQUEST_CREDENTIAL_PRIVATEKEY = 0x00;
 const SERVER_CONNECT_REQUEST_CREDENTIAL_PASSPHRASE = 0x01;
 const SERVER_CONNECT_REQUEST_CREDENTIAL_KEYBOARD = 0x02;
+const SERVER_CONNECT_REQUEST_CREDENTIAL_KEY_PASSPHRASE = 0x03;
 
 const CLIENT_DATA_STDIN = 0x00;
 const CLIENT_DATA_RESIZE = 0x01;
@@ -60,6 +82,7 @@ const CLIENT_CONNECT_RESPOND_CREDENTIAL = 0x03;
 const SERVER_REQUEST_ERROR_BAD_USERNAME = 0x01;
 const SERVER_REQUEST_ERROR_BAD_ADDRESS = 0x02;
 const SERVER_REQUEST_ERROR_BAD_AUTHMETHOD = 0x03;
+const SERVER_REQUEST_ERROR_BAD_TERMINAL = 0x04;
 
 const FingerprintPromptVerifyPassed = 0x00;
 const FingerprintPromptVerifyNoRecord = 0x01;
@@ -67,7 +90,79 @@ const FingerprintPromptVerifyMismatch = 0x02;
 
 const HostMaxSearchResults = 3;
 
-class SSH {
+/**
+ * Read the notice received after the connection has been established, and
+ * convert it to a message that can be displayed in the console
+ *
+ * @param {reader.Limited} rd Data reader
+ *
+ * @returns {string} The message, or empty if there is nothing to display
+ *
+ */
+async function readNoticeMessage(rd) {
+  const noticeType = await reader.readOne(rd);
+  switch (noticeType[0]) {
+    case SERVER_NOTICE_JUMP_HOST: {
+      const hop = await reader.readN(rd, 2),
+        host = strings.toString(await reader.readCompletely(rd), "utf-8");
+      return "Connecting to " + host + " (" + (hop[0] + 1) + "/" + hop[1] + ")";
+    }
+    case SERVER_NOTICE_EXIT_STATUS: {
+      const s = await reader.readN(rd, 4),
+        status = (s[0] << 24) | (s[1] << 16) | (s[2] << 8) | s[3],
+        signal = strings.toString(
+          (await strings.String.read(rd)).data(),
+          "utf-8",
+        ),
+        msg = strings.toString((await strings.String.read(rd)).data(), "utf-8");
+      let result = "Remote command has exited";
+      if (signal.length > 0) {
+        result = "Remote command has been terminated by signal " + signal;
+      } else if (status !== SERVER_NOTICE_EXIT_STATUS_UNKNOWN) {
+        result = "Remote command has exited with status " + status;
+      }
+      return msg.length > 0 ? result + ": " + msg : result;
+    }
+    case SERVER_NOTICE_BANNER:
+      return strings.toString(await reader.readCompletely(rd), "utf-8");
+    case SERVER_NOTICE_VERSION:
+      return (
+        "Remote is running " +
+        strings.toString(await reader.readCompletely(rd), "utf-8")
+      );
+    case SERVER_NOTICE_RECONNECT: {
+      const d = await reader.readN(rd, 2),
+        reason = strings.toString(await reader.readCompletely(rd), "utf-8");
+      if (d[0] === SERVER_NOTICE_RECONNECT_RECONNECTED) {
+        return "Reconnected after " + d[1] + " attempt(s)";
+      }
+      return "Connection lost: " + reason + ". Reconnecting (" + d[1] + ")";
+    }
+  }
+  await reader.readCompletely(rd);
+  return "";
+}
+
+/**
+ * Estimate the size of the terminal that fits into the current window
+ *
+ * @returns {object} rows and cols of the terminal, or 0 if unknown
+ *
+ */
+export function estimateTermSize() {
+  if (typeof window === "undefined") {
+    return { rows: 0, cols: 0 };
+  }
+  return {
+    rows: Math.min(Math.floor(window.innerHeight / TERM_CELL_HEIGHT), 0xffff),
+    cols: Math.min(Math.floor(window.innerWidth / TERM_CELL_WIDTH), 0xffff),
+  };
+}
+
+// SSH is also the base of the commands that work on top of a SSH connection,
+// they override connectedEvents, bootup and tickConnected to handle their own
+// data once the connection is established
+export class SSH {
   /**
    * constructor
    *
@@ -91,15 +186,25 @@ class SSH {
         "connect.credential.privatekey",
         "connect.credential.passphrase",
         "connect.credential.keyboard",
-        "@stdout",
-        "@stderr",
+        "connect.credential.keypassphrase",
+        "notice",
         "close",
         "@completed",
-      ],
+      ].concat(this.connectedEvents()),
       callbacks,
     );
   }
 
+  /**
+   * Return the events that are fired after the connection is established
+   *
+   * @returns {Array<string>} Event names
+   *
+   */
+  connectedEvents() {
+    return ["@message", "@stdout", "@stderr"];
+  }
+
   /**
    * Send intial request
    *
@@ -107,20 +212,56 @@ class SSH {
    *
    */
   run(initialSender) {
+    const parts = this.bootup();
+    let dataLen = 0;
+    for (let i = 0; i < parts.length; i++) {
+      dataLen += parts[i].length;
+    }
+    let data = new Uint8Array(dataLen),
+      start = 0;
+    for (let i = 0; i < parts.length; i++) {
+      data.set(parts[i], start);
+      start += parts[i].length;
+    }
+    initialSender.send(data);
+  }
+
+  /**
+   * Build the user name, address and auth method part of the initial request
+   *
+   * @returns {Array<Uint8Array>} Parts of the initial request
+   *
+   */
+  connectionBootup() {
     let user = new strings.String(this.config.user),
-      userBuf = user.buffer(),
       addr = new address.Address(
         this.config.host.type,
         this.config.host.address,
         this.config.host.port,
-      ),
-      addrBuf = addr.buffer(),
-      authMethod = new Uint8Array([this.config.auth]);
-    let data = new Uint8Array(userBuf.length + addrBuf.length + 1);
-    data.set(userBuf, 0);
-    data.set(addrBuf, userBuf.length);
-    data.set(authMethod, userBuf.length + addrBuf.length);
-    initialSender.send(data);
+      );
+    return [user.buffer(), addr.buffer(), new Uint8Array([this.config.auth])];
+  }
+
+  /**
+   * Build the initial request
+   *
+   * @returns {Array<Uint8Array>} Parts of the initial request
+   *
+   */
+  bootup() {
+    let termType = new strings.String(strings.fromString(TERM_TYPE)),
+      termSize = new DataView(new ArrayBuffer(4)),
+      initialSize = estimateTermSize();
+    termSize.setUint16(0, initialSize.rows);
+    termSize.setUint16(2, initialSize.cols);
+    let parts = this.connectionBootup().concat([
+      termType.buffer(),
+      new Uint8Array(termSize.buffer),
+    ]);
+    if (this.config.command.length > 0) {
+      parts.push(new strings.String(this.config.command).buffer());
+    }
+    return parts;
   }
 
   /**
@@ -150,59 +291,66 @@ class SSH {
    *
    */
   async tick(streamHeader, rd) {
+    if (this.connected) {
+      return this.tickConnected(streamHeader.marker(), rd);
+    }
     switch (streamHeader.marker()) {
-      case SERVER_CONNECT_REQUEST_CREDENTIAL:
-        if (!this.connected) {
-          let credType = await reader.readOne(rd);
-          let authType = "";
-          switch (credType[0]) {
-            case SERVER_CONNECT_REQUEST_CREDENTIAL_PRIVATEKEY:
-              authType = "connect.credential.privatekey";
-              break;
-            case SERVER_CONNECT_REQUEST_CREDENTIAL_PASSPHRASE:
-              authType = "connect.credential.passphrase";
-              break;
-            case SERVER_CONNECT_REQUEST_CREDENTIAL_KEYBOARD:
-              authType = "connect.credential.keyboard";
-              break;
-          }
-          if (authType.length <= 0) {
-            throw new Exception("Request an unknown credential type");
-          }
-          return this.events.fire(authType, rd, this.sender);
+      case SERVER_CONNECT_REQUEST_CREDENTIAL: {
+        let credType = await reader.readOne(rd);
+        let authType = "";
+        switch (credType[0]) {
+          case SERVER_CONNECT_REQUEST_CREDENTIAL_PRIVATEKEY:
+            authType = "connect.credential.privatekey";
+            break;
+          case SERVER_CONNECT_REQUEST_CREDENTIAL_PASSPHRASE:
+            authType = "connect.credential.passphrase";
+            break;
+          case SERVER_CONNECT_REQUEST_CREDENTIAL_KEYBOARD:
+            authType = "connect.credential.keyboard";
+            break;
+          case SERVER_CONNECT_REQUEST_CREDENTIAL_KEY_PASSPHRASE:
+            authType = "connect.credential.keypassphrase";
+            break;
         }
-        break;
-      case SERVER_CONNECT_REQUEST_FINGERPRINT:
-        if (!this.connected) {
-          return this.events.fire("connect.fingerprint", rd, this.sender);
+        if (authType.length <= 0) {
+          throw new Exception("Request an unknown credential type");
         }
-        break;
+        return this.events.fire(authType, rd, this.sender);
+      }
+      case SERVER_CONNECT_REQUEST_FINGERPRINT:
+        return this.events.fire("connect.fingerprint", rd, this.sender);
       case SERVER_CONNECTED:
-        if (!this.connected) {
-          this.connected = true;
-          return this.events.fire("connect.succeed", rd, this);
-        }
-        break;
+        this.connected = true;
+        return this.events.fire("connect.succeed", rd, this);
       case SERVER_CONNECT_FAILED:
-        if (!this.connected) {
-          return this.events.fire("connect.failed", rd);
-        }
-        break;
+        return this.events.fire("connect.failed", rd);
       case SERVER_HOOK_OUTPUT_BEFORE_CONNECTING:
-        if (!this.connected) {
-          return this.events.fire("hook.before_connected", rd);
-        }
-        break;
+        return this.events.fire("hook.before_connected", rd);
+      case SERVER_NOTICE:
+        return this.events.fire("notice", rd);
+    }
+    throw new Exception("Unknown stream header marker");
+  }
+
+  /**
+   * Tick the command after the connection is established
+   *
+   * @param {number} marker Stream data marker
+   * @param {reader.Limited} rd Data reader
+   *
+   * @returns {any} The result of the ticking
+   *
+   * @throws {Exception} When the stream header type is unknown
+   *
+   */
+  async tickConnected(marker, rd) {
+    switch (marker) {
       case SERVER_REMOTE_STDERR:
-        if (this.connected) {
-          return this.events.fire("stderr", rd);
-        }
-        break;
+        return this.events.fire("stderr", rd);
       case SERVER_REMOTE_STDOUT:
-        if (this.connected) {
-          return this.events.fire("stdout", rd);
-        }
-        break;
+        return this.events.fire("stdout", rd);
+      case SERVER_NOTICE:
+        return this.events.fire("message", await readNoticeMessage(rd));
     }
     throw new Exception("Unknown stream header marker");
   }
@@ -257,7 +405,7 @@ class SSH {
   }
 }
 
-const initialFieldDef = {
+export const initialFieldDef = {
   Host: {
     name: "Host",
     description: "",
@@ -309,6 +457,31 @@ const initialFieldDef = {
       return "We'll login as user \"" + d + '"';
     },
   },
+  Command: {
+    name: "Command",
+    description:
+      "Run this command instead of an interactive shell. The command runs " +
+      "without a terminal, so its output is not mangled by prompts and " +
+      "escape codes",
+    type: "text",
+    value: "",
+    example: "uptime",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      if (d.length <= 0) {
+        return "We'll start an interactive shell";
+      }
+      if (d.length > MAX_COMMAND_LEN) {
+        throw new Error(
+          "It's too long, make it shorter than " + MAX_COMMAND_LEN + " bytes",
+        );
+      }
+      return "We'll run this command and show its output";
+    },
+  },
   Encoding: {
     name: "Encoding",
     description: "The character encoding of the server",
@@ -367,14 +540,35 @@ const initialFieldDef = {
       return "We'll login with this password";
     },
   },
+  "Key Passphrase": {
+    name: "Key Passphrase",
+    description: "",
+    type: "password",
+    value: "",
+    example: "----------",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      if (d.length <= 0) {
+        throw new Error("Passphrase must be specified");
+      }
+      if (d.length > MAX_PASSWORD_LEN) {
+        throw new Error(
+          "It's too long, make it shorter than " + MAX_PASSWORD_LEN + " bytes",
+        );
+      }
+      return "We'll decrypt the Private Key with this passphrase";
+    },
+  },
   "Private Key": {
     name: "Private Key",
     description:
       'Like the one inside <i style="color: #fff; font-style: normal;">' +
-      "~/.ssh/id_rsa</i>, can&apos;t be encrypted<br /><br />" +
-      'To decrypt the Private Key, use command: <i style="color: #fff;' +
-      ' font-style: normal;">ssh-keygen -f /path/to/private_key -p</i><br />' +
-      "<br />" +
+      "~/.ssh/id_rsa</i>. Multiple keys can be provided one after another, " +
+      "they will be tried in order. If a key is encrypted, you&apos;ll be " +
+      "asked for its passphrase<br /><br />" +
       "It is strongly recommended to use one Private Key per SSH server if " +
       "the Private Key will be submitted to Sshwifty. To generate a new SSH " +
       'key pair, use command <i style="color: #fff; font-style: normal;">' +
@@ -397,29 +591,6 @@ const initialFieldDef = {
           "It's too long, make it shorter than " + MAX_PASSWORD_LEN + " bytes",
         );
       }
-      const lines = d.trim().split("\n");
-      let firstLineReaded = false;
-      for (let i in lines) {
-        if (!firstLineReaded) {
-          if (lines[i].indexOf("-") === 0) {
-            firstLineReaded = true;
-            if (lines[i].indexOf("RSA") <= 0) {
-              break;
-            }
-          }
-          continue;
-        }
-        if (lines[i].indexOf("Proc-Type: 4,ENCRYPTED") === 0) {
-          throw new Error("Cannot use encrypted Private Key file");
-        }
-        if (lines[i].indexOf(":") > 0) {
-          continue;
-        }
-        if (lines[i].indexOf("MII") < 0) {
-          throw new Error("Cannot use encrypted Private Key file");
-        }
-        break;
-      }
       return "We'll login with this Private Key";
     },
   },
@@ -528,7 +699,9 @@ function getAuthMethodFromStr(b) {
   return method;
 }
 
-class Wizard {
+// Wizard is also the base of the wizards of the commands that work on top of a
+// SSH connection, which share the same connecting and authentication steps
+export class Wizard {
   /**
    * constructor
    *
@@ -563,9 +736,18 @@ class Wizard {
         };
     this.keptSessions = keptSessions;
     this.step = subs;
-    this.controls = controls.get("SSH");
+    this.controls = controls.get(this.controlType());
     this.history = history;
     this.repeatedAuth = {};
+    this.jumpHost = "";
+  }
+
+  controlType() {
+    return "SSH";
+  }
+
+  remoteName() {
+    return this.jumpHost ? "Jump host " + this.jumpHost : "Remote";
   }
 
   isAuthRepeated(authType) {
@@ -622,6 +804,20 @@ class Wizard {
     );
   }
 
+  stepWaitForHopEstablishWait(host, hop, hops) {
+    if (hops <= 1) {
+      return this.stepWaitForEstablishWait(host);
+    }
+    return command.wait(
+      "Connecting to " + host,
+      hop < hops
+        ? "Establishing connection with jump host " + hop + " of " + hops
+        : "Establishing connection with the remote host through " +
+            (hops - 1) +
+            " jump host(s)",
+    );
+  }
+
   stepContinueWaitForEstablishWait() {
     return command.wait(
       "Connecting",
@@ -630,48 +826,128 @@ class Wizard {
   }
 
   /**
+   * Build the configuration of the command
    *
-   * @param {stream.Sender} sender
    * @param {object} configInput
    * @param {object} sessionData
    *
+   * @returns {object} The configuration
+   *
    */
-  buildCommand(sender, configInput, sessionData) {
-    let self = this;
-    let config = {
+  buildConfig(configInput, sessionData) {
+    return {
       user: common.strToUint8Array(configInput.user),
       auth: getAuthMethodFromStr(configInput.authentication),
       charset: configInput.charset,
       credential: sessionData.credential,
       host: address.parseHostPort(configInput.host, DEFAULT_PORT),
       fingerprint: configInput.fingerprint,
+      command: strings.fromString(
+        configInput.command ? configInput.command : "",
+      ),
     };
+  }
+
+  /**
+   *
+   * @param {stream.Sender} sender
+   * @param {object} config
+   * @param {object} callbacks
+   *
+   * @returns {SSH} The command
+   *
+   */
+  newCommand(sender, config, callbacks) {
+    return new SSH(sender, config, callbacks);
+  }
+
+  /**
+   * Return the message of the error code that the initial request was
+   * rejected with
+   *
+   * @param {number} code Error code
+   *
+   * @returns {string} The message, or empty if the code is unknown
+   *
+   */
+  requestErrorMessage(code) {
+    switch (code) {
+      case SERVER_REQUEST_ERROR_BAD_USERNAME:
+        return "Invalid username";
+      case SERVER_REQUEST_ERROR_BAD_ADDRESS:
+        return "Invalid address";
+      case SERVER_REQUEST_ERROR_BAD_AUTHMETHOD:
+        return "Invalid authentication method";
+      case SERVER_REQUEST_ERROR_BAD_TERMINAL:
+        return "Invalid terminal settings";
+    }
+    return "";
+  }
+
+  /**
+   * Build the data of the control once the connection is established
+   *
+   * @param {object} configInput
+   * @param {SSH} commandHandler
+   *
+   * @returns {object} Control data
+   *
+   */
+  controlData(configInput, commandHandler) {
+    return {
+      charset: configInput.charset,
+      tabColor: configInput.tabColor,
+      // Commands run without a terminal, which don't convert the line breaks
+      convertEol: configInput.command ? true : false,
+      send(data) {
+        return commandHandler.sendData(data);
+      },
+      close() {
+        return commandHandler.sendClose();
+      },
+      resize(rows, cols) {
+        return commandHandler.sendResize(rows, cols);
+      },
+      events: commandHandler.events,
+    };
+  }
+
+  /**
+   * Return the default callbacks of the events that are fired after the
+   * connection is established, they'll be replaced by the control
+   *
+   * @returns {object} Callbacks
+   *
+   */
+  connectedCallbacks() {
+    return {
+      "@message"(msg) {},
+      "@stdout"(rd) {},
+      "@stderr"(rd) {},
+    };
+  }
+
+  /**
+   *
+   * @param {stream.Sender} sender
+   * @param {object} configInput
+   * @param {object} sessionData
+   *
+   */
+  buildCommand(sender, configInput, sessionData) {
+    let self = this;
+    let config = self.buildConfig(configInput, sessionData);
     // Copy the keptSessions from the record so it will not be overwritten here
     let keptSessions = self.keptSessions ? [].concat(...self.keptSessions) : [];
-    return new SSH(sender, config, {
+    return self.newCommand(sender, config, {
+      ...self.connectedCallbacks(),
       "initialization.failed"(hd) {
-        switch (hd.data()) {
-          case SERVER_REQUEST_ERROR_BAD_USERNAME:
-            self.step.resolve(
-              self.stepErrorDone("Request failed", "Invalid username"),
-            );
-            return;
-          case SERVER_REQUEST_ERROR_BAD_ADDRESS:
-            self.step.resolve(
-              self.stepErrorDone("Request failed", "Invalid address"),
-            );
-            return;
-          case SERVER_REQUEST_ERROR_BAD_AUTHMETHOD:
-            self.step.resolve(
-              self.stepErrorDone(
-                "Request failed",
-                "Invalid authentication method",
-              ),
-            );
-            return;
-        }
+        const message = self.requestErrorMessage(hd.data());
         self.step.resolve(
-          self.stepErrorDone("Request failed", "Unknown error: " + hd.data()),
+          self.stepErrorDone(
+            "Request failed",
+            message.length > 0 ? message : "Unknown error: " + hd.data(),
+          ),
         );
       },
       initialized(hd) {
@@ -687,6 +963,39 @@ class Wizard {
           self.stepHookOutputPrompt("Waiting for server hook", d),
         );
       },
+      async notice(rd) {
+        const noticeType = await reader.readOne(rd);
+        switch (noticeType[0]) {
+          case SERVER_NOTICE_JUMP_HOST: {
+            const hop = await reader.readN(rd, 2),
+              host = strings.toString(await reader.readCompletely(rd), "utf-8");
+            self.jumpHost = hop[0] + 1 < hop[1] ? host : "";
+            self.step.resolve(
+              self.stepWaitForHopEstablishWait(host, hop[0] + 1, hop[1]),
+            );
+            return;
+          }
+          case SERVER_NOTICE_BANNER:
+            self.step.resolve(
+              self.stepHookOutputPrompt(
+                "Message from the server",
+                strings.toString(await reader.readCompletely(rd), "utf-8"),
+              ),
+            );
+            return;
+          case SERVER_NOTICE_VERSION:
+            self.step.resolve(
+              self.stepHookOutputPrompt(
+                "Logged in",
+                self.remoteName() +
+                  " is running " +
+                  strings.toString(await reader.readCompletely(rd), "utf-8"),
+              ),
+            );
+            return;
+        }
+        await reader.readCompletely(rd);
+      },
       "connect.succeed"(rd, commandHandler) {
         self.connectionSucceed = true;
         self.step.resolve(
@@ -694,20 +1003,9 @@ class Wizard {
             new command.Result(
               configInput.user + "@" + configInput.host,
               self.info,
-              self.controls.build({
-                charset: configInput.charset,
-                tabColor: configInput.tabColor,
-                send(data) {
-                  return commandHandler.sendData(data);
-                },
-                close() {
-                  return commandHandler.sendClose();
-                },
-                resize(rows, cols) {
-                  return commandHandler.sendResize(rows, cols);
-                },
-                events: commandHandler.events,
-              }),
+              self.controls.build(
+                self.controlData(configInput, commandHandler),
+              ),
               self.controls.ui(),
             ),
           ),
@@ -728,7 +1026,8 @@ class Wizard {
             rd,
             sd,
             (v) => {
-              if (!configInput.fingerprint) {
+              // The recorded fingerprint only belongs to the remote host
+              if (self.jumpHost || !configInput.fingerprint) {
                 return FingerprintPromptVerifyNoRecord;
               }
               if (configInput.fingerprint === v) {
@@ -737,6 +1036,9 @@ class Wizard {
               return FingerprintPromptVerifyMismatch;
             },
             (newFingerprint) => {
+              if (self.jumpHost) {
+                return;
+              }
               configInput.fingerprint = newFingerprint;
             },
           ),
@@ -790,8 +1092,22 @@ class Wizard {
           ),
         );
       },
-      "@stdout"(rd) {},
-      "@stderr"(rd) {},
+      async "connect.credential.keypassphrase"(rd, sd) {
+        self.step.resolve(
+          await self.stepCredentialKeyPassphrasePrompt(
+            rd,
+            sd,
+            config,
+            (newCred, fromPreset) => {
+              sessionData.credential = newCred;
+              // Save the credential if the credential was from a preset
+              if (fromPreset && keptSessions.indexOf("credential") < 0) {
+                keptSessions.push("credential");
+              }
+            },
+          ),
+        );
+      },
       close() {},
       "@completed"() {
         self.step.resolve(
@@ -819,6 +1135,7 @@ class Wizard {
               user: r.user,
               authentication: r.authentication,
               host: r.host,
+              command: r.command,
               charset: r.encoding,
               tabColor: self.preset ? self.preset.tabColor() : "",
               fingerprint: self.preset
@@ -851,6 +1168,7 @@ class Wizard {
                   meta: {
                     User: hosts[i].data.user,
                     Authentication: hosts[i].data.authentication,
+                    Command: hosts[i].data.command,
                     Encoding: hosts[i].data.charset,
                   },
                 });
@@ -860,6 +1178,7 @@ class Wizard {
           },
           { name: "User" },
           { name: "Authentication" },
+          { name: "Command" },
           { name: "Encoding" },
           { name: "Notice" },
         ],
@@ -888,7 +1207,9 @@ class Wizard {
         ? "Do you recognize this server?"
         : "Danger! Server fingerprint has changed!",
       !fingerprintChanged
-        ? "Verify server fingerprint displayed below"
+        ? self.jumpHost
+          ? "Verify fingerprint of jump host " + self.jumpHost + " below"
+          : "Verify server fingerprint displayed below"
         : "It's very unusual. Please verify the new server fingerprint below",
       !fingerprintChanged ? "Yes, I do" : "I'm aware of the change",
       (r) => {
@@ -1006,7 +1327,7 @@ class Wizard {
       credentialStoreType,
       [{ name: "Private Key" }],
       "Provide private key",
-      "Remote is requesting for your private key",
+      this.remoteName() + " is requesting for your private key",
       newCredential,
     );
   }
@@ -1020,7 +1341,27 @@ class Wizard {
       credentialStoreType,
       [{ name: "Password" }],
       "Provide password",
-      "Remote is requesting for your password",
+      this.remoteName() + " is requesting for your password",
+      newCredential,
+    );
+  }
+
+  async stepCredentialKeyPassphrasePrompt(rd, sd, config, newCredential) {
+    const credentialStoreType = "KeyPassphrase";
+    const fingerprint = strings.toString(
+      await reader.readCompletely(rd),
+      "utf-8",
+    );
+    const keyName =
+      fingerprint.length > 0 ? "Private Key " + fingerprint : "Private Key";
+    return await this.stepSimpleCredentialPrompt(
+      rd,
+      sd,
+      config,
+      credentialStoreType,
+      [{ name: "Key Passphrase" }],
+      "Provide key passphrase",
+      keyName + " is encrypted, please provide its passphrase",
       newCredential,
     );
   }
@@ -1127,6 +1468,7 @@ class Executer extends Wizard {
           user: self.config.user,
           authentication: self.config.authentication,
           host: self.config.host,
+          command: self.config.command ? self.config.command : "",
           charset: self.config.charset ? self.config.charset : "utf-8",
           tabColor: self.config.tabColor ? self.config.tabColor : "",
           fingerprint: self.config.fingerprint,
@@ -1213,11 +1555,14 @@ export class Command {
     let user = userHostName[1],
       host = userHostName[2],
       auth = d[1],
-      charset = d.length >= 3 && d[2] ? d[2] : "utf-8"; // RM after depreciation
+      charset = d.length >= 3 && d[2] ? d[2] : "utf-8", // RM after depreciation
+      // The command is the rest of the launcher, it may contain "|" as well
+      cmd = d.length >= 3 ? launcher.slice(d.join("|").length + 1) : "";
     try {
       initialFieldDef["User"].verify(user);
       initialFieldDef["Host"].verify(host);
       initialFieldDef["Authentication"].verify(auth);
+      initialFieldDef["Command"].verify(cmd);
       initialFieldDef["Encoding"].verify(charset);
     } catch (e) {
       throw new Exception(
@@ -1230,6 +1575,7 @@ export class Command {
         user: user,
         host: host,
         authentication: auth,
+        command: cmd,
         charset: charset,
       },
       null,
@@ -1249,7 +1595,8 @@ export class Command {
       "|" +
       config.authentication +
       "|" +
-      (config.charset ? config.charset : "utf-8")
+      (config.charset ? config.charset : "utf-8") +
+      (config.command ? "|" + config.command : "")
     );
   }
 
@@ -0,0 +1,393 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import * as address from "./address.js";
+import * as command from "./commands.js";
+import * as common from "./common.js";
+import Exception from "./exception.js";
+import * as history from "./history.js";
+import * as presets from "./presets.js";
+import * as ssh from "./ssh.js";
+
+const COMMAND_ID = 0x03;
+
+const SERVER_REMOTE_DATA = 0x00;
+
+const SERVER_REQUEST_ERROR_BAD_TARGET_ADDRESS = 0x04;
+const SERVER_REQUEST_ERROR_TARGET_NOT_ALLOWED = 0x05;
+
+const HostMaxSearchResults = 3;
+
+class SSHTunnel extends ssh.SSH {
+  connectedEvents() {
+    return ["@message", "@inband"];
+  }
+
+  bootup() {
+    const target = new address.Address(
+      this.config.target.type,
+      this.config.target.address,
+      this.config.target.port,
+    );
+    return this.connectionBootup().concat([target.buffer()]);
+  }
+
+  async tickConnected(marker, rd) {
+    if (marker === SERVER_REMOTE_DATA) {
+      return this.events.fire("inband", rd);
+    }
+    return super.tickConnected(marker, rd);
+  }
+}
+
+const initialFieldDef = {
+  ...ssh.initialFieldDef,
+  "Tunnel Target": {
+    name: "Tunnel Target",
+    description:
+      "The host to connect to through the SSH server, it only has to be " +
+      "reachable from the SSH server",
+    type: "text",
+    value: "",
+    example: "db.internal:5432",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      if (d.length <= 0) {
+        throw new Error("Tunnel target must be specified");
+      }
+      let addr = common.splitHostPort(d, 0);
+      if (addr.addr.length <= 0) {
+        throw new Error("Cannot be empty");
+      }
+      if (addr.addr.length > address.MAX_ADDR_LEN) {
+        throw new Error(
+          "Can no longer than " + address.MAX_ADDR_LEN + " bytes",
+        );
+      }
+      if (addr.port <= 0) {
+        throw new Error("Port must be specified");
+      }
+      return "Look like " + addr.type + " address";
+    },
+  },
+};
+
+class Wizard extends ssh.Wizard {
+  controlType() {
+    return "SSH Tunnel";
+  }
+
+  buildConfig(configInput, sessionData) {
+    let config = super.buildConfig(configInput, sessionData);
+    config.target = address.parseHostPort(configInput.target, 0);
+    return config;
+  }
+
+  newCommand(sender, config, callbacks) {
+    return new SSHTunnel(sender, config, callbacks);
+  }
+
+  requestErrorMessage(code) {
+    switch (code) {
+      case SERVER_REQUEST_ERROR_BAD_TARGET_ADDRESS:
+        return "Invalid tunnel target";
+      case SERVER_REQUEST_ERROR_TARGET_NOT_ALLOWED:
+        return "The tunnel target is not allowed";
+    }
+    return super.requestErrorMessage(code);
+  }
+
+  controlData(configInput, commandHandler) {
+    return {
+      charset: configInput.charset,
+      tabColor: configInput.tabColor,
+      send(data) {
+        return commandHandler.sendData(data);
+      },
+      close() {
+        return commandHandler.sendClose();
+      },
+      events: commandHandler.events,
+    };
+  }
+
+  connectedCallbacks() {
+    return {
+      "@message"(msg) {},
+      "@inband"(rd) {},
+    };
+  }
+
+  stepInitialPrompt() {
+    let self = this;
+    return command.prompt(
+      "SSH Tunnel",
+      "Connect to a host through SSH server",
+      "Connect",
+      (r) => {
+        self.hasStarted = true;
+        self.streams.request(COMMAND_ID, (sd) => {
+          return self.buildCommand(
+            sd,
+            {
+              user: r.user,
+              authentication: r.authentication,
+              host: r.host,
+              target: r["tunnel target"],
+              charset: r.encoding,
+              tabColor: self.preset ? self.preset.tabColor() : "",
+              fingerprint: self.preset
+                ? self.preset.metaDefault("Fingerprint", "")
+                : "",
+            },
+            self.session,
+          );
+        });
+        self.step.resolve(self.stepWaitForAcceptWait());
+      },
+      () => {},
+      command.fieldsWithPreset(
+        initialFieldDef,
+        [
+          {
+            name: "Host",
+            suggestions(input) {
+              const hosts = self.history.search(
+                "SSH Tunnel",
+                "host",
+                input,
+                HostMaxSearchResults,
+              );
+              let sugg = [];
+              for (let i = 0; i < hosts.length; i++) {
+                sugg.push({
+                  title: hosts[i].title,
+                  value: hosts[i].data.host,
+                  meta: {
+                    User: hosts[i].data.user,
+                    Authentication: hosts[i].data.authentication,
+                    "Tunnel Target": hosts[i].data.target,
+                    Encoding: hosts[i].data.charset,
+                  },
+                });
+              }
+              return sugg;
+            },
+          },
+          { name: "User" },
+          { name: "Authentication" },
+          { name: "Tunnel Target" },
+          { name: "Encoding" },
+          { name: "Notice" },
+        ],
+        self.preset,
+        (r) => {},
+      ),
+    );
+  }
+}
+
+class Executer extends Wizard {
+  /**
+   * constructor
+   *
+   * @param {command.Info} info
+   * @param {config} config
+   * @param {object} session
+   * @param {Array<string>} keptSessions
+   * @param {streams.Streams} streams
+   * @param {subscribe.Subscribe} subs
+   * @param {controls.Controls} controls
+   * @param {history.History} history
+   *
+   */
+  constructor(
+    info,
+    config,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    super(
+      info,
+      presets.emptyPreset(),
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+    this.config = config;
+  }
+
+  stepInitialPrompt() {
+    const self = this;
+    self.hasStarted = true;
+    self.streams.request(COMMAND_ID, (sd) => {
+      return self.buildCommand(
+        sd,
+        {
+          user: self.config.user,
+          authentication: self.config.authentication,
+          host: self.config.host,
+          target: self.config.target,
+          charset: self.config.charset ? self.config.charset : "utf-8",
+          tabColor: self.config.tabColor ? self.config.tabColor : "",
+          fingerprint: self.config.fingerprint,
+        },
+        self.session,
+      );
+    });
+    return self.stepWaitForAcceptWait();
+  }
+}
+
+export class Command {
+  constructor() {}
+
+  id() {
+    return COMMAND_ID;
+  }
+
+  name() {
+    return "SSH Tunnel";
+  }
+
+  description() {
+    return "Connect to a host through SSH server";
+  }
+
+  color() {
+    return "#3bb";
+  }
+
+  wizard(
+    info,
+    preset,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    return new Wizard(
+      info,
+      preset,
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  execute(
+    info,
+    config,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    return new Executer(
+      info,
+      config,
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  launch(info, launcher, streams, subs, controls, history) {
+    const d = launcher.split("|", 4);
+    if (d.length < 3) {
+      throw new Exception('Given launcher "' + launcher + '" was invalid');
+    }
+    const userHostName = d[0].match(new RegExp("^(.*)\\@(.*)$"));
+    if (!userHostName || userHostName.length !== 3) {
+      throw new Exception('Given launcher "' + launcher + '" was malformed');
+    }
+    let user = userHostName[1],
+      host = userHostName[2],
+      auth = d[1],
+      target = d[2],
+      charset = d.length >= 4 && d[3] ? d[3] : "utf-8";
+    try {
+      initialFieldDef["User"].verify(user);
+      initialFieldDef["Host"].verify(host);
+      initialFieldDef["Authentication"].verify(auth);
+      initialFieldDef["Tunnel Target"].verify(target);
+      initialFieldDef["Encoding"].verify(charset);
+    } catch (e) {
+      throw new Exception(
+        'Given launcher "' + launcher + '" was malformed ' + e,
+      );
+    }
+    return this.execute(
+      info,
+      {
+        user: user,
+        host: host,
+        authentication: auth,
+        target: target,
+        charset: charset,
+      },
+      null,
+      null,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  launcher(config) {
+    return (
+      config.user +
+      "@" +
+      config.host +
+      "|" +
+      config.authentication +
+      "|" +
+      config.target +
+      "|" +
+      (config.charset ? config.charset : "utf-8")
+    );
+  }
+
+  represet(preset) {
+    const host = preset.host();
+    if (host.length > 0) {
+      preset.insertMeta("Host", host);
+    }
+    return preset;
+  }
+}
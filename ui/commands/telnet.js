@@ -37,11 +37,16 @@ const SERVER_HOOK_OUTPUT_BEFORE_CONNECTING = 0x01;
 const SERVER_DIAL_FAILED = 0x02;
 const SERVER_DIAL_CONNECTED = 0x03;
 
+const CLIENT_DATA_REMOTE_BAND = 0x00;
+const CLIENT_DATA_RESIZE = 0x01;
+
 const DEFAULT_PORT = 23;
 
 const HostMaxSearchResults = 3;
 
-class Telnet {
+// Telnet is also the base of the commands that talk to a Telnet server, they
+// override connectedEvents and tick to handle their own data
+export class Telnet {
   /**
    * constructor
    *
@@ -61,14 +66,23 @@ class Telnet {
         "hook.before_connected",
         "connect.failed",
         "connect.succeed",
-        "@inband",
         "close",
         "@completed",
-      ],
+      ].concat(this.connectedEvents()),
       callbacks,
     );
   }
 
+  /**
+   * Return the events that are fired after the connection is established
+   *
+   * @returns {Array<string>} Event names
+   *
+   */
+  connectedEvents() {
+    return ["@inband"];
+  }
+
   /**
    * Send intial request
    *
@@ -156,7 +170,21 @@ class Telnet {
    *
    */
   sendData(data) {
-    return this.sender.sendData(0x00, data);
+    return this.sender.sendData(CLIENT_DATA_REMOTE_BAND, data);
+  }
+
+  /**
+   * Send resize request
+   *
+   * @param {number} rows
+   * @param {number} cols
+   *
+   */
+  sendResize(rows, cols) {
+    let data = new DataView(new ArrayBuffer(4));
+    data.setUint16(0, rows);
+    data.setUint16(2, cols);
+    return this.sender.send(CLIENT_DATA_RESIZE, new Uint8Array(data.buffer));
   }
 
   /**
@@ -177,7 +205,7 @@ class Telnet {
   }
 }
 
-const initialFieldDef = {
+export const initialFieldDef = {
   Host: {
     name: "Host",
     description:
@@ -232,7 +260,9 @@ const initialFieldDef = {
   },
 };
 
-class Wizard {
+// Wizard is also the base of the wizards of the commands that talk to a
+// Telnet server
+export class Wizard {
   /**
    * constructor
    *
@@ -263,10 +293,14 @@ class Wizard {
     this.session = session;
     this.keptSessions = keptSessions;
     this.step = subs;
-    this.controls = controls.get("Telnet");
+    this.controls = controls.get(this.controlType());
     this.history = history;
   }
 
+  controlType() {
+    return "Telnet";
+  }
+
   run() {
     this.step.resolve(this.stepInitialPrompt());
   }
@@ -326,6 +360,58 @@ class Wizard {
     );
   }
 
+  /**
+   *
+   * @param {stream.Sender} sender
+   * @param {object} config
+   * @param {object} callbacks
+   *
+   * @returns {Telnet} The command
+   *
+   */
+  newCommand(sender, config, callbacks) {
+    return new Telnet(sender, config, callbacks);
+  }
+
+  /**
+   * Return the data that is used to build the control
+   *
+   * @param {object} configInput
+   * @param {Telnet} commandHandler
+   *
+   * @returns {object} Control data
+   *
+   */
+  controlData(configInput, commandHandler) {
+    return {
+      charset: configInput.charset,
+      tabColor: configInput.tabColor,
+      send(data) {
+        return commandHandler.sendData(data);
+      },
+      close() {
+        return commandHandler.sendClose();
+      },
+      resize(rows, cols) {
+        return commandHandler.sendResize(rows, cols);
+      },
+      events: commandHandler.events,
+    };
+  }
+
+  /**
+   * Return the default callbacks of the events that are fired after the
+   * connection is established, they'll be replaced by the control
+   *
+   * @returns {object} Callbacks
+   *
+   */
+  connectedCallbacks() {
+    return {
+      "@inband"(rd) {},
+    };
+  }
+
   /**
    *
    * @param {stream.Sender} sender
@@ -341,7 +427,8 @@ class Wizard {
     };
     // Copy the keptSessions from the record so it will not be overwritten here
     let keptSessions = self.keptSessions ? [].concat(...self.keptSessions) : [];
-    return new Telnet(sender, parsedConfig, {
+    return self.newCommand(sender, parsedConfig, {
+      ...self.connectedCallbacks(),
       "initialization.failed"(streamInitialHeader) {
         switch (streamInitialHeader.data()) {
           case SERVER_INITIAL_ERROR_BAD_ADDRESS:
@@ -372,17 +459,9 @@ class Wizard {
             new command.Result(
               configInput.host,
               self.info,
-              self.controls.build({
-                charset: parsedConfig.charset,
-                tabColor: configInput.tabColor,
-                send(data) {
-                  return commandHandler.sendData(data);
-                },
-                close() {
-                  return commandHandler.sendClose();
-                },
-                events: commandHandler.events,
-              }),
+              self.controls.build(
+                self.controlData(configInput, commandHandler),
+              ),
               self.controls.ui(),
             ),
           ),
@@ -402,7 +481,6 @@ class Wizard {
           message = strings.toString(read.buffer, "utf-8");
         self.step.resolve(self.stepErrorDone("Connection failed", message));
       },
-      "@inband"(rd) {},
       close() {},
       "@completed"() {},
     });
@@ -0,0 +1,451 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import * as reader from "../stream/reader.js";
+import * as command from "./commands.js";
+import Exception from "./exception.js";
+import * as history from "./history.js";
+import * as presets from "./presets.js";
+import * as strings from "./string.js";
+import * as tcp from "./tcp.js";
+
+const COMMAND_ID = 0x05;
+
+const SERVER_DIAL_CONNECTED = 0x03;
+const SERVER_CONNECTION_INFO = 0x04;
+const SERVER_PEER_CERTIFICATE = 0x05;
+
+const CERTIFICATE_FINGERPRINT_LENGTH = 32;
+
+const HostMaxSearchResults = 3;
+
+const versionNames = {
+  0x0301: "TLS 1.0",
+  0x0302: "TLS 1.1",
+  0x0303: "TLS 1.2",
+  0x0304: "TLS 1.3",
+};
+
+const cipherNames = {
+  0x002f: "TLS_RSA_WITH_AES_128_CBC_SHA",
+  0x0035: "TLS_RSA_WITH_AES_256_CBC_SHA",
+  0x009c: "TLS_RSA_WITH_AES_128_GCM_SHA256",
+  0x009d: "TLS_RSA_WITH_AES_256_GCM_SHA384",
+  0x1301: "TLS_AES_128_GCM_SHA256",
+  0x1302: "TLS_AES_256_GCM_SHA384",
+  0x1303: "TLS_CHACHA20_POLY1305_SHA256",
+  0xc009: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+  0xc00a: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+  0xc013: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+  0xc014: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+  0xc02b: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+  0xc02c: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+  0xc02f: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+  0xc030: "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+  0xcca8: "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+  0xcca9: "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+};
+
+/**
+ * Return the readable name of an IANA assigned value
+ *
+ * @param {object} names Known names
+ * @param {number} v The value
+ *
+ * @returns {string} The name
+ *
+ */
+function nameOf(names, v) {
+  if (names[v]) {
+    return names[v];
+  }
+  return "0x" + v.toString(16).padStart(4, "0");
+}
+
+/**
+ * Read a String as text
+ *
+ * @param {reader.Limited} rd Data reader
+ *
+ * @returns {string} The text
+ *
+ */
+async function readText(rd) {
+  return strings.toString((await strings.String.read(rd)).data(), "utf-8");
+}
+
+/**
+ * Read a Unix timestamp
+ *
+ * @param {Uint8Array} b Data
+ * @param {number} start Where the timestamp starts
+ *
+ * @returns {Date} The time
+ *
+ */
+function getTime(b, start) {
+  const d = new DataView(b.buffer, b.byteOffset, b.byteLength);
+  return new Date(
+    (d.getInt32(start) * 0x100000000 + d.getUint32(start + 4)) * 1000,
+  );
+}
+
+/**
+ * Read the connection info and format it into lines
+ *
+ * @param {reader.Limited} rd Data reader
+ *
+ * @returns {Array<string>} Lines of the info
+ *
+ */
+async function readConnectionInfo(rd) {
+  const d = await reader.readN(rd, 4),
+    protocol = await readText(rd),
+    serverName = await readText(rd),
+    verifyError = await readText(rd),
+    chain = await reader.readOne(rd);
+  return [
+    "Version:     " + nameOf(versionNames, (d[0] << 8) | d[1]),
+    "Cipher:      " + nameOf(cipherNames, (d[2] << 8) | d[3]),
+    "ALPN:        " + (protocol.length > 0 ? protocol : "(none)"),
+    "Server Name: " + serverName,
+    "Verified:    " +
+      (verifyError.length > 0 ? "No, " + verifyError : "Yes"),
+    "Chain:       " + chain[0] + " certificate(s)",
+  ];
+}
+
+/**
+ * Read a peer certificate and format it into lines
+ *
+ * @param {reader.Limited} rd Data reader
+ *
+ * @returns {Array<string>} Lines of the certificate
+ *
+ */
+async function readPeerCertificate(rd) {
+  const index = await reader.readOne(rd),
+    subject = await readText(rd),
+    issuer = await readText(rd),
+    d = await reader.readN(rd, 16 + CERTIFICATE_FINGERPRINT_LENGTH),
+    names = await strings.parseStrings(rd),
+    notBefore = getTime(d, 0),
+    notAfter = getTime(d, 8),
+    now = new Date();
+  let fingerprint = [];
+  for (let i = 16; i < d.length; i++) {
+    fingerprint.push(d[i].toString(16).padStart(2, "0").toUpperCase());
+  }
+  let validity = "";
+  if (now < notBefore) {
+    validity = " (not yet valid)";
+  } else if (now > notAfter) {
+    validity = " (expired)";
+  }
+  return [
+    "",
+    "Certificate #" + index[0],
+    "  Subject:    " + subject,
+    "  Issuer:     " + issuer,
+    "  Not Before: " + notBefore.toUTCString(),
+    "  Not After:  " + notAfter.toUTCString() + validity,
+    "  SHA256:     " + fingerprint.join(":"),
+    "  Names:      " +
+      names.map((n) => strings.toString(n.data(), "utf-8")).join(", "),
+  ];
+}
+
+// TLS reports the details of the handshake before the connection is
+// established, they're collected and displayed once the console is ready
+class TLS extends tcp.TCP {
+  constructor(sd, config, callbacks) {
+    super(sd, config, callbacks);
+    this.info = [];
+  }
+
+  connectedEvents() {
+    return ["@inband", "@message"];
+  }
+
+  async tick(streamHeader, rd) {
+    switch (streamHeader.marker()) {
+      case SERVER_CONNECTION_INFO:
+        if (!this.connected) {
+          this.info.push(...(await readConnectionInfo(rd)));
+          return;
+        }
+        break;
+      case SERVER_PEER_CERTIFICATE:
+        if (!this.connected) {
+          this.info.push(...(await readPeerCertificate(rd)));
+          return;
+        }
+        break;
+      case SERVER_DIAL_CONNECTED:
+        if (!this.connected) {
+          const r = await super.tick(streamHeader, rd);
+          this.events.fire("message", this.info.join("\n"));
+          this.info = [];
+          return r;
+        }
+        break;
+    }
+    return super.tick(streamHeader, rd);
+  }
+}
+
+const initialFieldDef = {
+  ...tcp.initialFieldDef,
+  Host: {
+    ...tcp.initialFieldDef.Host,
+    description:
+      "The remote to perform the TLS handshake with. The server name, ALPN " +
+      "and client certificate can be defined by a Preset",
+    example: "nirui.org:443",
+  },
+};
+
+class Wizard extends tcp.Wizard {
+  controlType() {
+    return "TLS";
+  }
+
+  newCommand(sender, config, callbacks) {
+    return new TLS(sender, config, callbacks);
+  }
+
+  connectedCallbacks() {
+    return {
+      "@inband"(rd) {},
+      "@message"(msg) {},
+    };
+  }
+
+  stepInitialPrompt() {
+    const self = this;
+    return command.prompt(
+      "TLS",
+      "TLS connection",
+      "Connect",
+      (r) => {
+        self.hasStarted = true;
+        self.streams.request(COMMAND_ID, (sd) => {
+          return self.buildCommand(
+            sd,
+            {
+              host: r.host,
+              charset: r.encoding,
+              tabColor: self.preset ? self.preset.tabColor() : "",
+            },
+            self.session,
+          );
+        });
+        self.step.resolve(self.stepWaitForAcceptWait());
+      },
+      () => {},
+      command.fieldsWithPreset(
+        initialFieldDef,
+        [
+          {
+            name: "Host",
+            suggestions(input) {
+              const hosts = self.history.search(
+                "TLS",
+                "host",
+                input,
+                HostMaxSearchResults,
+              );
+              let sugg = [];
+              for (let i = 0; i < hosts.length; i++) {
+                sugg.push({
+                  title: hosts[i].title,
+                  value: hosts[i].data.host,
+                  meta: {
+                    Encoding: hosts[i].data.charset,
+                  },
+                });
+              }
+              return sugg;
+            },
+          },
+          { name: "Encoding" },
+        ],
+        self.preset,
+        (r) => {},
+      ),
+    );
+  }
+}
+
+class Executor extends Wizard {
+  /**
+   * constructor
+   *
+   * @param {command.Info} info
+   * @param {object} config
+   * @param {object} session
+   * @param {Array<string>} keptSessions
+   * @param {streams.Streams} streams
+   * @param {subscribe.Subscribe} subs
+   * @param {controls.Controls} controls
+   * @param {history.History} history
+   *
+   */
+  constructor(
+    info,
+    config,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    super(
+      info,
+      presets.emptyPreset(),
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+    this.config = config;
+  }
+
+  stepInitialPrompt() {
+    const self = this;
+    self.hasStarted = true;
+    self.streams.request(COMMAND_ID, (sd) => {
+      return self.buildCommand(
+        sd,
+        {
+          host: self.config.host,
+          charset: self.config.charset ? self.config.charset : "utf-8",
+          tabColor: self.config.tabColor ? self.config.tabColor : "",
+        },
+        self.session,
+      );
+    });
+    return self.stepWaitForAcceptWait();
+  }
+}
+
+export class Command {
+  constructor() {}
+
+  id() {
+    return COMMAND_ID;
+  }
+
+  name() {
+    return "TLS";
+  }
+
+  description() {
+    return "TLS connection";
+  }
+
+  color() {
+    return "#c6a";
+  }
+
+  wizard(
+    info,
+    preset,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    return new Wizard(
+      info,
+      preset,
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  execute(
+    info,
+    config,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    return new Executor(
+      info,
+      config,
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  launch(info, launcher, streams, subs, controls, history) {
+    const d = launcher.split("|", 2);
+    if (d.length < 2) {
+      throw new Exception('Given launcher "' + launcher + '" was invalid');
+    }
+    try {
+      initialFieldDef["Host"].verify(d[0]);
+      initialFieldDef["Encoding"].verify(d[1]);
+    } catch (e) {
+      throw new Exception(
+        'Given launcher "' + launcher + '" was invalid: ' + e,
+      );
+    }
+    return this.execute(
+      info,
+      {
+        host: d[0],
+        charset: d[1],
+      },
+      null,
+      null,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  launcher(config) {
+    return config.host + "|" + (config.charset ? config.charset : "utf-8");
+  }
+
+  represet(preset) {
+    const host = preset.host();
+    if (host.length > 0) {
+      preset.insertMeta("Host", host);
+    }
+    return preset;
+  }
+}
@@ -0,0 +1,163 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import * as color from "../commands/color.js";
+import * as common from "../commands/common.js";
+import * as reader from "../stream/reader.js";
+import * as subscribe from "../stream/subscribe.js";
+import * as iconvDecoder from "../iconv/decoder.js";
+import * as iconvEncoder from "../iconv/encoder.js";
+
+// Control relays the data of a remote which has no terminal semantics, such as
+// a TCP socket. Like a terminal in canonical mode, the input is echoed and
+// edited locally, and only sent to the remote line by line. Line breaks are
+// converted to CRLF in both directions, as most of the line based protocols
+// expect
+class Control {
+  constructor(data, color) {
+    this.background = color;
+    this.charset = data.charset;
+    this.sender = data.send;
+    this.closer = data.close;
+    this.closed = false;
+    this.line = "";
+    this.subs = new subscribe.Subscribe();
+    let self = this;
+    this.charsetEncoder = new iconvEncoder.IconvEncoder(
+      (o) => self.sender(o),
+      this.charset,
+    );
+    let charsetDecoder = new iconvDecoder.IconvDecoder(
+      (o) => self.subs.resolve(o.replace(/\r?\n/g, "\r\n")),
+      this.charset,
+    );
+    data.events.place("inband", async (rd) => {
+      try {
+        charsetDecoder.write(await reader.readCompletely(rd));
+      } catch (e) {
+        // Do nothing
+      }
+    });
+    if (data.events.placeable("message")) {
+      data.events.place("message", (msg) => {
+        if (msg.length <= 0) {
+          return;
+        }
+        self.subs.resolve(msg.replace(/\r?\n/g, "\r\n") + "\r\n");
+      });
+    }
+    data.events.place("completed", () => {
+      self.closed = true;
+      self.background.forget();
+      self.charsetEncoder.close();
+      charsetDecoder.close();
+      self.subs.reject("Remote connection has been terminated");
+    });
+  }
+
+  echo() {
+    return false;
+  }
+
+  resize(dim) {}
+
+  enabled() {}
+
+  disabled() {}
+
+  retap(isOn) {}
+
+  receive() {
+    return this.subs.subscribe();
+  }
+
+  send(data) {
+    // Escape sequences such as the ones of the arrow keys are not supported
+    if (this.closed || data.startsWith("\x1b")) {
+      return;
+    }
+    for (let i = 0; i < data.length; i++) {
+      const c = data[i];
+      switch (c) {
+        case "\r": {
+          const line = this.line;
+          this.line = "";
+          this.subs.resolve("\r\n");
+          this.charsetEncoder.write(line + "\r\n");
+          continue;
+        }
+        case "\x7f":
+        case "\b":
+          if (this.line.length > 0) {
+            this.line = this.line.substring(0, this.line.length - 1);
+            this.subs.resolve("\b \b");
+          }
+          continue;
+      }
+      if (c < " " && c !== "\t") {
+        continue;
+      }
+      this.line += c;
+      this.subs.resolve(c);
+    }
+  }
+
+  sendBinary(data) {
+    if (this.closed) {
+      return;
+    }
+    return this.sender(common.strToBinary(data));
+  }
+
+  color() {
+    return this.background.hex();
+  }
+
+  close() {
+    if (this.closer === null) {
+      return;
+    }
+    let cc = this.closer;
+    this.closer = null;
+    return cc();
+  }
+}
+
+export class Raw {
+  /**
+   * constructor
+   *
+   * @param {string} type Type of the control
+   * @param {color.Colors} c
+   */
+  constructor(type, c) {
+    this.controlType = type;
+    this.colors = c;
+  }
+
+  type() {
+    return this.controlType;
+  }
+
+  ui() {
+    return "Console";
+  }
+
+  build(data) {
+    return new Control(data, this.colors.get(data.tabColor));
+  }
+}
@@ -0,0 +1,167 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import * as color from "../commands/color.js";
+import * as telnet from "./telnet.js";
+
+// Ctrl+], the same escape character as the one of the telnet utility
+const MENU_ESCAPE = "\x1d";
+const MENU_PROMPT = "serial> ";
+const MENU_HELP =
+  "Commands:\r\n" +
+  "  break [ms]    Send BREAK, for 250ms unless specified\r\n" +
+  "  baud <rate>   Set baud rate, i.e. 115200\r\n" +
+  "  data <bits>   Set data bits: 5, 6, 7, 8\r\n" +
+  "  parity <p>    Set parity: none, odd, even, mark, space\r\n" +
+  "  stop <bits>   Set stop bits: 1, 1.5, 2\r\n" +
+  "  flow <f>      Set flow control: none, xon/xoff, hardware\r\n" +
+  "  help          Display this message\r\n" +
+  "Leave the line empty to return to the remote\r\n";
+
+// Control works just like the Telnet one, except that a local menu can be
+// opened with the escape character to send BREAK or change the line settings
+// of the serial port
+class Control extends telnet.Control {
+  constructor(data, color) {
+    super(data, color);
+    this.lineSetter = data.setLine;
+    this.breakSender = data.sendBreak;
+    this.menu = null;
+    let self = this;
+    data.events.place("message", (msg) => {
+      if (msg.length <= 0) {
+        return;
+      }
+      self.subs.resolve("\r\n[" + msg + "]\r\n");
+    });
+    this.subs.resolve(
+      "[Press Ctrl+] to send BREAK or change the line settings]\r\n",
+    );
+  }
+
+  echo() {
+    return this.menu === null && super.echo();
+  }
+
+  send(data) {
+    if (this.closed) {
+      return;
+    }
+    if (this.menu === null) {
+      const esc = data.indexOf(MENU_ESCAPE);
+      if (esc < 0) {
+        return super.send(data);
+      }
+      if (esc > 0) {
+        super.send(data.substring(0, esc));
+      }
+      this.menu = "";
+      this.subs.resolve("\r\n" + MENU_PROMPT);
+      data = data.substring(esc + 1);
+    }
+    for (let i = 0; i < data.length; i++) {
+      const c = data[i];
+      switch (c) {
+        case "\r": {
+          const line = this.menu;
+          this.menu = null;
+          this.subs.resolve("\r\n");
+          this.runMenu(line.trim());
+          return this.send(data.substring(i + 1));
+        }
+        case "\x03":
+        case MENU_ESCAPE:
+          this.menu = null;
+          this.subs.resolve("\r\n");
+          return this.send(data.substring(i + 1));
+        case "\x7f":
+        case "\b":
+          if (this.menu.length > 0) {
+            this.menu = this.menu.substring(0, this.menu.length - 1);
+            this.subs.resolve("\b \b");
+          }
+          continue;
+      }
+      if (c < " ") {
+        continue;
+      }
+      this.menu += c;
+      this.subs.resolve(c);
+    }
+  }
+
+  runMenu(line) {
+    if (line.length <= 0) {
+      return;
+    }
+    const args = line.split(/\s+/),
+      cmd = args[0].toLowerCase();
+    try {
+      switch (cmd) {
+        case "help":
+          this.subs.resolve(MENU_HELP);
+          return;
+        case "break": {
+          const length = args.length > 1 ? parseInt(args[1], 10) : 0;
+          if (isNaN(length) || length < 0) {
+            throw new Error("BREAK length must be a positive integer");
+          }
+          this.breakSender(length);
+          return;
+        }
+      }
+      if (args.length < 2) {
+        throw new Error(
+          'Unknown command "' + line + '", type "help" for help',
+        );
+      }
+      this.lineSetter(cmd, args[1].toLowerCase());
+    } catch (e) {
+      this.subs.resolve(e.message + "\r\n");
+    }
+  }
+
+  sendBinary(data) {
+    if (this.menu !== null) {
+      return;
+    }
+    return super.sendBinary(data);
+  }
+}
+
+export class Serial {
+  /**
+   * constructor
+   *
+   * @param {color.Colors} c
+   */
+  constructor(c) {
+    this.colors = c;
+  }
+
+  type() {
+    return "Serial";
+  }
+
+  ui() {
+    return "Console";
+  }
+
+  build(data) {
+    return new Control(data, this.colors.get(data.tabColor));
+  }
+}
@@ -0,0 +1,493 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2026 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import * as color from "../commands/color.js";
+import * as sftp from "../commands/sftp.js";
+import * as subscribe from "../stream/subscribe.js";
+
+const HOME_DIR = ".";
+
+const HIDDEN_ELEMENT_STYLE =
+  "overflow: hidden; opacity: 0; width: 1px; height: 1px; top: -1px;" +
+  "left: -1px; position: absolute;";
+
+const HELP = [
+  "help                  Show this help",
+  "pwd                   Print the working directory",
+  "cd [dir]              Change the working directory",
+  "ls [dir]              List the directory",
+  "stat <path>           Show the info of the file",
+  "get <file>            Download the file",
+  "put [file]            Upload a local file, to the file if given",
+  "mv <path> <new path>  Rename the file",
+  "rm <file>             Delete the file",
+  "exit                  Close the session",
+  "",
+  "Paths are relative to the working directory. Use quotes for the paths " +
+    "that contain spaces, and press Ctrl+C to cancel a transfer",
+];
+
+const FILE_TYPE_CHARS = {
+  [sftp.FILE_TYPE_REGULAR]: "-",
+  [sftp.FILE_TYPE_DIRECTORY]: "d",
+  [sftp.FILE_TYPE_SYMLINK]: "l",
+  [sftp.FILE_TYPE_OTHER]: "?",
+};
+
+/**
+ * Join `path` to `dir` and clean the result. Relative paths are relative to
+ * the directory that the remote starts in, which is unknown to the client, so
+ * their leading ".." are kept as is
+ *
+ * @param {string} dir The directory
+ * @param {string} path The path
+ *
+ * @returns {string} The joined path
+ *
+ */
+function joinPath(dir, path) {
+  const full = path.startsWith("/") ? path : dir + "/" + path,
+    absolute = full.startsWith("/"),
+    segs = full.split("/");
+  let result = [];
+  for (let i = 0; i < segs.length; i++) {
+    if (segs[i].length <= 0 || segs[i] === ".") {
+      continue;
+    }
+    if (segs[i] !== "..") {
+      result.push(segs[i]);
+    } else if (result.length > 0 && result[result.length - 1] !== "..") {
+      result.pop();
+    } else if (!absolute) {
+      result.push(segs[i]);
+    }
+  }
+  if (absolute) {
+    return "/" + result.join("/");
+  }
+  return result.length > 0 ? result.join("/") : HOME_DIR;
+}
+
+/**
+ * Return the last element of the path
+ *
+ * @param {string} path The path
+ *
+ * @returns {string} The name
+ *
+ */
+function baseName(path) {
+  const segs = path.split("/");
+  return segs[segs.length - 1];
+}
+
+/**
+ * Split the command line into arguments
+ *
+ * @param {string} line The command line
+ *
+ * @returns {Array<string>} The arguments
+ *
+ */
+function parseArgs(line) {
+  let args = [],
+    arg = null,
+    quote = "";
+  for (let i = 0; i < line.length; i++) {
+    const c = line[i];
+    if (quote.length > 0) {
+      if (c === quote) {
+        quote = "";
+      } else {
+        arg += c;
+      }
+      continue;
+    }
+    switch (c) {
+      case '"':
+      case "'":
+        quote = c;
+        arg = arg === null ? "" : arg;
+        continue;
+      case " ":
+      case "\t":
+        if (arg !== null) {
+          args.push(arg);
+          arg = null;
+        }
+        continue;
+    }
+    arg = arg === null ? c : arg + c;
+  }
+  if (arg !== null) {
+    args.push(arg);
+  }
+  return args;
+}
+
+/**
+ * Format the file info in the way of "ls -l"
+ *
+ * @param {object} info The file info
+ * @param {string} name Name to display
+ *
+ * @returns {string} The formatted file info
+ *
+ */
+function formatFileInfo(info, name) {
+  const rwx = "rwxrwxrwx";
+  let mode = FILE_TYPE_CHARS[info.type] ? FILE_TYPE_CHARS[info.type] : "?";
+  for (let i = 0; i < rwx.length; i++) {
+    mode += info.permissions & (1 << (rwx.length - 1 - i)) ? rwx[i] : "-";
+  }
+  return (
+    mode +
+    " " +
+    ("" + info.size).padStart(12) +
+    " " +
+    info.modified.toISOString().substring(0, 16).replace("T", " ") +
+    " " +
+    name
+  );
+}
+
+/**
+ * Let the user pick a local file
+ *
+ * @returns {Promise<File>} The file, or null when nothing was picked
+ *
+ */
+function pickFile() {
+  return new Promise((resolve, reject) => {
+    let el = null;
+    try {
+      el = document.createElement("input");
+      el.setAttribute("type", "file");
+      el.setAttribute("style", HIDDEN_ELEMENT_STYLE);
+      el.addEventListener("change", (ev) => {
+        resolve(ev.target.files.length > 0 ? ev.target.files[0] : null);
+      });
+      el.addEventListener("cancel", () => {
+        resolve(null);
+      });
+      document.body.appendChild(el);
+      el.click();
+    } catch (e) {
+      reject(e);
+    }
+    if (el === null) {
+      return;
+    }
+    document.body.removeChild(el);
+  });
+}
+
+/**
+ * Save the data as a local file
+ *
+ * @param {string} name Name of the file
+ * @param {Array<Uint8Array>} data The data
+ *
+ */
+function saveFile(name, data) {
+  const url = URL.createObjectURL(new Blob(data));
+  let el = null;
+  try {
+    el = document.createElement("a");
+    el.setAttribute("href", url);
+    el.setAttribute("download", name);
+    el.setAttribute("style", HIDDEN_ELEMENT_STYLE);
+    document.body.appendChild(el);
+    el.click();
+  } finally {
+    if (el !== null) {
+      document.body.removeChild(el);
+    }
+    setTimeout(() => URL.revokeObjectURL(url), 0);
+  }
+}
+
+// Control is a simple shell which runs commands like the ones of the sftp
+// command line client. The input is echoed and edited locally, only the
+// finished commands are sent to the remote
+class Control {
+  constructor(data, color) {
+    this.background = color;
+    this.sftp = data.sftp;
+    this.closer = data.close;
+    this.closed = false;
+    this.subs = new subscribe.Subscribe();
+    this.dir = HOME_DIR;
+    this.line = "";
+    this.running = false;
+    this.cancelled = false;
+    let self = this;
+    data.events.place("message", (msg) => {
+      if (msg.length <= 0) {
+        return;
+      }
+      self.print(msg);
+    });
+    data.events.place("completed", () => {
+      self.closed = true;
+      self.background.forget();
+      self.subs.reject("Remote connection has been terminated");
+    });
+    this.write('Type "help" to see the available commands\r\n');
+    this.write(this.prompt());
+  }
+
+  displayDir() {
+    if (this.dir === HOME_DIR) {
+      return "~";
+    }
+    return this.dir.startsWith("/") ? this.dir : "~/" + this.dir;
+  }
+
+  prompt() {
+    return "sftp:" + this.displayDir() + "> ";
+  }
+
+  write(s) {
+    this.subs.resolve(s);
+  }
+
+  println(s) {
+    this.write(s.replace(/\r?\n/g, "\r\n") + "\r\n");
+  }
+
+  print(s) {
+    if (this.running) {
+      this.println(s);
+      return;
+    }
+    // Clear the prompt, then redraw it after the message
+    this.write("\r\x1b[2K");
+    this.println(s);
+    this.write(this.prompt() + this.line);
+  }
+
+  path(args, i) {
+    if (args.length <= i) {
+      throw new Error('missing operand, see "help" for usage');
+    }
+    return joinPath(this.dir, args[i]);
+  }
+
+  async get(path) {
+    const info = await this.sftp.stat(path);
+    if (info.type === sftp.FILE_TYPE_DIRECTORY) {
+      throw new Error(path + " is a directory");
+    }
+    let data = [],
+      offset = 0;
+    for (;;) {
+      if (this.cancelled) {
+        throw new Error("cancelled");
+      }
+      this.write("\rDownloading: " + offset + " of " + info.size + " bytes");
+      const d = await this.sftp.read(path, offset, sftp.MAX_TRANSFER_LENGTH);
+      if (d.length <= 0) {
+        break;
+      }
+      data.push(d);
+      offset += d.length;
+    }
+    this.write("\r\n");
+    saveFile(baseName(path), data);
+  }
+
+  async put(args) {
+    const file = await pickFile();
+    if (file === null) {
+      return;
+    }
+    const path =
+      args.length > 1 ? this.path(args, 1) : joinPath(this.dir, file.name);
+    let offset = 0;
+    do {
+      if (this.cancelled) {
+        throw new Error("cancelled");
+      }
+      this.write("\rUploading: " + offset + " of " + file.size + " bytes");
+      const chunk = file.slice(offset, offset + sftp.MAX_TRANSFER_LENGTH),
+        d = new Uint8Array(await chunk.arrayBuffer());
+      await this.sftp.write(path, offset, offset === 0, d);
+      offset += d.length;
+    } while (offset < file.size);
+    this.write("\r\n");
+  }
+
+  async runCommand(args) {
+    switch (args[0]) {
+      case "help":
+        return this.println(HELP.join("\n"));
+      case "pwd":
+        return this.println(this.displayDir());
+      case "cd": {
+        const path = args.length > 1 ? this.path(args, 1) : HOME_DIR,
+          info = await this.sftp.stat(path);
+        if (info.type !== sftp.FILE_TYPE_DIRECTORY) {
+          throw new Error(path + " is not a directory");
+        }
+        this.dir = path;
+        return;
+      }
+      case "ls": {
+        const infos = await this.sftp.list(
+          args.length > 1 ? this.path(args, 1) : this.dir,
+        );
+        infos.sort((a, b) => (a.name < b.name ? -1 : a.name > b.name ? 1 : 0));
+        for (let i = 0; i < infos.length; i++) {
+          this.println(formatFileInfo(infos[i], infos[i].name));
+        }
+        return;
+      }
+      case "stat": {
+        const path = this.path(args, 1);
+        return this.println(formatFileInfo(await this.sftp.stat(path), path));
+      }
+      case "get":
+        return this.get(this.path(args, 1));
+      case "put":
+        return this.put(args);
+      case "mv":
+        return this.sftp.rename(this.path(args, 1), this.path(args, 2));
+      case "rm":
+        return this.sftp.delete(this.path(args, 1));
+      case "exit":
+        return this.close();
+    }
+    throw new Error('unknown command, see "help" for available commands');
+  }
+
+  async execute(line) {
+    const args = parseArgs(line);
+    if (args.length > 0) {
+      this.running = true;
+      this.cancelled = false;
+      try {
+        await this.runCommand(args);
+      } catch (e) {
+        this.write("\r\x1b[2K");
+        this.println(args[0] + ": " + (e instanceof Error ? e.message : e));
+      }
+      this.running = false;
+    }
+    if (this.closed || this.closer === null) {
+      return;
+    }
+    this.write(this.prompt());
+  }
+
+  echo() {
+    return false;
+  }
+
+  resize(dim) {}
+
+  enabled() {}
+
+  disabled() {}
+
+  retap(isOn) {}
+
+  receive() {
+    return this.subs.subscribe();
+  }
+
+  send(data) {
+    // Escape sequences such as the ones of the arrow keys are not supported
+    if (this.closed || data.startsWith("\x1b")) {
+      return;
+    }
+    for (let i = 0; i < data.length; i++) {
+      const c = data[i];
+      if (c === "\x03") {
+        if (this.running) {
+          this.cancelled = true;
+          continue;
+        }
+        this.line = "";
+        this.write("^C\r\n" + this.prompt());
+        continue;
+      }
+      if (this.running) {
+        continue;
+      }
+      switch (c) {
+        case "\r": {
+          const line = this.line;
+          this.line = "";
+          this.write("\r\n");
+          this.execute(line);
+          continue;
+        }
+        case "\x7f":
+        case "\b":
+          if (this.line.length > 0) {
+            this.line = this.line.substring(0, this.line.length - 1);
+            this.write("\b \b");
+          }
+          continue;
+      }
+      if (c < " ") {
+        continue;
+      }
+      this.line += c;
+      this.write(c);
+    }
+  }
+
+  sendBinary(data) {}
+
+  color() {
+    return this.background.hex();
+  }
+
+  close() {
+    if (this.closer === null) {
+      return;
+    }
+    let cc = this.closer;
+    this.closer = null;
+    return cc();
+  }
+}
+
+export class SFTP {
+  /**
+   * constructor
+   *
+   * @param {color.Colors} c
+   */
+  constructor(c) {
+    this.colors = c;
+  }
+
+  type() {
+    return "SFTP";
+  }
+
+  ui() {
+    return "Console";
+  }
+
+  build(data) {
+    return new Control(data, this.colors.get(data.tabColor));
+  }
+}
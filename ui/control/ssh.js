@@ -38,7 +38,8 @@ class Control {
       this.charset,
     );
     let charsetDecoder = new iconvDecoder.IconvDecoder(
-      (o) => self.subs.resolve(o),
+      (o) =>
+        self.subs.resolve(data.convertEol ? o.replace(/\r?\n/g, "\r\n") : o),
       this.charset,
     );
     data.events.place("stdout", async (rd) => {
@@ -48,12 +49,22 @@ class Control {
         // Do nothing
       }
     });
-    data.events.place("stderr", async (rd) => {
-      try {
-        charsetDecoder.write(await reader.readCompletely(rd));
-      } catch (e) {
-        // Do nothing
+    // Not every terminal has a separate stderr, i.e. the ones of a pseudo
+    // terminal
+    if (data.events.placeable("stderr")) {
+      data.events.place("stderr", async (rd) => {
+        try {
+          charsetDecoder.write(await reader.readCompletely(rd));
+        } catch (e) {
+          // Do nothing
+        }
+      });
+    }
+    data.events.place("message", (msg) => {
+      if (msg.length <= 0) {
+        return;
       }
+      self.subs.resolve("\r\n" + msg.replace(/\r?\n/g, "\r\n") + "\r\n");
     });
     data.events.place("completed", () => {
       self.closed = true;
@@ -139,3 +150,11 @@ export class SSH {
     return new Control(data, this.colors.get(data.tabColor));
   }
 }
+
+// LocalShell is the control of the commands that run in a pseudo terminal on
+// the backend host, which behave just like a SSH shell
+export class LocalShell extends SSH {
+  type() {
+    return "Local Shell";
+  }
+}
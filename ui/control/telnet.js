@@ -44,24 +44,13 @@ const cmdIAC = 255;
 
 const optEcho = 1;
 const optSuppressGoAhead = 3;
-const optTerminalType = 24;
-const optNAWS = 31;
-
-const optTerminalTypeIs = 0;
-const optTerminalTypeSend = 1;
-
-const unknownTermTypeSendData = new Uint8Array([
-  optTerminalTypeIs,
-  88,
-  84,
-  69,
-  82,
-  77,
-]);
 
 // Most of code of this class is directly from
 // https://github.com/ziutek/telnet/blob/master/conn.go#L122
 // Thank you!
+//
+// Options are negotiated by the backend, which only forwards the changes of
+// the ECHO option so the local echo can be turned on and off accordingly
 class Parser {
   constructor(sender, flusher, callbacks) {
     this.sender = sender;
@@ -71,7 +60,6 @@ class Parser {
     this.options = {
       echoEnabled: false,
       suppressGoAhead: false,
-      nawsAccpeted: false,
     };
     this.current = 0;
   }
@@ -89,53 +77,16 @@ class Parser {
     }
   }
 
-  sendWillSubNego(willCmd, data, option) {
-    let b = new Uint8Array(6 + data.length + 2);
-    b.set([cmdIAC, willCmd, option, cmdIAC, cmdSB, option], 0);
-    b.set(data, 6);
-    b.set([cmdIAC, cmdSE], data.length + 6);
-    return this.sender(b);
-  }
-
-  sendSubNego(data, option) {
-    let b = new Uint8Array(3 + data.length + 2);
-    b.set([cmdIAC, cmdSB, option], 0);
-    b.set(data, 3);
-    b.set([cmdIAC, cmdSE], data.length + 3);
-    return this.sender(b);
-  }
-
-  async handleTermTypeSubNego(rd) {
-    let action = await reader.readOne(rd);
-    if (action[0] !== optTerminalTypeSend) {
-      return null;
-    }
-    let self = this;
-    return () => {
-      self.sendSubNego(unknownTermTypeSendData, optTerminalType);
-    };
-  }
-
   async handleSubNego(rd) {
-    let endExec = null;
     for (;;) {
       let d = await reader.readOne(rd);
-      switch (d[0]) {
-        case optTerminalType:
-          endExec = await this.handleTermTypeSubNego(rd);
-          continue;
-        case cmdIAC:
-          break;
-        default:
-          continue;
+      if (d[0] !== cmdIAC) {
+        continue;
       }
       let e = await reader.readOne(rd);
       if (e[0] !== cmdSE) {
         continue;
       }
-      if (endExec !== null) {
-        endExec();
-      }
       return;
     }
   }
@@ -218,42 +169,10 @@ class Parser {
             this.options.suppressGoAhead = d;
           },
         );
-      case optNAWS:
-        // Window resize allowed?
-        if (d[0] !== cmdDo) {
-          this.sendDeny(d[0], o[0]);
-          return;
-        }
-        {
-          let dim = this.callbacks.getWindowDim(),
-            dimData = new DataView(new ArrayBuffer(4));
-          dimData.setUint16(0, dim.cols);
-          dimData.setUint16(2, dim.rows);
-          let dimBytes = new Uint8Array(dimData.buffer);
-          if (this.options.nawsAccpeted) {
-            this.sendSubNego(dimBytes, optNAWS);
-            return;
-          }
-          this.options.nawsAccpeted = true;
-          this.sendWillSubNego(cmdWill, dimBytes, optNAWS);
-        }
-        return;
-      case optTerminalType:
-        if (d[0] !== cmdDo) {
-          this.sendDeny(d[0], o[0]);
-          return;
-        }
-        this.sendNego(cmdWill, o[0]);
-        return;
     }
     this.sendDeny(d[0], o[0]);
   }
 
-  requestWindowResize() {
-    this.options.nawsAccpeted = true;
-    this.sendNego(cmdWill, optNAWS);
-  }
-
   async run() {
     try {
       for (;;) {
@@ -281,20 +200,17 @@ class Parser {
   }
 }
 
-class Control {
+export class Control {
   constructor(data, color) {
     this.background = color;
     this.charset = data.charset;
     this.sender = data.send;
     this.closer = data.close;
+    this.resizer = data.resize;
     this.closed = false;
     this.localEchoEnabled = true;
     this.subs = new subscribe.Subscribe();
     this.enable = false;
-    this.windowDim = {
-      cols: 65535,
-      rows: 65535,
-    };
     let self = this;
     this.charsetEncoder = new iconvEncoder.IconvEncoder(
       (o) => self.sendSeg(o),
@@ -308,9 +224,6 @@ class Control {
       setEcho(newVal) {
         self.localEchoEnabled = newVal;
       },
-      getWindowDim() {
-        return self.windowDim;
-      },
     });
     let runWait = this.parser.run();
     data.events.place("inband", (rd) => {
@@ -339,9 +252,7 @@ class Control {
     if (this.closed) {
       return;
     }
-    this.windowDim.cols = dim.cols;
-    this.windowDim.rows = dim.rows;
-    this.parser.requestWindowResize();
+    this.resizer(dim.rows, dim.cols);
   }
 
   enabled() {